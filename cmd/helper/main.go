@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/go-courier/envconf"
+	"github.com/sirupsen/logrus"
+	"github.com/zj-open-source/helper/kvstorage"
+	kvredis "github.com/zj-open-source/helper/kvstorage/redis"
+	"github.com/zj-open-source/helper/redis"
+)
+
+const usage = `helper is a tool to help developer to improve code handling capabilities
+
+Usage:
+  helper kv migrate --from <redis endpoint> --to <redis endpoint> [flags]
+`
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] != "kv" || os.Args[2] != "migrate" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := kvMigrate(os.Args[3:]); err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+}
+
+func kvMigrate(args []string) error {
+	flags := flag.NewFlagSet("helper kv migrate", flag.ExitOnError)
+
+	from := flags.String("from", "", "source redis endpoint, e.g. redis://:password@127.0.0.1:6379?db=10")
+	to := flags.String("to", "", "target redis endpoint")
+	opt := kvstorage.MigrateOptions{}
+	flags.StringVar(&opt.Match, "match", "", "only copy keys matching the glob pattern")
+	flags.BoolVar(&opt.DryRun, "dry-run", false, "only count the keys which would be copied")
+	flags.IntVar(&opt.Rate, "rate", 0, "max keys copied per second, 0 means unlimited")
+	flags.BoolVar(&opt.Verify, "verify", false, "compare every copied key after copying")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		flags.Usage()
+		return fmt.Errorf("both --from and --to are required")
+	}
+
+	src, err := newRedisKVStorage(*from)
+	if err != nil {
+		return err
+	}
+	dst, err := newRedisKVStorage(*to)
+	if err != nil {
+		return err
+	}
+
+	// values are copied as they are stored, without decoding
+	opt.NewValue = func() interface{} {
+		return &json.RawMessage{}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	report, err := kvstorage.Migrate(ctx, src, dst, opt)
+	if report != nil {
+		fmt.Fprintf(os.Stdout, "scanned: %d, matched: %d, copied: %d, skipped: %d, verified: %d, failed: %d, mismatched: %d\n",
+			report.Scanned, report.Matched, report.Copied, report.Skipped, report.Verified, len(report.Failed), len(report.Mismatched))
+		for key, e := range report.Failed {
+			fmt.Fprintf(os.Stdout, "failed %s: %s\n", key, e)
+		}
+		for _, key := range report.Mismatched {
+			fmt.Fprintf(os.Stdout, "mismatched %s\n", key)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if report != nil && (len(report.Failed) > 0 || len(report.Mismatched) > 0) {
+		return fmt.Errorf("migration finished with failures")
+	}
+	return nil
+}
+
+func newRedisKVStorage(endpoint string) (*kvredis.RedisKVStorage, error) {
+	e, err := envconf.ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &redis.RedisEndpoint{Endpoint: *e}
+	r.Init()
+
+	return kvredis.NewRedisKVStorage(r), nil
+}
@@ -0,0 +1,308 @@
+package election
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+var errConflict = errors.New("election: lease changed while it was updated")
+
+// NewElection creates a candidate identified by id for the election name, id defaults to the host name
+func NewElection(op redis1.RedisOperator, name string, id string) *Election {
+	e := &Election{
+		op:   op,
+		Name: name,
+		ID:   id,
+	}
+	e.SetDefaults()
+	return e
+}
+
+// Election elects one leader among the candidates running Run for the same Name.
+// The leader holds a lease key which it renews, other candidates take it over once it expires.
+// Every election increments a fencing token, so the writes of a leader which lost its lease without knowing it
+// can be rejected by comparing tokens.
+type Election struct {
+	op   redis1.RedisOperator
+	Name string
+	// ID identifies the candidate, a random suffix is added so candidates with the same ID don't share the lease
+	ID string
+	// LeaseTTL is how long the lease lasts without renewal, and so how long a dead leader is waited for
+	LeaseTTL time.Duration
+	// RenewInterval is how often the leader renews the lease and the others try to acquire it
+	RenewInterval time.Duration
+	// OnElected is called in its own goroutine once the candidate is elected, ctx is canceled when leadership ends
+	OnElected func(ctx context.Context, token int64)
+	// OnRevoked is called after leadership ended and OnElected returned
+	OnRevoked func()
+	// OnError is called with the errors of acquiring and renewing the lease, by default they are logged
+	OnError func(err error)
+
+	value string
+
+	mu     sync.Mutex
+	token  int64
+	leader bool
+}
+
+func (e *Election) SetDefaults() {
+	if e.ID == "" {
+		e.ID, _ = os.Hostname()
+	}
+	if e.LeaseTTL == 0 {
+		e.LeaseTTL = 15 * time.Second
+	}
+	if e.RenewInterval == 0 {
+		e.RenewInterval = e.LeaseTTL / 3
+	}
+	if e.OnError == nil {
+		e.OnError = func(err error) {
+			logrus.Warnf("election %s: %s", e.Name, err)
+		}
+	}
+	if e.value == "" {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		e.value = e.ID + ":" + hex.EncodeToString(b)
+	}
+}
+
+func (e *Election) leaseKey() string {
+	return e.op.Prefix(fmt.Sprintf("election:{%s}:lease", e.Name))
+}
+
+func (e *Election) tokenKey() string {
+	return e.op.Prefix(fmt.Sprintf("election:{%s}:token", e.Name))
+}
+
+// IsLeader reports whether the candidate currently holds the lease
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Token returns the fencing token of the current leadership, 0 when not leader
+func (e *Election) Token() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.leader {
+		return 0
+	}
+	return e.token
+}
+
+// Leader returns the ID of the current leader, empty when there is none
+func (e *Election) Leader(ctx context.Context) (string, error) {
+	value, err := redis.String(e.op.ExecContext(ctx, redis1.Command("GET", e.leaseKey())))
+	if err != nil {
+		if err == redis.ErrNil {
+			return "", nil
+		}
+		return "", err
+	}
+	for i := len(value) - 1; i >= 0; i-- {
+		if value[i] == ':' {
+			return value[:i], nil
+		}
+	}
+	return value, nil
+}
+
+// Run campaigns until ctx is done, then steps down and releases the lease when it holds it
+func (e *Election) Run(ctx context.Context) error {
+	for {
+		token, err := e.acquire(ctx)
+		if err != nil && ctx.Err() == nil {
+			e.OnError(err)
+		}
+		if token > 0 {
+			e.lead(ctx, token)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(e.RenewInterval):
+		}
+	}
+}
+
+// lead runs OnElected and renews the lease until leadership is lost or ctx is done
+func (e *Election) lead(ctx context.Context, token int64) {
+	e.mu.Lock()
+	e.leader, e.token = true, token
+	e.mu.Unlock()
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if e.OnElected != nil {
+			e.OnElected(leaderCtx, token)
+		}
+	}()
+
+	// the lease is ours until deadline at least, as it was renewed no earlier than the request was sent
+	deadline := time.Now().Add(e.LeaseTTL)
+
+	ticker := time.NewTicker(e.RenewInterval)
+	defer ticker.Stop()
+
+renew:
+	for {
+		select {
+		case <-ctx.Done():
+			break renew
+		case <-ticker.C:
+			sentAt := time.Now()
+			held, err := e.renew(ctx)
+			if err == nil && !held {
+				e.OnError(errors.New("lease lost to another candidate"))
+				break renew
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					break renew
+				}
+				e.OnError(err)
+				// step down before the lease may expire, as another candidate may take it over then
+				if time.Now().Add(e.RenewInterval).After(deadline) {
+					e.OnError(errors.New("lease not renewed in time, stepping down"))
+					break renew
+				}
+				continue
+			}
+			deadline = sentAt.Add(e.LeaseTTL)
+		}
+	}
+
+	e.mu.Lock()
+	e.leader = false
+	e.mu.Unlock()
+
+	cancel()
+	<-done
+
+	// released even when ctx is done, so the others don't wait for the lease to expire
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), e.RenewInterval)
+	defer cancelRelease()
+	if err := e.release(releaseCtx); err != nil {
+		e.OnError(err)
+	}
+
+	if e.OnRevoked != nil {
+		e.OnRevoked()
+	}
+}
+
+// acquire takes the lease when it is free, and returns the new fencing token or 0 when another candidate holds it
+func (e *Election) acquire(ctx context.Context) (int64, error) {
+	var token int64
+
+	err := e.whenLease(ctx, func(value string, exists bool) bool {
+		return !exists
+	}, func(c redis1.Conn) error {
+		if err := c.Send("SET", e.leaseKey(), e.value, "PX", int64(e.LeaseTTL/time.Millisecond)); err != nil {
+			return err
+		}
+		return c.Send("INCR", e.tokenKey())
+	}, func(replies []interface{}) error {
+		var err error
+		token, err = redis.Int64(replies[1], nil)
+		return err
+	})
+
+	// another candidate was faster
+	if err == errNotMatched || err == errConflict {
+		return 0, nil
+	}
+	return token, err
+}
+
+// renew extends the lease, held is false when it isn't ours anymore
+func (e *Election) renew(ctx context.Context) (bool, error) {
+	err := e.whenLease(ctx, func(value string, exists bool) bool {
+		return value == e.value
+	}, func(c redis1.Conn) error {
+		return c.Send("PEXPIRE", e.leaseKey(), int64(e.LeaseTTL/time.Millisecond))
+	}, nil)
+
+	if err == errNotMatched {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (e *Election) release(ctx context.Context) error {
+	err := e.whenLease(ctx, func(value string, exists bool) bool {
+		return value == e.value
+	}, func(c redis1.Conn) error {
+		return c.Send("DEL", e.leaseKey())
+	}, nil)
+
+	if err == errNotMatched {
+		return nil
+	}
+	return err
+}
+
+var errNotMatched = errors.New("election: lease not matched")
+
+// whenLease runs the commands sent by then in a transaction when match reports true for the current lease,
+// the lease is watched so the transaction fails when the lease changes in between
+func (e *Election) whenLease(
+	ctx context.Context,
+	match func(value string, exists bool) bool,
+	then func(c redis1.Conn) error,
+	replies func(replies []interface{}) error,
+) error {
+	// on the server of the lease, where Leader reads it
+	c, err := redis1.GetContextForKey(ctx, e.op, e.leaseKey())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if _, err := c.Do("WATCH", e.leaseKey()); err != nil {
+		return err
+	}
+
+	value, err := redis.String(c.Do("GET", e.leaseKey()))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	if !match(value, err == nil) {
+		_, _ = c.Do("UNWATCH")
+		return errNotMatched
+	}
+
+	if err := c.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := then(c); err != nil {
+		return err
+	}
+
+	values, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		if err == redis.ErrNil {
+			return errConflict
+		}
+		return err
+	}
+	if replies != nil {
+		return replies(values)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+func newRedis(server *redistest.Server) *redis.Redis {
+	r := &redis.Redis{
+		Host: server.Host(),
+		Port: server.Port(),
+	}
+	r.SetDefaults()
+	r.Init()
+	return r
+}
+
+type events struct {
+	mu      sync.Mutex
+	elected []int64
+	revoked int
+}
+
+func (ev *events) candidate(r redis.RedisOperator, id string) *Election {
+	return ev.candidateOf(r, "singleton", id)
+}
+
+func (ev *events) candidateOf(r redis.RedisOperator, name string, id string) *Election {
+	e := NewElection(r, name, id)
+	e.LeaseTTL = 150 * time.Millisecond
+	e.RenewInterval = 30 * time.Millisecond
+	e.OnError = func(err error) {}
+	e.OnElected = func(ctx context.Context, token int64) {
+		ev.mu.Lock()
+		defer ev.mu.Unlock()
+		ev.elected = append(ev.elected, token)
+	}
+	e.OnRevoked = func() {
+		ev.mu.Lock()
+		defer ev.mu.Unlock()
+		ev.revoked++
+	}
+	return e
+}
+
+func (ev *events) snapshot() ([]int64, int) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	return append([]int64{}, ev.elected...), ev.revoked
+}
+
+func TestElection(t *testing.T) {
+	server := redistest.NewServer()
+	defer server.Close()
+	r := newRedis(server)
+
+	ev := &events{}
+	a, b := ev.candidate(r, "a"), ev.candidate(r, "b")
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+
+	stoppedA := make(chan struct{})
+	go func() {
+		defer close(stoppedA)
+		_ = a.Run(ctxA)
+	}()
+	NewWithT(t).Eventually(a.IsLeader).Should(BeTrue())
+
+	go func() {
+		_ = b.Run(ctxB)
+	}()
+
+	t.Run("a single leader", func(t *testing.T) {
+		NewWithT(t).Consistently(b.IsLeader, 300*time.Millisecond).Should(BeFalse())
+		NewWithT(t).Expect(a.IsLeader()).To(BeTrue())
+		NewWithT(t).Expect(a.Token()).To(Equal(int64(1)))
+		NewWithT(t).Expect(b.Leader(context.Background())).To(Equal("a"))
+	})
+
+	t.Run("step down on cancel", func(t *testing.T) {
+		cancelA()
+		<-stoppedA
+		NewWithT(t).Expect(a.IsLeader()).To(BeFalse())
+
+		// released, so b doesn't wait for the lease to expire
+		NewWithT(t).Eventually(b.IsLeader, 100*time.Millisecond).Should(BeTrue())
+		NewWithT(t).Expect(b.Token()).To(Equal(int64(2)))
+
+		elected, revoked := ev.snapshot()
+		NewWithT(t).Expect(elected).To(Equal([]int64{1, 2}))
+		NewWithT(t).Expect(revoked).To(Equal(1))
+	})
+
+	t.Run("lease taken over", func(t *testing.T) {
+		_, err := r.Exec(redis.Command("SET", b.leaseKey(), "other:1", "PX", 1000))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		NewWithT(t).Eventually(b.IsLeader).Should(BeFalse())
+		_, revoked := ev.snapshot()
+		NewWithT(t).Expect(revoked).To(Equal(2))
+	})
+}
+
+func TestElectionRenewalFailure(t *testing.T) {
+	server := redistest.NewServer()
+	r := newRedis(server)
+
+	ev := &events{}
+	a := ev.candidate(r, "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = a.Run(ctx)
+	}()
+	NewWithT(t).Eventually(a.IsLeader).Should(BeTrue())
+
+	server.Close()
+
+	// steps down before the lease could have expired
+	lostAt := time.Now()
+	NewWithT(t).Eventually(a.IsLeader).Should(BeFalse())
+	NewWithT(t).Expect(time.Since(lostAt)).To(BeNumerically("<", a.LeaseTTL))
+}
+
+func TestElectionSharded(t *testing.T) {
+	first, second := redistest.NewServer(), redistest.NewServer()
+	defer first.Close()
+	defer second.Close()
+
+	shards := map[string]redis.RedisOperator{"a": newRedis(first), "b": newRedis(second)}
+	sharded := redis.NewShardedRedis(shards, 0)
+
+	// an election whose lease isn't on the first shard, where GetContext connects
+	name := ""
+	for i := 0; name == ""; i++ {
+		n := fmt.Sprintf("singleton-%d", i)
+		if sharded.Shard(NewElection(sharded, n, "").leaseKey()) == shards["b"] {
+			name = n
+		}
+	}
+
+	ev := &events{}
+	a := ev.candidateOf(sharded, name, "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = a.Run(ctx)
+	}()
+	NewWithT(t).Eventually(a.IsLeader).Should(BeTrue())
+	NewWithT(t).Expect(a.Leader(context.Background())).To(Equal("a"))
+
+	exists, err := shards["b"].Exec(redis.Command("EXISTS", a.leaseKey()))
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(exists).To(Equal(int64(1)))
+}
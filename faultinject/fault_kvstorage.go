@@ -0,0 +1,156 @@
+package faultinject
+
+import (
+	"context"
+	"time"
+
+	"github.com/zj-open-source/helper/kvstorage"
+)
+
+var (
+	_ kvstorage.KVStorage      = (*FaultKVStorage)(nil)
+	_ kvstorage.NXKVStorage    = (*FaultKVStorage)(nil)
+	_ kvstorage.FieldKVStorage = (*FaultKVStorage)(nil)
+	_ kvstorage.RangeKVStorage = (*FaultKVStorage)(nil)
+)
+
+func NewFaultKVStorage(s kvstorage.KVStorage, faults Faults) *FaultKVStorage {
+	return &FaultKVStorage{
+		s:   s,
+		inj: newInjector(faults),
+	}
+}
+
+// FaultKVStorage injects faults into the calls of the wrapped KVStorage, a failed call never reaches it.
+// The calls of the optional interfaces fail with kvstorage.ErrNotSupported when the wrapped storage doesn't implement them.
+type FaultKVStorage struct {
+	s   kvstorage.KVStorage
+	inj *injector
+}
+
+func (f *FaultKVStorage) Context() context.Context {
+	return f.s.Context()
+}
+
+func (f *FaultKVStorage) WithContext(ctx context.Context) kvstorage.KVStorage {
+	return &FaultKVStorage{
+		s:   f.s.WithContext(ctx),
+		inj: f.inj,
+	}
+}
+
+func (f *FaultKVStorage) inject(name string) error {
+	if err := f.inj.before(f.s.Context()); err != nil {
+		return err
+	}
+	return f.inj.commandError(name)
+}
+
+func (f *FaultKVStorage) Store(key string, value interface{}, expiresIn time.Duration) error {
+	if err := f.inject("Store"); err != nil {
+		return err
+	}
+	return f.s.Store(key, value, expiresIn)
+}
+
+// StoreNX fails with kvstorage.ErrNotSupported when the wrapped storage isn't a kvstorage.NXKVStorage
+func (f *FaultKVStorage) StoreNX(key string, value interface{}, expiresIn time.Duration) (bool, error) {
+	if err := f.inject("StoreNX"); err != nil {
+		return false, err
+	}
+	return kvstorage.StoreNX(f.s, key, value, expiresIn)
+}
+
+func (f *FaultKVStorage) Load(key string, value interface{}) error {
+	if err := f.inject("Load"); err != nil {
+		return err
+	}
+	return f.s.Load(key, value)
+}
+
+func (f *FaultKVStorage) LoadAndDel(key string, value interface{}) error {
+	if err := f.inject("LoadAndDel"); err != nil {
+		return err
+	}
+	return f.s.LoadAndDel(key, value)
+}
+
+func (f *FaultKVStorage) Del(key string) error {
+	if err := f.inject("Del"); err != nil {
+		return err
+	}
+	return f.s.Del(key)
+}
+
+func (f *FaultKVStorage) fields() (kvstorage.FieldKVStorage, error) {
+	if s, ok := f.s.(kvstorage.FieldKVStorage); ok {
+		return s, nil
+	}
+	return nil, kvstorage.ErrNotSupported
+}
+
+func (f *FaultKVStorage) StoreField(key string, field string, value interface{}, expiresIn time.Duration) error {
+	s, err := f.fields()
+	if err != nil {
+		return err
+	}
+	if err := f.inject("StoreField"); err != nil {
+		return err
+	}
+	return s.StoreField(key, field, value, expiresIn)
+}
+
+func (f *FaultKVStorage) LoadField(key string, field string, value interface{}) error {
+	s, err := f.fields()
+	if err != nil {
+		return err
+	}
+	if err := f.inject("LoadField"); err != nil {
+		return err
+	}
+	return s.LoadField(key, field, value)
+}
+
+func (f *FaultKVStorage) LoadFields(key string, values map[string]interface{}) error {
+	s, err := f.fields()
+	if err != nil {
+		return err
+	}
+	if err := f.inject("LoadFields"); err != nil {
+		return err
+	}
+	return s.LoadFields(key, values)
+}
+
+func (f *FaultKVStorage) LoadAll(key string, value interface{}) error {
+	s, err := f.fields()
+	if err != nil {
+		return err
+	}
+	if err := f.inject("LoadAll"); err != nil {
+		return err
+	}
+	return s.LoadAll(key, value)
+}
+
+func (f *FaultKVStorage) DelField(key string, fields ...string) error {
+	s, err := f.fields()
+	if err != nil {
+		return err
+	}
+	if err := f.inject("DelField"); err != nil {
+		return err
+	}
+	return s.DelField(key, fields...)
+}
+
+func (f *FaultKVStorage) Range(fn func(key string, ttl time.Duration) bool) error {
+	s, ok := f.s.(kvstorage.RangeKVStorage)
+	if !ok {
+		return kvstorage.ErrNotSupported
+	}
+	if err := f.inject("Range"); err != nil {
+		return err
+	}
+	return s.Range(fn)
+}
@@ -0,0 +1,276 @@
+package faultinject
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+var (
+	_ redis1.RedisOperator = (*FaultRedisOperator)(nil)
+	_ redis1.KeyRouter     = (*FaultRedisOperator)(nil)
+	_ redis1.Pipeliner     = (*FaultRedisOperator)(nil)
+	_ redis1.Transactor    = (*FaultRedisOperator)(nil)
+	_ redis1.Dialer        = (*FaultRedisOperator)(nil)
+	_ redis1.NodeRanger    = (*FaultRedisOperator)(nil)
+)
+
+func NewFaultRedisOperator(op redis1.RedisOperator, faults Faults) *FaultRedisOperator {
+	return &FaultRedisOperator{
+		op:  op,
+		inj: newInjector(faults),
+	}
+}
+
+// FaultRedisOperator injects faults into the commands executed through the wrapped RedisOperator
+type FaultRedisOperator struct {
+	op  redis1.RedisOperator
+	inj *injector
+}
+
+func (f *FaultRedisOperator) Prefix(key string) string {
+	return f.op.Prefix(key)
+}
+
+// Get returns a connection whose commands fail with the error of getting it
+func (f *FaultRedisOperator) Get() redis1.Conn {
+	c, err := f.GetContext(context.Background())
+	if err != nil {
+		return redis1.NewErrorConn(err)
+	}
+	return c
+}
+
+func (f *FaultRedisOperator) GetContext(ctx context.Context) (redis1.Conn, error) {
+	return f.conn(ctx, func() (redis1.Conn, error) {
+		return f.op.GetContext(ctx)
+	})
+}
+
+func (f *FaultRedisOperator) GetContextForKey(ctx context.Context, key string) (redis1.Conn, error) {
+	return f.conn(ctx, func() (redis1.Conn, error) {
+		return redis1.GetContextForKey(ctx, f.op, key)
+	})
+}
+
+func (f *FaultRedisOperator) DialContext(ctx context.Context) (redis1.Conn, error) {
+	return f.conn(ctx, func() (redis1.Conn, error) {
+		return redis1.DialContext(ctx, f.op)
+	})
+}
+
+// RangeNodes injects faults into the connection of each server of the wrapped operator
+func (f *FaultRedisOperator) RangeNodes(ctx context.Context, fn func(c redis1.Conn) error) error {
+	return redis1.RangeNodes(ctx, f.op, func(c redis1.Conn) error {
+		fc, err := f.conn(ctx, func() (redis1.Conn, error) {
+			return c, nil
+		})
+		if err != nil {
+			return err
+		}
+		return fn(fc)
+	})
+}
+
+// conn injects faults into the connection of get, the commands run with ctx when they aren't given one
+func (f *FaultRedisOperator) conn(ctx context.Context, get func() (redis1.Conn, error)) (redis1.Conn, error) {
+	if err := f.inj.delay(ctx); err != nil {
+		return nil, err
+	}
+	c, err := get()
+	if err != nil || c == nil {
+		return c, err
+	}
+	return &faultConn{Conn: c, ctx: ctx, inj: f.inj}, nil
+}
+
+func (f *FaultRedisOperator) Exec(cmd *redis1.CMD, others ...*redis1.CMD) (interface{}, error) {
+	return f.ExecContext(context.Background(), cmd, others...)
+}
+
+func (f *FaultRedisOperator) ExecContext(ctx context.Context, cmd *redis1.CMD, others ...*redis1.CMD) (interface{}, error) {
+	if err := f.inj.before(ctx); err != nil {
+		return nil, err
+	}
+
+	cmds := []*redis1.CMD{cmd}
+	for _, o := range others {
+		if o != nil {
+			cmds = append(cmds, o)
+		}
+	}
+
+	if len(cmds) == 1 {
+		if err := f.inj.commandError(cmd.Name()); err != nil {
+			return nil, err
+		}
+		return f.op.ExecContext(ctx, cmd)
+	}
+
+	// failed commands are left out of the batch and their errors are put in their place of the EXEC reply
+	failed := make([]error, len(cmds))
+	for i, c := range cmds {
+		failed[i] = f.inj.commandError(c.Name())
+	}
+	if f.inj.chance(f.inj.faults.PartialMultiRate) {
+		failed[f.inj.intn(len(cmds))] = f.inj.faults.Err
+	}
+
+	remaining := make([]*redis1.CMD, 0, len(cmds))
+	for i, c := range cmds {
+		if failed[i] == nil {
+			remaining = append(remaining, c)
+		}
+	}
+
+	var replies []interface{}
+
+	switch len(remaining) {
+	case 0:
+	case 1:
+		reply, err := f.op.ExecContext(ctx, remaining[0])
+		if err != nil {
+			if e, ok := err.(redis1.Error); ok {
+				reply = e
+			} else {
+				return nil, err
+			}
+		}
+		replies = []interface{}{reply}
+	default:
+		reply, err := f.op.ExecContext(ctx, remaining[0], remaining[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		values, ok := reply.([]interface{})
+		if !ok {
+			return reply, nil
+		}
+		replies = values
+	}
+
+	results := make([]interface{}, len(cmds))
+	for i := range cmds {
+		if failed[i] != nil {
+			results[i] = redis1.Error(failed[i].Error())
+			continue
+		}
+		results[i], replies = replies[0], replies[1:]
+	}
+	return results, nil
+}
+
+// Pipeline pipelines cmds on the wrapped operator, the commands failed by CommandErrors are left out and get their errors
+func (f *FaultRedisOperator) Pipeline(ctx context.Context, cmds ...*redis1.CMD) ([]redis1.Reply, error) {
+	replies := make([]redis1.Reply, len(cmds))
+
+	if err := f.inj.before(ctx); err != nil {
+		for i := range cmds {
+			if cmds[i] != nil {
+				replies[i].Err = err
+			}
+		}
+		return replies, err
+	}
+
+	remaining := make([]*redis1.CMD, len(cmds))
+	for i, c := range cmds {
+		if c == nil {
+			continue
+		}
+		if err := f.inj.commandError(c.Name()); err != nil {
+			replies[i].Err = err
+			continue
+		}
+		remaining[i] = c
+	}
+
+	results, err := redis1.Pipeline(ctx, f.op, remaining...)
+	for i := range results {
+		if remaining[i] != nil {
+			replies[i] = results[i]
+		}
+	}
+	return replies, err
+}
+
+// Tx runs the transaction on the wrapped operator once the faults of a call pass, CommandErrors of EXEC fail it
+func (f *FaultRedisOperator) Tx(ctx context.Context, watchKeys []string, fn func(tx *redis1.Transaction) error) ([]interface{}, error) {
+	if err := f.inj.before(ctx); err != nil {
+		return nil, err
+	}
+	if err := f.inj.commandError("EXEC"); err != nil {
+		return nil, err
+	}
+	return redis1.Tx(ctx, f.op, watchKeys, fn)
+}
+
+var _ redis.ConnWithContext = (*faultConn)(nil)
+
+type faultConn struct {
+	redis1.Conn
+	// ctx is the context the connection was got with, the latency of Do and Send is cut short when it is done
+	ctx     context.Context
+	inj     *injector
+	dropped bool
+}
+
+func (c *faultConn) inject(ctx context.Context, commandName string) error {
+	if c.dropped {
+		return ErrConnectionDropped
+	}
+	if err := c.inj.before(ctx); err != nil {
+		if err == ErrConnectionDropped {
+			c.dropped = true
+			_ = c.Conn.Close()
+		}
+		return err
+	}
+	if commandName != "" {
+		return c.inj.commandError(commandName)
+	}
+	return nil
+}
+
+func (c *faultConn) Err() error {
+	if c.dropped {
+		return ErrConnectionDropped
+	}
+	return c.Conn.Err()
+}
+
+func (c *faultConn) Close() error {
+	if c.dropped {
+		return nil
+	}
+	return c.Conn.Close()
+}
+
+func (c *faultConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if err := c.inject(c.ctx, commandName); err != nil {
+		return nil, err
+	}
+	return c.Conn.Do(commandName, args...)
+}
+
+func (c *faultConn) DoContext(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	if err := c.inject(ctx, commandName); err != nil {
+		return nil, err
+	}
+	return redis.DoContext(c.Conn, ctx, commandName, args...)
+}
+
+func (c *faultConn) Send(commandName string, args ...interface{}) error {
+	if err := c.inject(c.ctx, commandName); err != nil {
+		return err
+	}
+	return c.Conn.Send(commandName, args...)
+}
+
+func (c *faultConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	if c.dropped {
+		return nil, ErrConnectionDropped
+	}
+	return redis.ReceiveContext(c.Conn, ctx)
+}
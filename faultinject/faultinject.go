@@ -0,0 +1,115 @@
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInjected          = errors.New("fault injected")
+	ErrConnectionDropped = errors.New("connection dropped by fault injection")
+)
+
+// Faults describes the faults to inject. All random decisions come from one source seeded with Seed,
+// so a sequence of calls fails the same way on every run.
+type Faults struct {
+	Seed int64
+	// Latency is added before every call
+	Latency time.Duration
+	// LatencyJitter adds a random latency in [0, LatencyJitter) on top of Latency
+	LatencyJitter time.Duration
+	// ErrorRate is the probability in [0, 1] of failing a call with Err
+	ErrorRate float64
+	// Err is returned by calls failed by ErrorRate, defaults to ErrInjected
+	Err error
+	// CommandErrors fails every call of the command with the mapped error, names are case-insensitive.
+	// Commands are Redis command names for RedisOperator and method names (Store, Load…) for KVStorage.
+	CommandErrors map[string]error
+	// DropRate is the probability in [0, 1] of dropping the connection of a call
+	DropRate float64
+	// PartialMultiRate is the probability in [0, 1] of failing one random command inside a MULTI batch,
+	// the other commands of the batch are still executed
+	PartialMultiRate float64
+}
+
+func newInjector(faults Faults) *injector {
+	if faults.Err == nil {
+		faults.Err = ErrInjected
+	}
+
+	commandErrors := make(map[string]error, len(faults.CommandErrors))
+	for name, err := range faults.CommandErrors {
+		commandErrors[strings.ToUpper(name)] = err
+	}
+	faults.CommandErrors = commandErrors
+
+	return &injector{
+		faults: faults,
+		r:      rand.New(rand.NewSource(faults.Seed)),
+	}
+}
+
+type injector struct {
+	faults Faults
+	mu     sync.Mutex
+	r      *rand.Rand
+}
+
+func (i *injector) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.r.Float64() < rate
+}
+
+func (i *injector) intn(n int) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.r.Intn(n)
+}
+
+func (i *injector) delay(ctx context.Context) error {
+	d := i.faults.Latency
+	if i.faults.LatencyJitter > 0 {
+		i.mu.Lock()
+		d += time.Duration(i.r.Int63n(int64(i.faults.LatencyJitter)))
+		i.mu.Unlock()
+	}
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// before runs the faults shared by every call: latency, connection drops and random errors
+func (i *injector) before(ctx context.Context) error {
+	if err := i.delay(ctx); err != nil {
+		return err
+	}
+	if i.chance(i.faults.DropRate) {
+		return ErrConnectionDropped
+	}
+	if i.chance(i.faults.ErrorRate) {
+		return i.faults.Err
+	}
+	return nil
+}
+
+func (i *injector) commandError(name string) error {
+	return i.faults.CommandErrors[strings.ToUpper(name)]
+}
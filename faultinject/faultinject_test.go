@@ -0,0 +1,226 @@
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/kvstorage"
+	"github.com/zj-open-source/helper/kvstorage/memory"
+	"github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+type okOperator struct {
+	executed []string
+}
+
+func (o *okOperator) Prefix(key string) string {
+	return key
+}
+
+func (o *okOperator) Get() redis.Conn {
+	return nil
+}
+
+func (o *okOperator) GetContext(ctx context.Context) (redis.Conn, error) {
+	return nil, nil
+}
+
+func (o *okOperator) Exec(cmd *redis.CMD, others ...*redis.CMD) (interface{}, error) {
+	return o.ExecContext(context.Background(), cmd, others...)
+}
+
+func (o *okOperator) ExecContext(ctx context.Context, cmd *redis.CMD, others ...*redis.CMD) (interface{}, error) {
+	o.executed = append(o.executed, cmd.Name())
+	if len(others) == 0 {
+		return "OK", nil
+	}
+	replies := []interface{}{"OK"}
+	for _, c := range others {
+		o.executed = append(o.executed, c.Name())
+		replies = append(replies, "OK")
+	}
+	return replies, nil
+}
+
+func TestFaultKVStorage(t *testing.T) {
+	errSet := errors.New("set failed")
+
+	t.Run("command errors", func(t *testing.T) {
+		s := NewFaultKVStorage(memory.NewMemoryKVStorage(), Faults{
+			CommandErrors: map[string]error{"store": errSet},
+		})
+
+		NewWithT(t).Expect(s.Store("key", "value", -1)).To(Equal(errSet))
+
+		v := ""
+		NewWithT(t).Expect(s.Load("key", &v)).To(BeNil())
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		s := NewFaultKVStorage(memory.NewMemoryKVStorage(), Faults{
+			Latency: 20 * time.Millisecond,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+
+		v := ""
+		NewWithT(t).Expect(s.WithContext(ctx).Load("key", &v)).To(Equal(context.DeadlineExceeded))
+	})
+
+	t.Run("deterministic error rate", func(t *testing.T) {
+		run := func() []bool {
+			s := NewFaultKVStorage(memory.NewMemoryKVStorage(), Faults{Seed: 1, ErrorRate: 0.5})
+			failed := make([]bool, 0)
+			for i := 0; i < 100; i++ {
+				failed = append(failed, s.Del("key") != nil)
+			}
+			return failed
+		}
+
+		first := run()
+		NewWithT(t).Expect(run()).To(Equal(first))
+		NewWithT(t).Expect(first).To(ContainElement(true))
+		NewWithT(t).Expect(first).To(ContainElement(false))
+	})
+
+	t.Run("optional interfaces", func(t *testing.T) {
+		s := NewFaultKVStorage(memory.NewMemoryKVStorage(), Faults{
+			CommandErrors: map[string]error{"LoadField": errSet},
+		})
+
+		NewWithT(t).Expect(s.StoreField("key", "field", "value", -1)).To(BeNil())
+		v := ""
+		NewWithT(t).Expect(s.LoadField("key", "field", &v)).To(Equal(errSet))
+
+		keys := []string{}
+		NewWithT(t).Expect(s.Range(func(key string, ttl time.Duration) bool {
+			keys = append(keys, key)
+			return true
+		})).To(BeNil())
+		NewWithT(t).Expect(keys).To(Equal([]string{"key"}))
+
+		plain := NewFaultKVStorage(struct{ kvstorage.KVStorage }{memory.NewMemoryKVStorage()}, Faults{})
+		NewWithT(t).Expect(plain.StoreField("key", "field", "value", -1)).To(Equal(kvstorage.ErrNotSupported))
+		_, err := plain.StoreNX("key", "value", -1)
+		NewWithT(t).Expect(err).To(Equal(kvstorage.ErrNotSupported))
+	})
+}
+
+func TestFaultRedisOperator(t *testing.T) {
+	errSet := errors.New("set failed")
+
+	t.Run("partial multi", func(t *testing.T) {
+		op := &okOperator{}
+		f := NewFaultRedisOperator(op, Faults{
+			CommandErrors: map[string]error{"SET": errSet},
+		})
+
+		reply, err := f.Exec(
+			redis.Command("GET", "a"),
+			redis.Command("SET", "a", 1),
+			redis.Command("DEL", "a"),
+		)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(reply).To(Equal([]interface{}{"OK", redis.Error(errSet.Error()), "OK"}))
+		NewWithT(t).Expect(op.executed).To(Equal([]string{"GET", "DEL"}))
+
+		_, err = f.Exec(redis.Command("SET", "a", 1))
+		NewWithT(t).Expect(err).To(Equal(errSet))
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		f := NewFaultRedisOperator(&okOperator{}, Faults{DropRate: 1})
+
+		_, err := f.Exec(redis.Command("GET", "a"))
+		NewWithT(t).Expect(err).To(Equal(ErrConnectionDropped))
+	})
+
+	t.Run("connections", func(t *testing.T) {
+		server := redistest.NewServer()
+		defer server.Close()
+
+		r := &redis.Redis{Host: server.Host(), Port: server.Port()}
+		r.SetDefaults()
+		r.Init()
+
+		f := NewFaultRedisOperator(r, Faults{Latency: 10 * time.Millisecond, CommandErrors: map[string]error{"SET": errSet}})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		c, err := f.GetContext(ctx)
+		NewWithT(t).Expect(err).To(BeNil())
+		defer c.Close()
+
+		NewWithT(t).Expect(c.Do("PING")).To(Equal("PONG"))
+		_, err = redigo.DoContext(c, context.Background(), "SET", "a", 1)
+		NewWithT(t).Expect(err).To(Equal(errSet))
+
+		// the latency is cut short by the context of the connection
+		cancel()
+		_, err = c.Do("PING")
+		NewWithT(t).Expect(err).To(Equal(context.Canceled))
+		NewWithT(t).Expect(redigo.DoContext(c, context.Background(), "PING")).To(Equal("PONG"))
+	})
+
+	t.Run("sharded pipelines and transactions", func(t *testing.T) {
+		shards := map[string]redis.RedisOperator{}
+		for _, name := range []string{"a", "b"} {
+			server := redistest.NewServer()
+			defer server.Close()
+
+			r := &redis.Redis{Host: server.Host(), Port: server.Port()}
+			r.SetDefaults()
+			r.Init()
+			shards[name] = r
+		}
+		s := redis.NewShardedRedis(shards, 0)
+
+		f := NewFaultRedisOperator(s, Faults{CommandErrors: map[string]error{"INCR": errSet}})
+		ctx := context.Background()
+
+		// the keys are spread over both shards, each command runs on the shard of its key
+		cmds := make([]*redis.CMD, 0)
+		for i := 0; i < 10; i++ {
+			key := s.Prefix(fmt.Sprint("key-", i))
+			_, err := s.Exec(redis.Command("SET", key, i))
+			NewWithT(t).Expect(err).To(BeNil())
+			cmds = append(cmds, redis.Command("GET", key))
+		}
+		cmds = append(cmds, redis.Command("INCR", s.Prefix("key-0")))
+
+		replies, err := f.Pipeline(ctx, cmds...)
+		NewWithT(t).Expect(err).To(BeNil())
+		for i := 0; i < 10; i++ {
+			NewWithT(t).Expect(redigo.Int(replies[i].Value, replies[i].Err)).To(Equal(i))
+		}
+		NewWithT(t).Expect(replies[10].Err).To(Equal(errSet))
+
+		for i := 0; i < 10; i++ {
+			key := s.Prefix(fmt.Sprint("key-", i))
+			_, err := f.Tx(ctx, []string{key}, func(tx *redis.Transaction) error {
+				n, err := redigo.Int(tx.Do(redis.Command("GET", key)))
+				if err != nil {
+					return err
+				}
+				tx.Queue(redis.Command("SET", key, n*2))
+				return nil
+			})
+			NewWithT(t).Expect(err).To(BeNil())
+			NewWithT(t).Expect(redigo.Int(s.Exec(redis.Command("GET", key)))).To(Equal(i * 2))
+		}
+	})
+
+	t.Run("connections of uninitialized operators", func(t *testing.T) {
+		c := NewFaultRedisOperator(&redis.Redis{}, Faults{}).Get()
+		defer c.Close()
+
+		_, err := c.Do("PING")
+		NewWithT(t).Expect(err).To(Equal(redis.ErrNotInitialized))
+	})
+}
@@ -0,0 +1,235 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zj-open-source/helper/kvstorage"
+)
+
+const (
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotency-Replayed"
+)
+
+func NewIdempotency(storage kvstorage.KVStorage, ttl time.Duration) *Idempotency {
+	i := &Idempotency{
+		Storage: storage,
+		TTL:     ttl,
+	}
+	i.SetDefaults()
+	return i
+}
+
+// Idempotency replays the first response of a request for every retry carrying the same Idempotency-Key.
+// A key is bound to the method, the URL and the body of its first request, reusing it for another request is rejected.
+type Idempotency struct {
+	// Storage must be a kvstorage.NXKVStorage, to reserve the keys
+	Storage kvstorage.KVStorage
+	// Header is the request header to read the key from
+	Header string
+	// KeyPrefix is prepended to the header value to build the storage key
+	KeyPrefix string
+	// TTL is how long a completed response is kept for replay
+	TTL time.Duration
+	// LockTTL bounds how long a request may hold the key while in flight
+	LockTTL time.Duration
+	// Methods limits the middleware to the listed methods; all methods when empty
+	Methods []string
+}
+
+func (i *Idempotency) SetDefaults() {
+	if i.Header == "" {
+		i.Header = HeaderIdempotencyKey
+	}
+	if i.KeyPrefix == "" {
+		i.KeyPrefix = "idempotency:"
+	}
+	if i.TTL == 0 {
+		i.TTL = 24 * time.Hour
+	}
+	if i.LockTTL == 0 {
+		i.LockTTL = time.Minute
+	}
+}
+
+// Record is the stored state of an idempotency key
+type Record struct {
+	InFlight   bool        `json:"inFlight,omitempty"`
+	StatusCode int         `json:"statusCode,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+	// Fingerprint identifies the request which reserved the key
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// Middleware panics when Storage isn't a kvstorage.NXKVStorage
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	if _, ok := i.Storage.(kvstorage.NXKVStorage); !ok {
+		panic(fmt.Errorf("idempotency: storage %T can't reserve keys: %w", i.Storage, kvstorage.ErrNotSupported))
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(i.Header)
+		if key == "" || !i.matchMethod(req.Method) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		fingerprint, err := requestFingerprint(req)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		storage := i.Storage.WithContext(req.Context())
+		storageKey := i.KeyPrefix + key
+
+		for retry := 0; ; retry++ {
+			reserved, err := kvstorage.StoreNX(storage, storageKey, Record{InFlight: true, Fingerprint: fingerprint}, i.LockTTL)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if reserved {
+				i.serveAndStore(storage, storageKey, fingerprint, next, rw, req)
+				return
+			}
+
+			record := Record{}
+			if err := storage.Load(storageKey, &record); err != nil {
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if (record.InFlight || record.StatusCode != 0) && record.Fingerprint != fingerprint {
+				http.Error(rw, "idempotency key already used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+
+			if record.InFlight {
+				http.Error(rw, "request with the same idempotency key is in progress", http.StatusConflict)
+				return
+			}
+
+			if record.StatusCode != 0 {
+				replay(rw, &record)
+				return
+			}
+
+			// record expired between StoreNX and Load, try to reserve once more
+			if retry > 0 {
+				http.Error(rw, "failed to reserve idempotency key", http.StatusConflict)
+				return
+			}
+		}
+	})
+}
+
+// requestFingerprint hashes the method, the URL and the body of req, the body is read and replaced for the handler
+func requestFingerprint(req *http.Request) (string, error) {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s %s\n", req.Method, req.URL.RequestURI())
+
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return "", err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (i *Idempotency) matchMethod(method string) bool {
+	if len(i.Methods) == 0 {
+		return true
+	}
+	for _, m := range i.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Idempotency) serveAndStore(storage kvstorage.KVStorage, storageKey string, fingerprint string, next http.Handler, rw http.ResponseWriter, req *http.Request) {
+	completed := false
+
+	// release the key when the handler panics, so retries are not locked out until LockTTL
+	defer func() {
+		if !completed {
+			_ = storage.Del(storageKey)
+		}
+	}()
+
+	w := &responseRecorder{ResponseWriter: rw}
+	next.ServeHTTP(w, req)
+	completed = true
+
+	statusCode := w.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	// server errors are not cached, the client is expected to retry them
+	if statusCode >= http.StatusInternalServerError {
+		_ = storage.Del(storageKey)
+		return
+	}
+
+	err := storage.Store(storageKey, Record{
+		Fingerprint: fingerprint,
+		StatusCode:  statusCode,
+		Header:      w.Header().Clone(),
+		Body:        w.body.Bytes(),
+	}, i.TTL)
+	if err != nil {
+		// the response is sent already, so the key is released for retries to run the request again,
+		// instead of conflicting with the in-flight record until LockTTL
+		logrus.Errorf("idempotency %s: %s", storageKey, err)
+		_ = storage.Del(storageKey)
+	}
+}
+
+func replay(rw http.ResponseWriter, record *Record) {
+	for k, values := range record.Header {
+		for _, v := range values {
+			rw.Header().Add(k, v)
+		}
+	}
+	rw.Header().Set(HeaderIdempotencyReplayed, "true")
+	rw.WriteHeader(record.StatusCode)
+	_, _ = rw.Write(record.Body)
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
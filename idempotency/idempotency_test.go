@@ -0,0 +1,143 @@
+package idempotency
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/faultinject"
+	"github.com/zj-open-source/helper/kvstorage"
+	"github.com/zj-open-source/helper/kvstorage/memory"
+)
+
+func TestIdempotency(t *testing.T) {
+	calls := int32(0)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	handler := NewIdempotency(memory.NewMemoryKVStorage(), time.Minute).Middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		switch req.URL.Path {
+		case "/slow":
+			close(entered)
+			<-release
+		case "/fail":
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("X-Order", "1")
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = rw.Write([]byte("created"))
+	}))
+
+	do := func(path string, key string) *httptest.ResponseRecorder {
+		return serve(handler, path, key, "")
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+
+		first := do("/", "replay")
+		NewWithT(t).Expect(first.Code).To(Equal(http.StatusCreated))
+		NewWithT(t).Expect(first.Header().Get(HeaderIdempotencyReplayed)).To(BeEmpty())
+
+		second := do("/", "replay")
+		NewWithT(t).Expect(second.Code).To(Equal(http.StatusCreated))
+		NewWithT(t).Expect(second.Body.String()).To(Equal("created"))
+		NewWithT(t).Expect(second.Header().Get("X-Order")).To(Equal("1"))
+		NewWithT(t).Expect(second.Header().Get(HeaderIdempotencyReplayed)).To(Equal("true"))
+
+		NewWithT(t).Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	})
+
+	t.Run("without key", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+
+		do("/", "")
+		do("/", "")
+
+		NewWithT(t).Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+	})
+
+	t.Run("server error is not cached", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+
+		NewWithT(t).Expect(do("/fail", "fail").Code).To(Equal(http.StatusInternalServerError))
+		NewWithT(t).Expect(do("/fail", "fail").Code).To(Equal(http.StatusInternalServerError))
+
+		NewWithT(t).Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+	})
+
+	t.Run("in flight conflict", func(t *testing.T) {
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			done <- do("/slow", "slow")
+		}()
+
+		<-entered
+		NewWithT(t).Expect(do("/slow", "slow").Code).To(Equal(http.StatusConflict))
+
+		close(release)
+		NewWithT(t).Expect((<-done).Code).To(Equal(http.StatusCreated))
+		NewWithT(t).Expect(do("/slow", "slow").Code).To(Equal(http.StatusCreated))
+	})
+
+	t.Run("key reused for another request", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+
+		NewWithT(t).Expect(serve(handler, "/", "reused", `{"amount":1}`).Code).To(Equal(http.StatusCreated))
+		NewWithT(t).Expect(serve(handler, "/", "reused", `{"amount":1}`).Header().Get(HeaderIdempotencyReplayed)).To(Equal("true"))
+
+		NewWithT(t).Expect(serve(handler, "/", "reused", `{"amount":2}`).Code).To(Equal(http.StatusUnprocessableEntity))
+		NewWithT(t).Expect(serve(handler, "/other", "reused", `{"amount":1}`).Code).To(Equal(http.StatusUnprocessableEntity))
+
+		NewWithT(t).Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	})
+}
+
+func TestIdempotencyStoreFailure(t *testing.T) {
+	calls := int32(0)
+	storage := faultinject.NewFaultKVStorage(memory.NewMemoryKVStorage(), faultinject.Faults{
+		CommandErrors: map[string]error{"Store": faultinject.ErrInjected},
+	})
+
+	handler := NewIdempotency(storage, time.Minute).Middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = io.Copy(rw, req.Body)
+	}))
+
+	// the response isn't stored, but the key is released, so the retry runs again instead of conflicting
+	first := serve(handler, "/", "key", "body")
+	NewWithT(t).Expect(first.Code).To(Equal(http.StatusOK))
+	NewWithT(t).Expect(first.Body.String()).To(Equal("body"))
+
+	NewWithT(t).Expect(serve(handler, "/", "key", "body").Code).To(Equal(http.StatusOK))
+	NewWithT(t).Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+}
+
+type storageWithoutNX struct {
+	kvstorage.KVStorage
+}
+
+func TestIdempotencyStorage(t *testing.T) {
+	NewWithT(t).Expect(func() {
+		NewIdempotency(storageWithoutNX{memory.NewMemoryKVStorage()}, time.Minute).Middleware(http.NotFoundHandler())
+	}).To(PanicWith(MatchError(kvstorage.ErrNotSupported)))
+}
+
+func serve(handler http.Handler, path string, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw
+}
@@ -0,0 +1,68 @@
+// Package fields reads the struct fields stored as the fields of Redis hashes and stream entries
+package fields
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+
+	"github.com/go-courier/reflectx"
+)
+
+// Field is an exported field of a struct, named by the tag `redis:"name,omitempty"` and by the field name without it
+type Field struct {
+	Name      string
+	Index     int
+	OmitEmpty bool
+}
+
+// Of returns the fields of the struct type t, skipping unexported fields and the fields tagged `redis:"-"`
+func Of(t reflect.Type) []Field {
+	fields := make([]Field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		field := Field{Name: f.Name, Index: i}
+		if tag, ok := f.Tag.Lookup("redis"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				field.Name = parts[0]
+			}
+			for _, option := range parts[1:] {
+				if option == "omitempty" {
+					field.OmitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// IsTextUnmarshaler reports whether t implements encoding.TextUnmarshaler
+func IsTextUnmarshaler(t reflect.Type) bool {
+	return t.Implements(textUnmarshalerType)
+}
+
+// IsText reports whether values of t are stored as text: scalars, []byte and text unmarshalers, or pointers to them
+func IsText(t reflect.Type) bool {
+	if t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch reflectx.Deref(t).Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
@@ -0,0 +1,109 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zj-open-source/helper/kvstorage"
+	"github.com/zj-open-source/helper/redis"
+)
+
+var (
+	_ kvstorage.KVStorage   = (*FallbackKVStorage)(nil)
+	_ kvstorage.NXKVStorage = (*FallbackKVStorage)(nil)
+)
+
+// NewFallbackKVStorage serves calls from primary, and from fallback when shouldFallback reports the error of primary.
+// shouldFallback defaults to matching redis.ErrCircuitOpen, so a RedisKVStorage on a redis.CircuitBreaker
+// degrades to fallback, usually a MemoryKVStorage, while the breaker is open and goes back to Redis once it closes.
+//
+// Values stored into fallback are local to the process and are not copied back into primary.
+func NewFallbackKVStorage(primary kvstorage.KVStorage, fallback kvstorage.KVStorage, shouldFallback func(err error) bool) *FallbackKVStorage {
+	if shouldFallback == nil {
+		shouldFallback = func(err error) bool {
+			return errors.Is(err, redis.ErrCircuitOpen)
+		}
+	}
+	return &FallbackKVStorage{
+		primary:        primary,
+		fallback:       fallback,
+		shouldFallback: shouldFallback,
+	}
+}
+
+type FallbackKVStorage struct {
+	primary        kvstorage.KVStorage
+	fallback       kvstorage.KVStorage
+	shouldFallback func(err error) bool
+}
+
+func (s *FallbackKVStorage) Context() context.Context {
+	return s.primary.Context()
+}
+
+func (s *FallbackKVStorage) WithContext(ctx context.Context) kvstorage.KVStorage {
+	return &FallbackKVStorage{
+		primary:        s.primary.WithContext(ctx),
+		fallback:       s.fallback.WithContext(ctx),
+		shouldFallback: s.shouldFallback,
+	}
+}
+
+// forget drops key from fallback once primary has it, so values stored while degraded don't show up later
+func (s *FallbackKVStorage) forget(key string) {
+	_ = s.fallback.Del(key)
+}
+
+func (s *FallbackKVStorage) Store(key string, value interface{}, expiresIn time.Duration) error {
+	err := s.primary.Store(key, value, expiresIn)
+	if err != nil && s.shouldFallback(err) {
+		return s.fallback.Store(key, value, expiresIn)
+	}
+	if err == nil {
+		s.forget(key)
+	}
+	return err
+}
+
+// StoreNX fails with kvstorage.ErrNotSupported when primary, or fallback once used, isn't a kvstorage.NXKVStorage
+func (s *FallbackKVStorage) StoreNX(key string, value interface{}, expiresIn time.Duration) (bool, error) {
+	stored, err := kvstorage.StoreNX(s.primary, key, value, expiresIn)
+	if err != nil && s.shouldFallback(err) {
+		return kvstorage.StoreNX(s.fallback, key, value, expiresIn)
+	}
+	if err == nil && stored {
+		s.forget(key)
+	}
+	return stored, err
+}
+
+func (s *FallbackKVStorage) Load(key string, value interface{}) error {
+	err := s.primary.Load(key, value)
+	if err != nil && s.shouldFallback(err) {
+		return s.fallback.Load(key, value)
+	}
+	return err
+}
+
+func (s *FallbackKVStorage) LoadAndDel(key string, value interface{}) error {
+	err := s.primary.LoadAndDel(key, value)
+	if err != nil && s.shouldFallback(err) {
+		return s.fallback.LoadAndDel(key, value)
+	}
+	if err == nil {
+		s.forget(key)
+	}
+	return err
+}
+
+func (s *FallbackKVStorage) Del(key string) error {
+	err := s.primary.Del(key)
+	if err != nil && s.shouldFallback(err) {
+		return s.fallback.Del(key)
+	}
+	if err == nil {
+		s.forget(key)
+	}
+	return err
+}
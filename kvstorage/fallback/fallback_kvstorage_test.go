@@ -0,0 +1,82 @@
+package fallback
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/kvstorage/memory"
+	redisKVStorage "github.com/zj-open-source/helper/kvstorage/redis"
+	"github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+// downOperator fails every command with io.EOF while down is set
+type downOperator struct {
+	redis.RedisOperator
+	down bool
+}
+
+func (o *downOperator) Exec(cmd *redis.CMD, others ...*redis.CMD) (interface{}, error) {
+	return o.ExecContext(context.Background(), cmd, others...)
+}
+
+func (o *downOperator) ExecContext(ctx context.Context, cmd *redis.CMD, others ...*redis.CMD) (interface{}, error) {
+	if o.down {
+		return nil, io.EOF
+	}
+	return o.RedisOperator.ExecContext(ctx, cmd, others...)
+}
+
+func TestFallbackKVStorage(t *testing.T) {
+	server := redistest.NewServer()
+	defer server.Close()
+
+	r := &redis.Redis{Host: server.Host(), Port: server.Port()}
+	r.SetDefaults()
+	r.Init()
+
+	op := &downOperator{RedisOperator: r}
+	breaker := redis.NewCircuitBreaker(op, redis.CircuitBreakerOptions{
+		MinRequests: 1,
+		OpenTimeout: 50 * time.Millisecond,
+	})
+
+	s := NewFallbackKVStorage(redisKVStorage.NewRedisKVStorage(breaker), memory.NewMemoryKVStorage(), nil)
+
+	NewWithT(t).Expect(s.Store("key", "redis", 0)).To(BeNil())
+
+	t.Run("errors before the breaker opens are returned", func(t *testing.T) {
+		op.down = true
+		NewWithT(t).Expect(s.Store("key", "memory", 0)).To(Equal(io.EOF))
+		NewWithT(t).Expect(breaker.State()).To(Equal(redis.CircuitOpen))
+	})
+
+	t.Run("degrades to fallback while open", func(t *testing.T) {
+		NewWithT(t).Expect(s.Store("key", "memory", 0)).To(BeNil())
+
+		v := ""
+		NewWithT(t).Expect(s.Load("key", &v)).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal("memory"))
+	})
+
+	t.Run("resumes once redis recovers", func(t *testing.T) {
+		op.down = false
+		time.Sleep(60 * time.Millisecond)
+
+		v := ""
+		NewWithT(t).Expect(s.Load("key", &v)).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal("redis"))
+		NewWithT(t).Expect(breaker.State()).To(Equal(redis.CircuitClosed))
+	})
+
+	t.Run("values stored while degraded are dropped once stored into primary", func(t *testing.T) {
+		NewWithT(t).Expect(s.Store("key", "redis again", 0)).To(BeNil())
+
+		v := ""
+		NewWithT(t).Expect(s.fallback.Load("key", &v)).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal(""))
+	})
+}
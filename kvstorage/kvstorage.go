@@ -2,9 +2,15 @@ package kvstorage
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// ErrNotSupported is returned for the operations of the optional interfaces a storage doesn't implement
+var ErrNotSupported = errors.New("operation not supported by the storage")
+
 type KVStorage interface {
 	Store(key string, value interface{}, expiresIn time.Duration) error
 	Load(key string, value interface{}) error
@@ -14,3 +20,43 @@ type KVStorage interface {
 	Context() context.Context
 	WithContext(ctx context.Context) KVStorage
 }
+
+// NXKVStorage stores values only when their keys are absent, atomically, so a key can be reserved as a lock
+type NXKVStorage interface {
+	KVStorage
+
+	// StoreNX stores value only when key is absent or expired, and reports whether it was stored
+	StoreNX(key string, value interface{}, expiresIn time.Duration) (bool, error)
+}
+
+// StoreNX calls the StoreNX of s, it returns ErrNotSupported when s isn't a NXKVStorage
+func StoreNX(s KVStorage, key string, value interface{}, expiresIn time.Duration) (bool, error) {
+	if nx, ok := s.(NXKVStorage); ok {
+		return nx.StoreNX(key, value, expiresIn)
+	}
+	return false, ErrNotSupported
+}
+
+// FieldKVStorage stores values under fields of a key, so a part of an object can be updated without rewriting it.
+// Keys holding fields are removed with Del as a whole and share one TTL.
+type FieldKVStorage interface {
+	KVStorage
+
+	// StoreField stores value under field of key; expiresIn > 0 resets the TTL of the whole key, otherwise the TTL is kept
+	StoreField(key string, field string, value interface{}, expiresIn time.Duration) error
+	LoadField(key string, field string, value interface{}) error
+	// LoadFields loads each field into the pointer mapped to it; missing fields are left untouched
+	LoadFields(key string, values map[string]interface{}) error
+	// LoadAll loads all fields of key into value, which must be a pointer to a map with string keys
+	LoadAll(key string, value interface{}) error
+	DelField(key string, fields ...string) error
+}
+
+// RangeKVStorage can iterate over all its keys
+type RangeKVStorage interface {
+	KVStorage
+
+	// Range calls fn for each key with its remaining TTL until fn returns false, ttl <= 0 means the key never expires.
+	// Keys stored or deleted while ranging may or may not be visited.
+	Range(fn func(key string, ttl time.Duration) bool) error
+}
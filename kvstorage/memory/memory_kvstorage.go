@@ -1,6 +1,7 @@
 package memory
 
 import (
+	"container/list"
 	"context"
 	"reflect"
 	"sync"
@@ -11,22 +12,35 @@ import (
 	"github.com/zj-open-source/helper/kvstorage"
 )
 
-var _ kvstorage.KVStorage = (*MemoryKVStorage)(nil)
+var (
+	_ kvstorage.KVStorage   = (*MemoryKVStorage)(nil)
+	_ kvstorage.NXKVStorage = (*MemoryKVStorage)(nil)
+)
 
 func NewMemoryKVStorage() *MemoryKVStorage {
+	return NewMemoryKVStorageWithCapacity(0)
+}
+
+// NewMemoryKVStorageWithCapacity creates a storage holding at most capacity keys,
+// the least recently used key is evicted when full. capacity <= 0 means unlimited.
+func NewMemoryKVStorageWithCapacity(capacity int) *MemoryKVStorage {
 	return &MemoryKVStorage{
-		m: &sync.Map{},
+		s: &store{
+			items:    map[string]*list.Element{},
+			order:    list.New(),
+			capacity: capacity,
+		},
 	}
 }
 
 type MemoryKVStorage struct {
-	m *sync.Map
+	s *store
 	metax.Ctx
 }
 
 func (s *MemoryKVStorage) WithContext(ctx context.Context) kvstorage.KVStorage {
 	return &MemoryKVStorage{
-		m:   s.m,
+		s:   s.s,
 		Ctx: s.Ctx.WithContext(ctx),
 	}
 }
@@ -37,47 +51,264 @@ type ValueWithExpire struct {
 	ExpiredAt time.Time
 }
 
-func (s *MemoryKVStorage) Del(key string) error {
-	s.m.Delete(key)
-	return nil
+func (v ValueWithExpire) expired(now time.Time) bool {
+	return !v.Always && now.After(v.ExpiredAt)
 }
 
-func (s *MemoryKVStorage) Store(key string, value interface{}, expiresIn time.Duration) error {
+func newValueWithExpire(value interface{}, expiresIn time.Duration) ValueWithExpire {
 	if expiresIn > 0 {
-		s.m.Store(key, ValueWithExpire{
+		return ValueWithExpire{
 			Value:     value,
 			ExpiredAt: time.Now().Add(expiresIn),
-		})
-		return nil
+		}
 	}
-
-	s.m.Store(key, ValueWithExpire{
+	return ValueWithExpire{
 		Value:  value,
 		Always: true,
+	}
+}
+
+type EvictReason int
+
+const (
+	EvictReasonExpired EvictReason = iota + 1
+	EvictReasonDeleted
+	EvictReasonLoadedAndDeleted
+	EvictReasonOverwritten
+	EvictReasonCapacity
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictReasonExpired:
+		return "expired"
+	case EvictReasonDeleted:
+		return "deleted"
+	case EvictReasonLoadedAndDeleted:
+		return "loaded_and_deleted"
+	case EvictReasonOverwritten:
+		return "overwritten"
+	case EvictReasonCapacity:
+		return "capacity"
+	}
+	return "unknown"
+}
+
+type EvictFunc func(key string, value interface{}, reason EvictReason)
+
+// OnEvict registers fn to be called whenever a value leaves the storage.
+// Callbacks run after the storage lock is released, so they may use the storage.
+func (s *MemoryKVStorage) OnEvict(fn EvictFunc) {
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+	s.s.onEvict = append(s.s.onEvict, fn)
+}
+
+func (s *MemoryKVStorage) Del(key string) error {
+	s.s.do(func(evict evictFn) {
+		if e, ok := s.s.items[key]; ok {
+			evict(s.s.remove(e), EvictReasonDeleted)
+		}
 	})
+	return nil
+}
 
+func (s *MemoryKVStorage) Store(key string, value interface{}, expiresIn time.Duration) error {
+	s.s.do(func(evict evictFn) {
+		s.s.set(key, newValueWithExpire(value, expiresIn), evict)
+	})
 	return nil
 }
 
+func (s *MemoryKVStorage) StoreNX(key string, value interface{}, expiresIn time.Duration) (stored bool, err error) {
+	s.s.do(func(evict evictFn) {
+		if e, ok := s.s.items[key]; ok {
+			if !e.Value.(*entry).value.expired(time.Now()) {
+				return
+			}
+			evict(s.s.remove(e), EvictReasonExpired)
+		}
+		s.s.set(key, newValueWithExpire(value, expiresIn), evict)
+		stored = true
+	})
+	return
+}
+
 func (s *MemoryKVStorage) LoadAndDel(key string, value interface{}) error {
-	if err := s.Load(key, value); err != nil {
-		return err
-	}
-	return s.Del(key)
+	var found interface{}
+	s.s.do(func(evict evictFn) {
+		if ent := s.s.get(key, evict); ent != nil {
+			found = ent.value.Value
+			if _, ok := found.(Fields); ok {
+				return
+			}
+			evict(s.s.remove(s.s.items[key]), EvictReasonLoadedAndDeleted)
+		}
+	})
+	return loadValue(found, value)
 }
 
 func (s *MemoryKVStorage) Load(key string, value interface{}) error {
-	if val, ok := s.m.Load(key); ok {
-		v := val.(ValueWithExpire)
-		if !v.Always {
-			if time.Now().After(v.ExpiredAt) {
-				s.Del(key)
-				return nil
+	var found interface{}
+	s.s.do(func(evict evictFn) {
+		if ent := s.s.get(key, evict); ent != nil {
+			found = ent.value.Value
+		}
+	})
+	return loadValue(found, value)
+}
+
+// DeleteExpired removes all expired values, firing the eviction callbacks for each of them
+func (s *MemoryKVStorage) DeleteExpired() {
+	s.s.do(func(evict evictFn) {
+		now := time.Now()
+		for _, e := range s.s.items {
+			if e.Value.(*entry).value.expired(now) {
+				evict(s.s.remove(e), EvictReasonExpired)
 			}
 		}
-		rv := reflectx.Indirect(reflect.ValueOf(value))
-		rv.Set(reflectx.Indirect(reflect.ValueOf(v.Value)))
+	})
+}
+
+// StartJanitor calls DeleteExpired every interval until ctx is done.
+// Without it, expired values are only evicted when they are accessed.
+func (s *MemoryKVStorage) StartJanitor(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.DeleteExpired()
+			}
+		}
+	}()
+}
+
+func loadValue(found interface{}, value interface{}) error {
+	if found == nil {
+		return nil
+	}
+	if _, ok := found.(Fields); ok {
+		return kvstorage.ErrWrongType
+	}
+	setValue(value, found)
+	return nil
+}
+
+func setValue(value interface{}, v interface{}) {
+	rv := reflectx.Indirect(reflect.ValueOf(value))
+	rv.Set(reflectx.Indirect(reflect.ValueOf(v)))
+}
+
+type entry struct {
+	key   string
+	value ValueWithExpire
+}
+
+type evictFn func(e *entry, reason EvictReason)
+
+type store struct {
+	mu       sync.Mutex
+	items    map[string]*list.Element
+	order    *list.List
+	capacity int
+	onEvict  []EvictFunc
+}
+
+// do runs fn under the lock and fires the evictions it collected once the lock is released
+func (s *store) do(fn func(evict evictFn)) {
+	type eviction struct {
+		e      *entry
+		reason EvictReason
+	}
+
+	var evictions []eviction
+
+	s.mu.Lock()
+	fn(func(e *entry, reason EvictReason) {
+		evictions = append(evictions, eviction{e: e, reason: reason})
+	})
+	callbacks := s.onEvict
+	s.mu.Unlock()
+
+	for _, ev := range evictions {
+		for _, cb := range callbacks {
+			cb(ev.e.key, ev.e.value.Value, ev.reason)
+		}
+	}
+}
+
+func (s *store) get(key string, evict evictFn) *entry {
+	e, ok := s.items[key]
+	if !ok {
 		return nil
 	}
+	ent := e.Value.(*entry)
+	if ent.value.expired(time.Now()) {
+		evict(s.remove(e), EvictReasonExpired)
+		return nil
+	}
+	s.order.MoveToFront(e)
+	return ent
+}
+
+func (s *store) set(key string, value ValueWithExpire, evict evictFn) {
+	if e, ok := s.items[key]; ok {
+		ent := e.Value.(*entry)
+		evict(&entry{key: key, value: ent.value}, EvictReasonOverwritten)
+		ent.value = value
+		s.order.MoveToFront(e)
+		return
+	}
+
+	s.items[key] = s.order.PushFront(&entry{key: key, value: value})
+
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		evict(s.remove(s.order.Back()), EvictReasonCapacity)
+	}
+}
+
+func (s *store) remove(e *list.Element) *entry {
+	ent := s.order.Remove(e).(*entry)
+	delete(s.items, ent.key)
+	return ent
+}
+
+var _ kvstorage.RangeKVStorage = (*MemoryKVStorage)(nil)
+
+func (s *MemoryKVStorage) Range(fn func(key string, ttl time.Duration) bool) error {
+	type item struct {
+		key string
+		ttl time.Duration
+	}
+
+	var items []item
+
+	s.s.do(func(evict evictFn) {
+		now := time.Now()
+		for key, e := range s.s.items {
+			v := e.Value.(*entry).value
+			if v.expired(now) {
+				continue
+			}
+			i := item{key: key}
+			if !v.Always {
+				if i.ttl = v.ExpiredAt.Sub(now); i.ttl <= 0 {
+					continue
+				}
+			}
+			items = append(items, i)
+		}
+	})
+
+	for _, i := range items {
+		if !fn(i.key, i.ttl) {
+			break
+		}
+	}
 	return nil
 }
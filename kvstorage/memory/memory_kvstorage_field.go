@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/zj-open-source/helper/kvstorage"
+)
+
+var _ kvstorage.FieldKVStorage = (*MemoryKVStorage)(nil)
+
+// Fields is the value held by a key written with StoreField,
+// eviction callbacks receive it as the value of such keys
+type Fields map[string]interface{}
+
+func (s *MemoryKVStorage) StoreField(key string, field string, value interface{}, expiresIn time.Duration) (err error) {
+	s.s.do(func(evict evictFn) {
+		if ent := s.s.get(key, evict); ent != nil {
+			fields, ok := ent.value.Value.(Fields)
+			if !ok {
+				err = kvstorage.ErrWrongType
+				return
+			}
+			fields[field] = value
+			if expiresIn > 0 {
+				ent.value = newValueWithExpire(fields, expiresIn)
+			}
+			return
+		}
+		s.s.set(key, newValueWithExpire(Fields{field: value}, expiresIn), evict)
+	})
+	return
+}
+
+func (s *MemoryKVStorage) LoadField(key string, field string, value interface{}) error {
+	return s.LoadFields(key, map[string]interface{}{field: value})
+}
+
+func (s *MemoryKVStorage) LoadFields(key string, values map[string]interface{}) error {
+	found, err := s.loadFields(key)
+	if err != nil {
+		return err
+	}
+	for field, value := range values {
+		if v, ok := found[field]; ok {
+			setValue(value, v)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryKVStorage) LoadAll(key string, value interface{}) error {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Map || rv.Elem().Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("LoadAll needs a pointer to map with string keys, but got %T", value)
+	}
+
+	found, err := s.loadFields(key)
+	if err != nil {
+		return err
+	}
+
+	m := rv.Elem()
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+
+	for field, v := range found {
+		elem := reflect.New(m.Type().Elem())
+		setValue(elem.Interface(), v)
+		m.SetMapIndex(reflect.ValueOf(field).Convert(m.Type().Key()), elem.Elem())
+	}
+	return nil
+}
+
+func (s *MemoryKVStorage) DelField(key string, fields ...string) (err error) {
+	s.s.do(func(evict evictFn) {
+		ent := s.s.get(key, evict)
+		if ent == nil {
+			return
+		}
+		values, ok := ent.value.Value.(Fields)
+		if !ok {
+			err = kvstorage.ErrWrongType
+			return
+		}
+		for _, field := range fields {
+			delete(values, field)
+		}
+		if len(values) == 0 {
+			evict(s.s.remove(s.s.items[key]), EvictReasonDeleted)
+		}
+	})
+	return
+}
+
+// loadFields returns a copy of the fields of key, so they can be read without holding the lock
+func (s *MemoryKVStorage) loadFields(key string) (found Fields, err error) {
+	s.s.do(func(evict evictFn) {
+		ent := s.s.get(key, evict)
+		if ent == nil {
+			return
+		}
+		values, ok := ent.value.Value.(Fields)
+		if !ok {
+			err = kvstorage.ErrWrongType
+			return
+		}
+		found = make(Fields, len(values))
+		for field, v := range values {
+			found[field] = v
+		}
+	})
+	return
+}
@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/kvstorage"
+)
+
+func TestMemoryKVStorageField(t *testing.T) {
+	c := NewMemoryKVStorage()
+
+	key := "profile"
+
+	t.Run("store and load field", func(t *testing.T) {
+		NewWithT(t).Expect(c.StoreField(key, "name", "name", -1)).To(BeNil())
+		NewWithT(t).Expect(c.StoreField(key, "city", "city", -1)).To(BeNil())
+
+		name := ""
+		NewWithT(t).Expect(c.LoadField(key, "name", &name)).To(BeNil())
+		NewWithT(t).Expect(name).To(Equal("name"))
+
+		city, missing := "", ""
+		NewWithT(t).Expect(c.LoadFields(key, map[string]interface{}{"city": &city, "missing": &missing})).To(BeNil())
+		NewWithT(t).Expect(city).To(Equal("city"))
+		NewWithT(t).Expect(missing).To(BeEmpty())
+
+		all := map[string]string{}
+		NewWithT(t).Expect(c.LoadAll(key, &all)).To(BeNil())
+		NewWithT(t).Expect(all).To(Equal(map[string]string{"name": "name", "city": "city"}))
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		v := ""
+		NewWithT(t).Expect(c.Load(key, &v)).To(Equal(kvstorage.ErrWrongType))
+	})
+
+	t.Run("del field", func(t *testing.T) {
+		NewWithT(t).Expect(c.DelField(key, "name")).To(BeNil())
+
+		all := map[string]string{}
+		NewWithT(t).Expect(c.LoadAll(key, &all)).To(BeNil())
+		NewWithT(t).Expect(all).To(Equal(map[string]string{"city": "city"}))
+
+		NewWithT(t).Expect(c.Del(key)).To(BeNil())
+	})
+
+	t.Run("key level ttl", func(t *testing.T) {
+		NewWithT(t).Expect(c.StoreField(key, "name", "name", 10*time.Millisecond)).To(BeNil())
+		NewWithT(t).Expect(c.StoreField(key, "city", "city", -1)).To(BeNil())
+		time.Sleep(20 * time.Millisecond)
+
+		all := map[string]string{}
+		NewWithT(t).Expect(c.LoadAll(key, &all)).To(BeNil())
+		NewWithT(t).Expect(all).To(BeEmpty())
+	})
+}
@@ -45,4 +45,51 @@ func TestMemoryKVStorage(t *testing.T) {
 			NewWithT(t).Expect(v).To(BeEmpty())
 		}
 	})
+
+	t.Run("store nx", func(t *testing.T) {
+		NewWithT(t).Expect(c.Del(key)).To(BeNil())
+
+		stored, err := c.StoreNX(key, value, -1)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(stored).To(BeTrue())
+
+		stored, err = c.StoreNX(key, "other", -1)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(stored).To(BeFalse())
+
+		v := ""
+		NewWithT(t).Expect(c.LoadAndDel(key, &v)).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal(value))
+	})
+}
+
+func TestMemoryKVStorageOnEvict(t *testing.T) {
+	c := NewMemoryKVStorageWithCapacity(2)
+
+	evicted := map[string]EvictReason{}
+	c.OnEvict(func(key string, value interface{}, reason EvictReason) {
+		evicted[value.(string)] = reason
+	})
+
+	v := ""
+
+	NewWithT(t).Expect(c.Store("a", "a1", -1)).To(BeNil())
+	NewWithT(t).Expect(c.Store("a", "a2", -1)).To(BeNil())
+	NewWithT(t).Expect(evicted["a1"]).To(Equal(EvictReasonOverwritten))
+
+	NewWithT(t).Expect(c.Store("b", "b", -1)).To(BeNil())
+	NewWithT(t).Expect(c.Load("a", &v)).To(BeNil())
+	NewWithT(t).Expect(c.Store("c", "c", -1)).To(BeNil())
+	NewWithT(t).Expect(evicted["b"]).To(Equal(EvictReasonCapacity))
+
+	NewWithT(t).Expect(c.Del("a")).To(BeNil())
+	NewWithT(t).Expect(evicted["a2"]).To(Equal(EvictReasonDeleted))
+
+	NewWithT(t).Expect(c.LoadAndDel("c", &v)).To(BeNil())
+	NewWithT(t).Expect(evicted["c"]).To(Equal(EvictReasonLoadedAndDeleted))
+
+	NewWithT(t).Expect(c.Store("d", "d", time.Millisecond)).To(BeNil())
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	NewWithT(t).Expect(evicted["d"]).To(Equal(EvictReasonExpired))
 }
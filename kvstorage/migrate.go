@@ -0,0 +1,282 @@
+package kvstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type MigrateOptions struct {
+	// Match keeps only keys matching the glob pattern, with the same syntax as Redis KEYS/SCAN MATCH
+	Match string
+	// Filter keeps only keys it returns true for, applied after Match
+	Filter func(key string) bool
+	// DryRun only counts the keys which would be copied
+	DryRun bool
+	// Rate limits the copied keys per second, 0 means unlimited
+	Rate int
+	// Verify loads every copied key from both storages afterwards and reports the ones that differ
+	Verify bool
+	// NewValue returns the pointer each value is loaded into, defaults to *interface{}.
+	// Use func() interface{} { return &json.RawMessage{} } to copy between RedisKVStorages byte for byte,
+	// since decoding into interface{} turns big integers into float64.
+	NewValue func() interface{}
+}
+
+type MigrateReport struct {
+	Scanned int
+	Matched int
+	Copied  int
+	// Skipped counts the keys gone, as expired, between ranging and copying them
+	Skipped    int
+	Verified   int
+	Failed     map[string]error
+	Mismatched []string
+}
+
+// Migrate streams all keys of src into dst with their remaining TTLs.
+// Keys holding fields are copied field by field when both storages are FieldKVStorage.
+// Failures of single keys are collected in the report, the returned error is only for failures of ranging.
+func Migrate(ctx context.Context, src RangeKVStorage, dst KVStorage, opt MigrateOptions) (*MigrateReport, error) {
+	if opt.NewValue == nil {
+		opt.NewValue = func() interface{} {
+			var v interface{}
+			return &v
+		}
+	}
+
+	match := func(key string) bool { return true }
+	if opt.Match != "" {
+		re, err := globToRegexp(opt.Match)
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+	}
+
+	var tick <-chan time.Time
+	if opt.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(opt.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	if s, ok := src.WithContext(ctx).(RangeKVStorage); ok {
+		src = s
+	}
+	dst = dst.WithContext(ctx)
+
+	report := &MigrateReport{
+		Failed: map[string]error{},
+	}
+
+	skipped := map[string]bool{}
+
+	keep := func(key string) bool {
+		return match(key) && (opt.Filter == nil || opt.Filter(key))
+	}
+
+	err := src.Range(func(key string, ttl time.Duration) bool {
+		report.Scanned++
+
+		if !keep(key) {
+			return true
+		}
+		report.Matched++
+
+		if opt.DryRun {
+			return true
+		}
+
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-tick:
+			}
+		} else if ctx.Err() != nil {
+			return false
+		}
+
+		copied, err := copyKey(src, dst, key, ttl, opt.NewValue)
+		if err != nil {
+			report.Failed[key] = err
+			return true
+		}
+		if !copied {
+			skipped[key] = true
+			report.Skipped++
+			return true
+		}
+		report.Copied++
+
+		return true
+	})
+	if err != nil {
+		return report, err
+	}
+	if ctx.Err() != nil {
+		return report, ctx.Err()
+	}
+
+	if !opt.Verify || opt.DryRun {
+		return report, nil
+	}
+
+	err = src.Range(func(key string, ttl time.Duration) bool {
+		if !keep(key) {
+			return true
+		}
+		if _, failed := report.Failed[key]; failed || skipped[key] {
+			return true
+		}
+
+		equal, err := verifyKey(src, dst, key, opt.NewValue)
+		if err != nil {
+			report.Failed[key] = err
+		} else if !equal {
+			report.Mismatched = append(report.Mismatched, key)
+		} else {
+			report.Verified++
+		}
+
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, ctx.Err()
+}
+
+// copyKey returns false when key is gone, Load leaving the value as newValue returns it and LoadAll loading no field
+func copyKey(src KVStorage, dst KVStorage, key string, ttl time.Duration, newValue func() interface{}) (bool, error) {
+	value := newValue()
+
+	err := src.Load(key, value)
+	if err == nil {
+		if reflect.DeepEqual(value, newValue()) {
+			return false, nil
+		}
+		return true, dst.Store(key, reflect.ValueOf(value).Elem().Interface(), ttl)
+	}
+	if !errors.Is(err, ErrWrongType) {
+		return false, err
+	}
+
+	fieldSrc, ok := src.(FieldKVStorage)
+	if !ok {
+		return false, err
+	}
+	fieldDst, ok := dst.(FieldKVStorage)
+	if !ok {
+		return false, err
+	}
+
+	fields, err := loadAll(fieldSrc, key, newValue)
+	if err != nil {
+		return false, err
+	}
+	if fields.Len() == 0 {
+		return false, nil
+	}
+
+	for _, field := range fields.MapKeys() {
+		if err := fieldDst.StoreField(key, field.String(), fields.MapIndex(field).Interface(), ttl); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func verifyKey(src KVStorage, dst KVStorage, key string, newValue func() interface{}) (bool, error) {
+	load := func(s KVStorage) (interface{}, error) {
+		value := newValue()
+		err := s.Load(key, value)
+		if err == nil {
+			return value, nil
+		}
+		if fieldStorage, ok := s.(FieldKVStorage); ok && errors.Is(err, ErrWrongType) {
+			fields, err := loadAll(fieldStorage, key, newValue)
+			if err != nil {
+				return nil, err
+			}
+			return fields.Interface(), nil
+		}
+		return nil, err
+	}
+
+	srcValue, err := load(src)
+	if err != nil {
+		return false, err
+	}
+	dstValue, err := load(dst)
+	if err != nil {
+		return false, err
+	}
+
+	// compare the JSON forms, so values decoded by different storages are comparable
+	srcJSON, err := json.Marshal(srcValue)
+	if err != nil {
+		return false, err
+	}
+	dstJSON, err := json.Marshal(dstValue)
+	if err != nil {
+		return false, err
+	}
+	return string(srcJSON) == string(dstJSON), nil
+}
+
+func loadAll(s FieldKVStorage, key string, newValue func() interface{}) (reflect.Value, error) {
+	elemType := reflect.TypeOf(newValue()).Elem()
+	fields := reflect.New(reflect.MapOf(reflect.TypeOf(""), elemType))
+	if err := s.LoadAll(key, fields.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return fields.Elem(), nil
+}
+
+// globToRegexp converts a Redis style glob pattern, where * also matches '/', to a regexp
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	b := strings.Builder{}
+	b.WriteString("(?s)^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + regexp.QuoteMeta(class[1:])
+			} else {
+				class = regexp.QuoteMeta(class)
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			} else {
+				b.WriteString(`\\`)
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,99 @@
+package kvstorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/kvstorage"
+	"github.com/zj-open-source/helper/kvstorage/memory"
+)
+
+func TestMigrate(t *testing.T) {
+	src := memory.NewMemoryKVStorage()
+
+	NewWithT(t).Expect(src.Store("user:1", "1", -1)).To(BeNil())
+	NewWithT(t).Expect(src.Store("user:2", "2", time.Hour)).To(BeNil())
+	NewWithT(t).Expect(src.Store("order:1", "1", -1)).To(BeNil())
+	NewWithT(t).Expect(src.StoreField("user:profile", "name", "name", -1)).To(BeNil())
+
+	t.Run("dry run", func(t *testing.T) {
+		dst := memory.NewMemoryKVStorage()
+
+		report, err := kvstorage.Migrate(context.Background(), src, dst, kvstorage.MigrateOptions{
+			Match:  "user:*",
+			DryRun: true,
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(report.Scanned).To(Equal(4))
+		NewWithT(t).Expect(report.Matched).To(Equal(3))
+		NewWithT(t).Expect(report.Copied).To(Equal(0))
+
+		v := ""
+		NewWithT(t).Expect(dst.Load("user:1", &v)).To(BeNil())
+		NewWithT(t).Expect(v).To(BeEmpty())
+	})
+
+	t.Run("copy and verify", func(t *testing.T) {
+		dst := memory.NewMemoryKVStorage()
+
+		report, err := kvstorage.Migrate(context.Background(), src, dst, kvstorage.MigrateOptions{
+			Match:  "user:*",
+			Filter: func(key string) bool { return key != "user:1" },
+			Rate:   1000,
+			Verify: true,
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(report.Copied).To(Equal(2))
+		NewWithT(t).Expect(report.Verified).To(Equal(2))
+		NewWithT(t).Expect(report.Failed).To(BeEmpty())
+		NewWithT(t).Expect(report.Mismatched).To(BeEmpty())
+
+		ttls := map[string]time.Duration{}
+		NewWithT(t).Expect(dst.Range(func(key string, ttl time.Duration) bool {
+			ttls[key] = ttl
+			return true
+		})).To(BeNil())
+		NewWithT(t).Expect(ttls).To(HaveLen(2))
+		NewWithT(t).Expect(ttls["user:2"]).To(BeNumerically(">", 59*time.Minute))
+		NewWithT(t).Expect(ttls["user:profile"]).To(BeZero())
+
+		v := ""
+		NewWithT(t).Expect(dst.Load("user:2", &v)).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal("2"))
+
+		name := ""
+		NewWithT(t).Expect(dst.LoadField("user:profile", "name", &name)).To(BeNil())
+		NewWithT(t).Expect(name).To(Equal("name"))
+	})
+	t.Run("skips expired keys", func(t *testing.T) {
+		src := memory.NewMemoryKVStorage()
+		dst := memory.NewMemoryKVStorage()
+
+		NewWithT(t).Expect(src.Store("kept", "1", -1)).To(BeNil())
+		NewWithT(t).Expect(src.Store("expiring", "2", 50*time.Millisecond)).To(BeNil())
+
+		report, err := kvstorage.Migrate(context.Background(), src, dst, kvstorage.MigrateOptions{
+			// the key expires between ranging and loading it
+			Filter: func(key string) bool {
+				if key == "expiring" {
+					time.Sleep(100 * time.Millisecond)
+				}
+				return true
+			},
+			Verify: true,
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(report.Copied).To(Equal(1))
+		NewWithT(t).Expect(report.Skipped).To(Equal(1))
+		NewWithT(t).Expect(report.Verified).To(Equal(1))
+
+		keys := make([]string, 0)
+		NewWithT(t).Expect(dst.Range(func(key string, ttl time.Duration) bool {
+			keys = append(keys, key)
+			return true
+		})).To(BeNil())
+		NewWithT(t).Expect(keys).To(Equal([]string{"kept"}))
+	})
+}
@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/go-courier/metax"
@@ -11,7 +12,10 @@ import (
 	redis1 "github.com/zj-open-source/helper/redis"
 )
 
-var _ kvstorage.KVStorage = (*RedisKVStorage)(nil)
+var (
+	_ kvstorage.KVStorage   = (*RedisKVStorage)(nil)
+	_ kvstorage.NXKVStorage = (*RedisKVStorage)(nil)
+)
 
 func NewRedisKVStorage(op redis1.RedisOperator) *RedisKVStorage {
 	return &RedisKVStorage{
@@ -49,7 +53,7 @@ func (s *RedisKVStorage) Store(key string, value interface{}, expiresIn time.Dur
 	if expiresIn > 0 {
 		_, err := s.op.Exec(
 			redis1.Command("SET", s.op.Prefix(key), bytes),
-			redis1.Command("EXPIRE", s.op.Prefix(key), transToSecond(expiresIn)),
+			redis1.Command("PEXPIRE", s.op.Prefix(key), transToMillisecond(expiresIn)),
 		)
 		return err
 	}
@@ -60,6 +64,28 @@ func (s *RedisKVStorage) Store(key string, value interface{}, expiresIn time.Dur
 	return err
 }
 
+func (s *RedisKVStorage) StoreNX(key string, value interface{}, expiresIn time.Duration) (bool, error) {
+	bytes, errMarshal := json.Marshal(data{Value: value})
+	if errMarshal != nil {
+		return false, errMarshal
+	}
+
+	args := []interface{}{s.op.Prefix(key), bytes}
+	if expiresIn > 0 {
+		args = append(args, "PX", transToMillisecond(expiresIn))
+	}
+	args = append(args, "NX")
+
+	_, err := redis.String(s.op.Exec(redis1.Command("SET", args...)))
+	if err != nil {
+		if err == redis.ErrNil {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (s *RedisKVStorage) LoadAndDel(key string, value interface{}) error {
 	values, err := redis.Values(s.op.Exec(
 		redis1.Command("GET", s.op.Prefix(key)),
@@ -71,6 +97,9 @@ func (s *RedisKVStorage) LoadAndDel(key string, value interface{}) error {
 		}
 		return err
 	}
+	if e, ok := values[0].(redis.Error); ok {
+		return wrapError(e)
+	}
 	if bytes, ok := values[0].([]byte); ok {
 		return json.Unmarshal(bytes, &data{Value: value})
 	}
@@ -83,15 +112,23 @@ func (s *RedisKVStorage) Load(key string, value interface{}) error {
 		if err == redis.ErrNil {
 			return nil
 		}
-		return err
+		return wrapError(err)
 	}
 
 	return json.Unmarshal(bytes, &data{Value: value})
 }
 
-func transToSecond(dur time.Duration) int64 {
-	if dur > 0 && dur < time.Second {
-		return 0
+// wrapError maps WRONGTYPE replies to kvstorage.ErrWrongType, as MemoryKVStorage does
+func wrapError(err error) error {
+	if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "WRONGTYPE") {
+		return kvstorage.ErrWrongType
+	}
+	return err
+}
+
+func transToMillisecond(dur time.Duration) int64 {
+	if dur > 0 && dur < time.Millisecond {
+		return 1
 	}
-	return int64(dur / time.Second)
+	return int64(dur / time.Millisecond)
 }
@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/zj-open-source/helper/kvstorage"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+var _ kvstorage.FieldKVStorage = (*RedisKVStorage)(nil)
+
+func (s *RedisKVStorage) StoreField(key string, field string, value interface{}, expiresIn time.Duration) error {
+	bytes, errMarshal := json.Marshal(data{Value: value})
+	if errMarshal != nil {
+		return errMarshal
+	}
+
+	if expiresIn > 0 {
+		_, err := s.op.Exec(
+			redis1.Command("HSET", s.op.Prefix(key), field, bytes),
+			redis1.Command("PEXPIRE", s.op.Prefix(key), transToMillisecond(expiresIn)),
+		)
+		return err
+	}
+
+	_, err := s.op.Exec(
+		redis1.Command("HSET", s.op.Prefix(key), field, bytes),
+	)
+	return err
+}
+
+func (s *RedisKVStorage) LoadField(key string, field string, value interface{}) error {
+	bytes, err := redis.Bytes(s.op.Exec(redis1.Command("HGET", s.op.Prefix(key), field)))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil
+		}
+		return wrapError(err)
+	}
+
+	return json.Unmarshal(bytes, &data{Value: value})
+}
+
+func (s *RedisKVStorage) LoadFields(key string, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	fields := make([]string, 0, len(values))
+	args := []interface{}{s.op.Prefix(key)}
+	for field := range values {
+		fields = append(fields, field)
+		args = append(args, field)
+	}
+
+	list, err := redis.ByteSlices(s.op.Exec(redis1.Command("HMGET", args...)))
+	if err != nil {
+		return wrapError(err)
+	}
+
+	for i, bytes := range list {
+		if bytes == nil {
+			continue
+		}
+		if err := json.Unmarshal(bytes, &data{Value: values[fields[i]]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisKVStorage) LoadAll(key string, value interface{}) error {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Map || rv.Elem().Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("LoadAll needs a pointer to map with string keys, but got %T", value)
+	}
+
+	list, err := redis.ByteSlices(s.op.Exec(redis1.Command("HGETALL", s.op.Prefix(key))))
+	if err != nil {
+		return wrapError(err)
+	}
+
+	m := rv.Elem()
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+
+	for i := 0; i+1 < len(list); i += 2 {
+		elem := reflect.New(m.Type().Elem())
+		if err := json.Unmarshal(list[i+1], &data{Value: elem.Interface()}); err != nil {
+			return err
+		}
+		m.SetMapIndex(reflect.ValueOf(string(list[i])).Convert(m.Type().Key()), elem.Elem())
+	}
+	return nil
+}
+
+func (s *RedisKVStorage) DelField(key string, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	args := []interface{}{s.op.Prefix(key)}
+	for _, field := range fields {
+		args = append(args, field)
+	}
+
+	_, err := s.op.Exec(redis1.Command("HDEL", args...))
+	return err
+}
@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRedisKVStorageField(t *testing.T) {
+	c := NewRedisKVStorage(r)
+
+	key := "profile"
+
+	t.Run("store and load field", func(t *testing.T) {
+		NewWithT(t).Expect(c.Del(key)).To(BeNil())
+		NewWithT(t).Expect(c.StoreField(key, "name", "name", -1)).To(BeNil())
+		NewWithT(t).Expect(c.StoreField(key, "city", "city", -1)).To(BeNil())
+
+		name := ""
+		NewWithT(t).Expect(c.LoadField(key, "name", &name)).To(BeNil())
+		NewWithT(t).Expect(name).To(Equal("name"))
+
+		city, missing := "", ""
+		NewWithT(t).Expect(c.LoadFields(key, map[string]interface{}{"city": &city, "missing": &missing})).To(BeNil())
+		NewWithT(t).Expect(city).To(Equal("city"))
+		NewWithT(t).Expect(missing).To(BeEmpty())
+
+		all := map[string]string{}
+		NewWithT(t).Expect(c.LoadAll(key, &all)).To(BeNil())
+		NewWithT(t).Expect(all).To(Equal(map[string]string{"name": "name", "city": "city"}))
+	})
+
+	t.Run("del field", func(t *testing.T) {
+		NewWithT(t).Expect(c.DelField(key, "name")).To(BeNil())
+
+		all := map[string]string{}
+		NewWithT(t).Expect(c.LoadAll(key, &all)).To(BeNil())
+		NewWithT(t).Expect(all).To(Equal(map[string]string{"city": "city"}))
+
+		NewWithT(t).Expect(c.Del(key)).To(BeNil())
+	})
+
+	t.Run("key level ttl", func(t *testing.T) {
+		NewWithT(t).Expect(c.StoreField(key, "name", "name", 1*time.Second)).To(BeNil())
+		time.Sleep(2 * time.Second)
+
+		all := map[string]string{}
+		NewWithT(t).Expect(c.LoadAll(key, &all)).To(BeNil())
+		NewWithT(t).Expect(all).To(BeEmpty())
+	})
+}
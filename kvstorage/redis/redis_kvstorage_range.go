@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/zj-open-source/helper/kvstorage"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+var _ kvstorage.RangeKVStorage = (*RedisKVStorage)(nil)
+
+// Range scans the keys under the prefix of the operator with SCAN on each of its servers, keys are passed to fn without the prefix
+func (s *RedisKVStorage) Range(fn func(key string, ttl time.Duration) bool) error {
+	match := globEscaper.Replace(s.op.Prefix("")) + "*"
+	ctx := s.Context()
+	stopped := false
+
+	err := redis1.RangeNodes(ctx, s.op, func(c redis1.Conn) error {
+		cursor := int64(0)
+
+		for {
+			values, err := redis.Values(redis.DoContext(c, ctx, "SCAN", cursor, "MATCH", match, "COUNT", 100))
+			if err != nil {
+				return err
+			}
+
+			cursor, err = redis.Int64(values[0], nil)
+			if err != nil {
+				return err
+			}
+
+			keys, err := redis.Strings(values[1], nil)
+			if err != nil {
+				return err
+			}
+
+			for _, key := range keys {
+				pttl, err := redis.Int64(s.op.Exec(redis1.Command("PTTL", key)))
+				if err != nil {
+					return err
+				}
+				// -2 means the key is gone since SCAN
+				if pttl == -2 {
+					continue
+				}
+
+				ttl := time.Duration(0)
+				if pttl > 0 {
+					ttl = time.Duration(pttl) * time.Millisecond
+				}
+
+				if !fn(redis1.Unprefix(s.op, key), ttl) {
+					stopped = true
+					return errStopRange
+				}
+			}
+
+			if cursor == 0 {
+				return nil
+			}
+		}
+	})
+	if stopped {
+		return nil
+	}
+	return err
+}
+
+// errStopRange stops RangeNodes once fn returns false
+var errStopRange = errors.New("stop range")
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
@@ -1,16 +1,23 @@
 package redis
 
 import (
+	"context"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/gomodule/redigo/redis"
 	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/kvstorage"
 	redis1 "github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
 )
 
+var server = redistest.NewServer()
+
 var r = &redis1.Redis{
-	Host: "redis",
-	Port: 6379,
+	Host: server.Host(),
+	Port: server.Port(),
 }
 
 func init() {
@@ -56,4 +63,113 @@ func TestRedisKVStorage(t *testing.T) {
 			NewWithT(t).Expect(v).To(BeEmpty())
 		}
 	})
+
+	t.Run("store nx", func(t *testing.T) {
+		NewWithT(t).Expect(c.Del(key)).To(BeNil())
+
+		stored, err := c.StoreNX(key, value, -1)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(stored).To(BeTrue())
+
+		stored, err = c.StoreNX(key, "other", -1)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(stored).To(BeFalse())
+
+		v := ""
+		NewWithT(t).Expect(c.LoadAndDel(key, &v)).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal(value))
+	})
+
+	t.Run("range", func(t *testing.T) {
+		NewWithT(t).Expect(c.Store(key, value, time.Hour)).To(BeNil())
+
+		ttls := map[string]time.Duration{}
+		NewWithT(t).Expect(c.Range(func(key string, ttl time.Duration) bool {
+			ttls[key] = ttl
+			return true
+		})).To(BeNil())
+		NewWithT(t).Expect(ttls[key]).To(BeNumerically(">", 59*time.Minute))
+
+		NewWithT(t).Expect(c.Del(key)).To(BeNil())
+	})
+}
+
+func TestMigrateTTL(t *testing.T) {
+	newStorage := func(prefix string) *RedisKVStorage {
+		op := &redis1.Redis{Host: server.Host(), Port: server.Port(), KeyPrefix: prefix}
+		op.SetDefaults()
+		op.Init()
+		return NewRedisKVStorage(op)
+	}
+
+	src, dst := newStorage("migrate-src"), newStorage("migrate-dst")
+
+	NewWithT(t).Expect(src.Store("short", "1", 500*time.Millisecond)).To(BeNil())
+	NewWithT(t).Expect(src.Store("long", "2", 1500*time.Millisecond)).To(BeNil())
+
+	report, err := kvstorage.Migrate(context.Background(), src, dst, kvstorage.MigrateOptions{})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(report.Copied).To(Equal(2))
+
+	pttl := func(key string) int64 {
+		ms, err := redis.Int64(dst.op.Exec(redis1.Command("PTTL", dst.op.Prefix(key))))
+		NewWithT(t).Expect(err).To(BeNil())
+		return ms
+	}
+
+	// sub-second TTLs aren't rounded down to 0, which would delete the key, nor longer ones to whole seconds
+	NewWithT(t).Expect(pttl("short")).To(And(BeNumerically(">", 0), BeNumerically("<=", 500)))
+	NewWithT(t).Expect(pttl("long")).To(BeNumerically(">", 1000))
+
+	v := ""
+	NewWithT(t).Expect(dst.Load("short", &v)).To(BeNil())
+	NewWithT(t).Expect(v).To(Equal("1"))
+}
+
+func TestRangeSharded(t *testing.T) {
+	newSharded := func(prefix string) (*RedisKVStorage, map[string]redis1.RedisOperator) {
+		shards := map[string]redis1.RedisOperator{}
+		for i := 0; i < 3; i++ {
+			s := redistest.NewServer()
+			t.Cleanup(s.Close)
+			op := &redis1.Redis{Host: s.Host(), Port: s.Port(), KeyPrefix: prefix}
+			op.SetDefaults()
+			op.Init()
+			shards[fmt.Sprintf("shard-%d", i)] = op
+		}
+		return NewRedisKVStorage(redis1.NewShardedRedis(shards, 0)), shards
+	}
+
+	src, shards := newSharded("sharded-src")
+	for i := 0; i < 30; i++ {
+		NewWithT(t).Expect(src.Store(fmt.Sprintf("key-%d", i), i, time.Hour)).To(BeNil())
+	}
+
+	// the keys are spread over every shard, which are all scanned
+	for _, op := range shards {
+		NewWithT(t).Expect(redis.Int(op.Exec(redis1.Command("DBSIZE")))).To(BeNumerically(">", 0))
+	}
+
+	keys := map[string]bool{}
+	NewWithT(t).Expect(src.Range(func(key string, ttl time.Duration) bool {
+		keys[key] = true
+		return true
+	})).To(BeNil())
+	NewWithT(t).Expect(keys).To(HaveLen(30))
+
+	visited := 0
+	NewWithT(t).Expect(src.Range(func(key string, ttl time.Duration) bool {
+		visited++
+		return visited < 5
+	})).To(BeNil())
+	NewWithT(t).Expect(visited).To(Equal(5))
+
+	dst, _ := newSharded("sharded-dst")
+	report, err := kvstorage.Migrate(context.Background(), src, dst, kvstorage.MigrateOptions{})
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(report.Copied).To(Equal(30))
+
+	v := 0
+	NewWithT(t).Expect(dst.Load("key-29", &v)).To(BeNil())
+	NewWithT(t).Expect(v).To(Equal(29))
 }
@@ -0,0 +1,136 @@
+package probabilistic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/gomodule/redigo/redis"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+// maxBits is the size limit of a Redis string, 512MB
+const maxBits = 1 << 32
+
+// NewBloomFilter creates a Bloom filter named name sized for expectedItems with a false positive rate of errorRate
+func NewBloomFilter(op redis1.RedisOperator, name string, expectedItems uint64, errorRate float64) *BloomFilter {
+	f := &BloomFilter{
+		op:            op,
+		Name:          name,
+		ExpectedItems: expectedItems,
+		ErrorRate:     errorRate,
+	}
+	f.SetDefaults()
+	return f
+}
+
+// BloomFilter tells whether an item was probably added or certainly wasn't, on a Redis bitmap shared by replicas.
+// Every item sets Hashes bits, so filters sharing Name must use the same Bits and Hashes.
+type BloomFilter struct {
+	op   redis1.RedisOperator
+	Name string
+	// ExpectedItems and ErrorRate size the filter when Bits and Hashes are 0,
+	// more items than expected make false positives more likely than ErrorRate
+	ExpectedItems uint64
+	ErrorRate     float64
+	// Bits is the size of the bitmap, at most 2^32
+	Bits uint64
+	// Hashes is the number of bits set per item
+	Hashes int
+}
+
+func (f *BloomFilter) SetDefaults() {
+	if f.ExpectedItems == 0 {
+		f.ExpectedItems = 1000000
+	}
+	if f.ErrorRate <= 0 || f.ErrorRate >= 1 {
+		f.ErrorRate = 0.01
+	}
+	n := float64(f.ExpectedItems)
+	if f.Bits == 0 {
+		f.Bits = uint64(math.Ceil(-n * math.Log(f.ErrorRate) / (math.Ln2 * math.Ln2)))
+	}
+	if f.Bits > maxBits {
+		f.Bits = maxBits
+	}
+	if f.Hashes <= 0 {
+		f.Hashes = int(math.Round(float64(f.Bits) / n * math.Ln2))
+		if f.Hashes < 1 {
+			f.Hashes = 1
+		}
+	}
+}
+
+func (f *BloomFilter) key() string {
+	return f.op.Prefix(fmt.Sprintf("bloom:{%s}", f.Name))
+}
+
+// offsets returns the bits of item, derived from two 64 bits of its SHA-256 as h1 + i*h2
+func (f *BloomFilter) offsets(item string) []uint64 {
+	sum := sha256.Sum256([]byte(item))
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16])
+
+	offsets := make([]uint64, f.Hashes)
+	for i := range offsets {
+		offsets[i] = (h1 + uint64(i)*h2) % f.Bits
+	}
+	return offsets
+}
+
+// Add adds item and reports whether it is new, false means it was probably added before
+func (f *BloomFilter) Add(ctx context.Context, item string) (bool, error) {
+	offsets := f.offsets(item)
+	cmds := make([]*redis1.CMD, len(offsets))
+	for i, offset := range offsets {
+		cmds[i] = redis1.Command("SETBIT", f.key(), offset, 1)
+	}
+
+	previous, err := f.exec(ctx, cmds)
+	if err != nil {
+		return false, err
+	}
+	for _, bit := range previous {
+		if bit == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Contains reports whether item was probably added, false means it certainly wasn't
+func (f *BloomFilter) Contains(ctx context.Context, item string) (bool, error) {
+	offsets := f.offsets(item)
+	cmds := make([]*redis1.CMD, len(offsets))
+	for i, offset := range offsets {
+		cmds[i] = redis1.Command("GETBIT", f.key(), offset)
+	}
+
+	bits, err := f.exec(ctx, cmds)
+	if err != nil {
+		return false, err
+	}
+	for _, bit := range bits {
+		if bit == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Clear removes all the items
+func (f *BloomFilter) Clear(ctx context.Context) error {
+	_, err := f.op.ExecContext(ctx, redis1.Command("DEL", f.key()))
+	return err
+}
+
+// exec runs the bit commands in a transaction and returns their bits
+func (f *BloomFilter) exec(ctx context.Context, cmds []*redis1.CMD) ([]int, error) {
+	if len(cmds) == 1 {
+		bit, err := redis.Int(f.op.ExecContext(ctx, cmds[0]))
+		return []int{bit}, err
+	}
+	return redis.Ints(f.op.ExecContext(ctx, cmds[0], cmds[1:]...))
+}
@@ -0,0 +1,67 @@
+package probabilistic
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+var server = redistest.NewServer()
+
+var r = &redis.Redis{
+	Host: server.Host(),
+	Port: server.Port(),
+}
+
+func init() {
+	r.SetDefaults()
+	r.Init()
+}
+
+func TestBloomFilter(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+
+	f := NewBloomFilter(r, "seen", 1000, 0.01)
+	NewWithT(t).Expect(f.Bits).To(Equal(uint64(9586)))
+	NewWithT(t).Expect(f.Hashes).To(Equal(7))
+
+	// an item whose bits were all set by others looks added already
+	collisions := 0
+	for i := 0; i < 1000; i++ {
+		added, err := f.Add(ctx, fmt.Sprintf("id-%d", i))
+		NewWithT(t).Expect(err).To(BeNil())
+		if !added {
+			collisions++
+		}
+	}
+	NewWithT(t).Expect(collisions).To(BeNumerically("<", 30))
+
+	added, err := f.Add(ctx, "id-0")
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(added).To(BeFalse())
+
+	for i := 0; i < 1000; i++ {
+		NewWithT(t).Expect(f.Contains(ctx, fmt.Sprintf("id-%d", i))).To(BeTrue())
+	}
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		ok, err := f.Contains(ctx, fmt.Sprintf("other-%d", i))
+		NewWithT(t).Expect(err).To(BeNil())
+		if ok {
+			falsePositives++
+		}
+	}
+	NewWithT(t).Expect(falsePositives).To(BeNumerically("<", 30))
+
+	// shared by replicas with the same name
+	NewWithT(t).Expect(NewBloomFilter(r, "seen", 1000, 0.01).Contains(ctx, "id-1")).To(BeTrue())
+
+	NewWithT(t).Expect(f.Clear(ctx)).To(BeNil())
+	NewWithT(t).Expect(f.Contains(ctx, "id-1")).To(BeFalse())
+}
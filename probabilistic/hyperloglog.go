@@ -0,0 +1,70 @@
+package probabilistic
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+func NewHyperLogLog(op redis1.RedisOperator, name string) *HyperLogLog {
+	return &HyperLogLog{
+		op:   op,
+		Name: name,
+	}
+}
+
+// HyperLogLog counts distinct elements approximately, with a standard error of 0.81% in 12KB at most
+type HyperLogLog struct {
+	op   redis1.RedisOperator
+	Name string
+}
+
+func (h *HyperLogLog) key() string {
+	return h.op.Prefix(fmt.Sprintf("hll:%s", h.Name))
+}
+
+// Add adds elements and reports whether the count changed
+func (h *HyperLogLog) Add(ctx context.Context, elements ...string) (bool, error) {
+	args := make([]interface{}, 0, len(elements)+1)
+	args = append(args, h.key())
+	for _, e := range elements {
+		args = append(args, e)
+	}
+	changed, err := redis.Int(h.op.ExecContext(ctx, redis1.Command("PFADD", args...)))
+	return changed == 1, err
+}
+
+// Count returns the number of distinct elements added
+func (h *HyperLogLog) Count(ctx context.Context) (int64, error) {
+	return redis.Int64(h.op.ExecContext(ctx, redis1.Command("PFCOUNT", h.key())))
+}
+
+// CountUnion returns the number of distinct elements added to h or others, without changing them.
+// On a cluster or a sharded operator the keys must be on the same shard, which the hash tag {tag} in names does,
+// otherwise nothing runs and redis.ErrCrossSlot or redis.ErrCrossShard is returned.
+func (h *HyperLogLog) CountUnion(ctx context.Context, others ...*HyperLogLog) (int64, error) {
+	return redis.Int64(h.op.ExecContext(ctx, redis1.Command("PFCOUNT", h.keys(others)...)))
+}
+
+// Merge adds the elements of others to h, see CountUnion for clusters
+func (h *HyperLogLog) Merge(ctx context.Context, others ...*HyperLogLog) error {
+	_, err := h.op.ExecContext(ctx, redis1.Command("PFMERGE", h.keys(others)...))
+	return err
+}
+
+// Clear removes all the elements
+func (h *HyperLogLog) Clear(ctx context.Context) error {
+	_, err := h.op.ExecContext(ctx, redis1.Command("DEL", h.key()))
+	return err
+}
+
+func (h *HyperLogLog) keys(others []*HyperLogLog) []interface{} {
+	keys := make([]interface{}, 0, len(others)+1)
+	keys = append(keys, h.key())
+	for _, o := range others {
+		keys = append(keys, o.key())
+	}
+	return keys
+}
@@ -0,0 +1,79 @@
+package probabilistic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+
+	monday := NewHyperLogLog(r, "{visitors}:monday")
+	tuesday := NewHyperLogLog(r, "{visitors}:tuesday")
+	week := NewHyperLogLog(r, "{visitors}:week")
+
+	NewWithT(t).Expect(monday.Add(ctx, "a", "b", "c")).To(BeTrue())
+	NewWithT(t).Expect(monday.Add(ctx, "a")).To(BeFalse())
+	NewWithT(t).Expect(tuesday.Add(ctx, "c", "d")).To(BeTrue())
+
+	NewWithT(t).Expect(monday.Count(ctx)).To(Equal(int64(3)))
+	NewWithT(t).Expect(monday.CountUnion(ctx, tuesday)).To(Equal(int64(4)))
+
+	NewWithT(t).Expect(week.Merge(ctx, monday, tuesday)).To(BeNil())
+	NewWithT(t).Expect(week.Count(ctx)).To(Equal(int64(4)))
+
+	NewWithT(t).Expect(monday.Clear(ctx)).To(BeNil())
+	NewWithT(t).Expect(monday.Count(ctx)).To(Equal(int64(0)))
+
+	keys, err := r.Exec(redis.Command("KEYS", "*"))
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(keys).To(ConsistOf([]byte(r.Prefix("hll:{visitors}:tuesday")), []byte(r.Prefix("hll:{visitors}:week"))))
+}
+
+func TestHyperLogLogSharded(t *testing.T) {
+	ctx := context.Background()
+
+	operators := map[string]redis.RedisOperator{}
+	for _, name := range []string{"a", "b"} {
+		s := redistest.NewServer()
+		defer s.Close()
+
+		op := &redis.Redis{Host: s.Host(), Port: s.Port()}
+		op.SetDefaults()
+		op.Init()
+		operators[name] = op
+	}
+	sharded := redis.NewShardedRedis(operators, 0)
+
+	// logs of both shards
+	var logs []*HyperLogLog
+	shards := map[redis.RedisOperator]bool{}
+	for i := 0; len(shards) < 2; i++ {
+		h := NewHyperLogLog(sharded, fmt.Sprint("visitors", i))
+		if shard := sharded.Shard(h.key()); !shards[shard] {
+			shards[shard] = true
+			logs = append(logs, h)
+		}
+	}
+
+	for _, h := range logs {
+		NewWithT(t).Expect(h.Add(ctx, h.Name)).To(BeTrue())
+	}
+
+	_, err := logs[0].CountUnion(ctx, logs[1])
+	NewWithT(t).Expect(errors.Is(err, redis.ErrCrossShard)).To(BeTrue())
+	NewWithT(t).Expect(errors.Is(logs[0].Merge(ctx, logs[1]), redis.ErrCrossShard)).To(BeTrue())
+	NewWithT(t).Expect(logs[0].Count(ctx)).To(Equal(int64(1)))
+
+	monday, tuesday := NewHyperLogLog(sharded, "{visitors}:monday"), NewHyperLogLog(sharded, "{visitors}:tuesday")
+	NewWithT(t).Expect(monday.Add(ctx, "a", "b")).To(BeTrue())
+	NewWithT(t).Expect(tuesday.Add(ctx, "b", "c")).To(BeTrue())
+	NewWithT(t).Expect(monday.CountUnion(ctx, tuesday)).To(Equal(int64(3)))
+}
@@ -0,0 +1,264 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+func NewPubSub(op redis1.RedisOperator) *PubSub {
+	p := &PubSub{
+		op: op,
+	}
+	p.SetDefaults()
+	return p
+}
+
+// PubSub publishes messages and delivers the messages of subscriptions on Go channels.
+// A subscription holds a connection dialed by redis.DialContext while it lasts, outside of the pool of the operator so Exec keeps its connections,
+// and reconnects and subscribes again when the connection fails.
+// Messages published while reconnecting are lost, as Redis doesn't keep them.
+type PubSub struct {
+	op redis1.RedisOperator
+	// Prefixed applies the key prefix of the operator to channels and patterns, so projects and environments sharing Redis don't see each other.
+	// Channels of received messages are returned without it.
+	Prefixed bool
+	// HealthCheckInterval is how often a subscribed connection is pinged, it is reconnected when no reply comes within twice of it
+	HealthCheckInterval time.Duration
+	// MinBackoff and MaxBackoff bound the wait between reconnects, which doubles on every failure
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// BufferSize is the capacity of the channels returned by Subscribe
+	BufferSize int
+	// OnError is called with the errors which make a subscription reconnect, by default they are logged
+	OnError func(err error)
+}
+
+func (p *PubSub) SetDefaults() {
+	if p.HealthCheckInterval == 0 {
+		p.HealthCheckInterval = 15 * time.Second
+	}
+	if p.MinBackoff == 0 {
+		p.MinBackoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 30 * time.Second
+	}
+	if p.BufferSize == 0 {
+		p.BufferSize = 100
+	}
+	if p.OnError == nil {
+		p.OnError = func(err error) {
+			logrus.Warnf("pubsub: reconnecting: %s", err)
+		}
+	}
+}
+
+type Message struct {
+	Channel string
+	// Pattern is the pattern which matched Channel, empty for subscriptions to channels
+	Pattern string
+	Data    []byte
+}
+
+// Publish sends message to channel and returns the number of clients which received it
+func (p *PubSub) Publish(ctx context.Context, channel string, message interface{}) (int, error) {
+	return redis.Int(p.op.ExecContext(ctx, redis1.Command("PUBLISH", p.channel(channel), message)))
+}
+
+// Subscribe delivers the messages of channels until ctx is done, then the returned channel is closed
+func (p *PubSub) Subscribe(ctx context.Context, channels ...string) <-chan Message {
+	return p.Listen(ctx, channels, nil)
+}
+
+// PSubscribe delivers the messages of the channels matching patterns until ctx is done, then the returned channel is closed
+func (p *PubSub) PSubscribe(ctx context.Context, patterns ...string) <-chan Message {
+	return p.Listen(ctx, nil, patterns)
+}
+
+// Listen delivers the messages of channels and of the channels matching patterns until ctx is done, then the returned channel is closed
+func (p *PubSub) Listen(ctx context.Context, channels []string, patterns []string) <-chan Message {
+	s := &subscription{
+		p:        p,
+		channels: make([]interface{}, 0, len(channels)),
+		patterns: make([]interface{}, 0, len(patterns)),
+		out:      make(chan Message, p.BufferSize),
+	}
+	for _, ch := range channels {
+		s.channels = append(s.channels, p.channel(ch))
+	}
+	for _, pattern := range patterns {
+		s.patterns = append(s.patterns, p.pattern(pattern))
+	}
+
+	go s.run(ctx)
+
+	return s.out
+}
+
+func (p *PubSub) channel(ch string) string {
+	if p.Prefixed {
+		return p.op.Prefix(ch)
+	}
+	return ch
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func (p *PubSub) pattern(pattern string) string {
+	if p.Prefixed {
+		prefix := p.op.Prefix("")
+		return globEscaper.Replace(prefix) + pattern
+	}
+	return pattern
+}
+
+func (p *PubSub) unprefix(ch string, pattern string) (string, string) {
+	if !p.Prefixed {
+		return ch, pattern
+	}
+	prefix := p.op.Prefix("")
+	ch = strings.TrimPrefix(ch, prefix)
+	if pattern != "" {
+		pattern = strings.TrimPrefix(pattern, globEscaper.Replace(prefix))
+	}
+	return ch, pattern
+}
+
+type subscription struct {
+	p        *PubSub
+	channels []interface{}
+	patterns []interface{}
+	out      chan Message
+}
+
+func (s *subscription) run(ctx context.Context) {
+	defer close(s.out)
+
+	backoff := s.p.MinBackoff
+
+	for {
+		subscribed, err := s.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if subscribed {
+			backoff = s.p.MinBackoff
+		}
+		s.p.OnError(err)
+
+		// jitter keeps the subscribers of a restarted Redis from reconnecting all at once
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > s.p.MaxBackoff {
+			backoff = s.p.MaxBackoff
+		}
+	}
+}
+
+var (
+	errNoConn       = errors.New("pubsub: no connection, the operator may not be initialized")
+	errUnsubscribed = errors.New("pubsub: unsubscribed by the server")
+)
+
+// session subscribes on a new connection and delivers messages until the connection fails or ctx is done,
+// subscribed reports whether all subscriptions were confirmed
+func (s *subscription) session(ctx context.Context) (subscribed bool, err error) {
+	conn, err := redis1.DialContext(ctx, s.p.op)
+	if err != nil {
+		return false, err
+	}
+	if conn == nil {
+		return false, errNoConn
+	}
+	defer conn.Close()
+
+	psc := redis.PubSubConn{Conn: conn}
+
+	if len(s.channels) > 0 {
+		if err := psc.Subscribe(s.channels...); err != nil {
+			return false, err
+		}
+	}
+	if len(s.patterns) > 0 {
+		if err := psc.PSubscribe(s.patterns...); err != nil {
+			return false, err
+		}
+	}
+
+	// the connection is written by the pinger and read here, as redigo allows one writer and one reader at the same time
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(s.p.HealthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				// the replies make the reader return
+				_ = psc.Unsubscribe()
+				_ = psc.PUnsubscribe()
+				return
+			case <-ticker.C:
+				if err := psc.Ping(""); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	pending := len(s.channels) + len(s.patterns)
+
+	for {
+		switch v := psc.ReceiveWithTimeout(2 * s.p.HealthCheckInterval).(type) {
+		case error:
+			return subscribed, v
+		case redis.Subscription:
+			switch v.Kind {
+			case "subscribe", "psubscribe":
+				pending--
+				subscribed = pending == 0
+			case "unsubscribe", "punsubscribe":
+				if v.Count == 0 {
+					if ctx.Err() != nil {
+						return subscribed, ctx.Err()
+					}
+					return subscribed, errUnsubscribed
+				}
+			}
+		case redis.Message:
+			msg := Message{Data: v.Data}
+			msg.Channel, msg.Pattern = s.p.unprefix(v.Channel, v.Pattern)
+
+			select {
+			case s.out <- msg:
+			case <-ctx.Done():
+				return subscribed, ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+var server = redistest.NewServer()
+
+var r = &redis.Redis{
+	Host: server.Host(),
+	Port: server.Port(),
+}
+
+func init() {
+	r.SetDefaults()
+	r.Init()
+}
+
+func receive(t *testing.T, messages <-chan Message) Message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+	return Message{}
+}
+
+// publish retries until a subscriber receives the message, as subscribing is asynchronous,
+// errors are retried too, as idle connections of the pool are broken by DropConnections
+func publish(t *testing.T, p *PubSub, channel string, message string) {
+	for i := 0; i < 100; i++ {
+		n, err := p.Publish(context.Background(), channel, message)
+		if err == nil && n > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no subscriber")
+}
+
+func TestPubSub(t *testing.T) {
+	p := NewPubSub(r)
+	p.HealthCheckInterval = 50 * time.Millisecond
+	p.MinBackoff = 10 * time.Millisecond
+	p.OnError = func(err error) {}
+
+	t.Run("subscribe", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		messages := p.Subscribe(ctx, "news")
+
+		publish(t, p, "news", "hello")
+		NewWithT(t).Expect(receive(t, messages)).To(Equal(Message{Channel: "news", Data: []byte("hello")}))
+
+		// pings keep an idle subscription alive
+		time.Sleep(150 * time.Millisecond)
+		publish(t, p, "news", "still there")
+		NewWithT(t).Expect(string(receive(t, messages).Data)).To(Equal("still there"))
+
+		cancel()
+		_, open := <-messages
+		NewWithT(t).Expect(open).To(BeFalse())
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		messages := p.PSubscribe(ctx, "user.*")
+		publish(t, p, "user.1", "before")
+		NewWithT(t).Expect(receive(t, messages)).To(Equal(Message{Channel: "user.1", Pattern: "user.*", Data: []byte("before")}))
+
+		server.DropConnections()
+
+		publish(t, p, "user.2", "after")
+		NewWithT(t).Expect(receive(t, messages)).To(Equal(Message{Channel: "user.2", Pattern: "user.*", Data: []byte("after")}))
+	})
+
+	t.Run("prefixed", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		prefixed := NewPubSub(r)
+		prefixed.Prefixed = true
+
+		messages := prefixed.Listen(ctx, []string{"events"}, []string{"jobs.*"})
+
+		publish(t, prefixed, "events", "1")
+		NewWithT(t).Expect(receive(t, messages)).To(Equal(Message{Channel: "events", Data: []byte("1")}))
+
+		publish(t, prefixed, "jobs.a", "2")
+		NewWithT(t).Expect(receive(t, messages)).To(Equal(Message{Channel: "jobs.a", Pattern: "jobs.*", Data: []byte("2")}))
+
+		n, err := p.Publish(ctx, "events", "not prefixed")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(n).To(Equal(0))
+	})
+
+	t.Run("subscriptions don't take the connections of the pool", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		small := &redis.Redis{Host: server.Host(), Port: server.Port(), MaxActive: 1}
+		small.SetDefaults()
+		small.Init()
+
+		p := NewPubSub(small)
+		subscriptions := make([]<-chan Message, 3)
+		for i := range subscriptions {
+			subscriptions[i] = p.Subscribe(ctx, "pool")
+		}
+
+		for i := 0; i < 100; i++ {
+			n, err := p.Publish(ctx, "pool", "all")
+			NewWithT(t).Expect(err).To(BeNil())
+			if n == len(subscriptions) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, messages := range subscriptions {
+			NewWithT(t).Expect(string(receive(t, messages).Data)).To(Equal("all"))
+		}
+	})
+}
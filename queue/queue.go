@@ -0,0 +1,349 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+// ErrJobLost is returned when a job is no longer processing, usually because its visibility timeout passed
+// and it was requeued for another worker
+var ErrJobLost = errors.New("queue: job is no longer processing")
+
+// NewQueue creates a queue named name, all its keys share the hash tag {name} so they live on the same shard.
+func NewQueue(op redis1.RedisOperator, name string) *Queue {
+	q := &Queue{
+		op:   op,
+		Name: name,
+	}
+	q.SetDefaults()
+	return q
+}
+
+// Queue is a reliable job queue on Redis lists.
+// Dequeue moves a job from the ready list into the processing list, where it stays until it is acked,
+// nacked or its visibility timeout passes and Requeue moves it back, so a job is delivered at least once.
+type Queue struct {
+	op   redis1.RedisOperator
+	Name string
+	// VisibilityTimeout is how long a dequeued job may be processed before it is handed to another worker, 30s by default
+	VisibilityTimeout time.Duration
+	// MaxAttempts is the number of failed deliveries after which a job is moved to the dead-letter list
+	MaxAttempts int
+
+	mu sync.Mutex
+	// suspects are the processing jobs seen without a deadline on the last run of trackOrphans
+	suspects map[string]bool
+}
+
+func (q *Queue) SetDefaults() {
+	if q.VisibilityTimeout <= 0 {
+		q.VisibilityTimeout = 30 * time.Second
+	}
+	if q.MaxAttempts == 0 {
+		q.MaxAttempts = 5
+	}
+}
+
+func (q *Queue) key(name string) string {
+	return q.op.Prefix(fmt.Sprintf("queue:{%s}:%s", q.Name, name))
+}
+
+func (q *Queue) readyKey() string {
+	return q.key("ready")
+}
+
+func (q *Queue) processingKey() string {
+	return q.key("processing")
+}
+
+// deadlinesKey is a sorted set of the processing jobs scored by the unix milliseconds their visibility timeout passes
+func (q *Queue) deadlinesKey() string {
+	return q.key("deadlines")
+}
+
+func (q *Queue) deadKey() string {
+	return q.key("dead")
+}
+
+type Job struct {
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+	// Attempts is the number of deliveries of the job which failed or timed out
+	Attempts   int       `json:"attempts"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+	// LastError is the error of the last failed delivery
+	LastError string `json:"lastError,omitempty"`
+
+	// raw is the job as stored in the lists, which identifies the delivery
+	raw []byte
+}
+
+// Decode unmarshals the payload of the job into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+func (j *Job) encode() ([]byte, error) {
+	raw, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	j.raw = raw
+	return raw, nil
+}
+
+func decodeJob(raw []byte) (*Job, error) {
+	j := &Job{}
+	if err := json.Unmarshal(raw, j); err != nil {
+		return nil, err
+	}
+	j.raw = raw
+	return j, nil
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (q *Queue) Enqueue(ctx context.Context, payload interface{}) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Job{
+		ID:         newJobID(),
+		Payload:    data,
+		EnqueuedAt: time.Now(),
+	}
+
+	raw, err := j.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := q.op.ExecContext(ctx, redis1.Command("LPUSH", q.readyKey(), raw)); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Dequeue waits up to timeout for a job and moves it into the processing list, it returns nil without a job.
+// timeout is rounded up to whole milliseconds, timeout <= 0 doesn't wait.
+func (q *Queue) Dequeue(ctx context.Context, timeout time.Duration) (*Job, error) {
+	var reply interface{}
+	var err error
+
+	if timeout > 0 {
+		reply, err = q.op.ExecContext(ctx, redis1.Command("BLMOVE", q.readyKey(), q.processingKey(), "RIGHT", "LEFT", toSeconds(timeout)))
+	} else {
+		reply, err = q.op.ExecContext(ctx, redis1.Command("LMOVE", q.readyKey(), q.processingKey(), "RIGHT", "LEFT"))
+	}
+
+	raw, err := redis.Bytes(reply, err)
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// a job moved without a deadline, when this fails, is given one by Requeue
+	if _, err := q.op.ExecContext(ctx, redis1.Command("ZADD", q.deadlinesKey(), deadline(time.Now().Add(q.VisibilityTimeout)), raw)); err != nil {
+		return nil, err
+	}
+
+	return decodeJob(raw)
+}
+
+// Extend pushes the visibility timeout of a processing job to d from now
+func (q *Queue) Extend(ctx context.Context, j *Job, d time.Duration) error {
+	n, err := redis.Int(q.op.ExecContext(ctx, redis1.Command("ZADD", q.deadlinesKey(), "XX", "CH", deadline(time.Now().Add(d)), j.raw)))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// Ack removes a processed job from the queue
+func (q *Queue) Ack(ctx context.Context, j *Job) error {
+	return q.move(ctx, j.raw, nil)
+}
+
+// Nack puts a failed job back into the ready list, or into the dead-letter list once it failed MaxAttempts times
+func (q *Queue) Nack(ctx context.Context, j *Job, cause error) error {
+	retried, err := q.retry(j, cause)
+	if err != nil {
+		return err
+	}
+	return q.move(ctx, j.raw, q.push(retried))
+}
+
+func (q *Queue) retry(j *Job, cause error) (*Job, error) {
+	retried := *j
+	retried.Attempts++
+	if cause != nil {
+		retried.LastError = cause.Error()
+	}
+	if _, err := retried.encode(); err != nil {
+		return nil, err
+	}
+	return &retried, nil
+}
+
+func (q *Queue) push(j *Job) *redis1.CMD {
+	if j.Attempts >= q.MaxAttempts {
+		return redis1.Command("LPUSH", q.deadKey(), j.raw)
+	}
+	// pushed to the right, so a retried job is the next to be dequeued
+	return redis1.Command("RPUSH", q.readyKey(), j.raw)
+}
+
+// move claims a processing job by removing its deadline, then removes it from the processing list together with then.
+// Only one of the concurrent acks, nacks and requeues of a job wins the claim, the others get ErrJobLost.
+// A job claimed by a process which died before removing it is left without a deadline and is requeued as an orphan.
+func (q *Queue) move(ctx context.Context, raw []byte, then *redis1.CMD) error {
+	n, err := redis.Int(q.op.ExecContext(ctx, redis1.Command("ZREM", q.deadlinesKey(), raw)))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobLost
+	}
+
+	if then == nil {
+		_, err = q.op.ExecContext(ctx, redis1.Command("LREM", q.processingKey(), 1, raw))
+		return err
+	}
+	_, err = q.op.ExecContext(ctx, redis1.Command("LREM", q.processingKey(), 1, raw), then)
+	return err
+}
+
+// Requeue moves the processing jobs whose visibility timeout passed back into the ready list, or into the dead-letter list,
+// and returns how many were moved. It is run periodically by Worker.
+func (q *Queue) Requeue(ctx context.Context) (int, error) {
+	if err := q.trackOrphans(ctx); err != nil {
+		return 0, err
+	}
+
+	expired, err := redis.ByteSlices(q.op.ExecContext(ctx, redis1.Command("ZRANGEBYSCORE", q.deadlinesKey(), "-inf", deadline(time.Now()))))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, raw := range expired {
+		j, err := decodeJob(raw)
+		if err != nil {
+			return n, err
+		}
+		retried, err := q.retry(j, errors.New("visibility timeout passed"))
+		if err != nil {
+			return n, err
+		}
+		if err := q.move(ctx, raw, q.push(retried)); err != nil {
+			// acked meanwhile
+			if err == ErrJobLost {
+				continue
+			}
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// trackOrphans gives a deadline to processing jobs missing one, which happens when a process died
+// right after Dequeue moved a job or after move claimed it.
+// A job is only taken for an orphan once it was seen without a deadline on two runs in a row,
+// so jobs between the two steps of Dequeue or move are left alone.
+func (q *Queue) trackOrphans(ctx context.Context) error {
+	processing, err := redis.ByteSlices(q.op.ExecContext(ctx, redis1.Command("LRANGE", q.processingKey(), 0, -1)))
+	if err != nil {
+		return err
+	}
+
+	// the deadlines are read in one round trip
+	cmds := make([]*redis1.CMD, len(processing))
+	for i, raw := range processing {
+		cmds[i] = redis1.Command("ZSCORE", q.deadlinesKey(), raw)
+	}
+	replies, err := redis1.Pipeline(ctx, q.op, cmds...)
+	if err != nil {
+		return err
+	}
+
+	untracked := map[string]bool{}
+	for i, raw := range processing {
+		if replies[i].Err != nil {
+			return replies[i].Err
+		}
+		if replies[i].Value == nil {
+			untracked[string(raw)] = true
+		}
+	}
+
+	q.mu.Lock()
+	suspects := q.suspects
+	q.suspects = untracked
+	q.mu.Unlock()
+
+	args := []interface{}{q.deadlinesKey(), "NX"}
+	d := deadline(time.Now().Add(q.VisibilityTimeout))
+	for raw := range untracked {
+		if suspects[raw] {
+			args = append(args, d, raw)
+		}
+	}
+	if len(args) == 2 {
+		return nil
+	}
+
+	_, err = q.op.ExecContext(ctx, redis1.Command("ZADD", args...))
+	return err
+}
+
+// Len returns the number of ready jobs
+func (q *Queue) Len(ctx context.Context) (int, error) {
+	return redis.Int(q.op.ExecContext(ctx, redis1.Command("LLEN", q.readyKey())))
+}
+
+// Dead returns the jobs in the dead-letter list, the latest first
+func (q *Queue) Dead(ctx context.Context) ([]*Job, error) {
+	values, err := redis.ByteSlices(q.op.ExecContext(ctx, redis1.Command("LRANGE", q.deadKey(), 0, -1)))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, 0, len(values))
+	for _, raw := range values {
+		j, err := decodeJob(raw)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+func deadline(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func toSeconds(d time.Duration) string {
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	return fmt.Sprintf("%d.%03d", ms/1000, ms%1000)
+}
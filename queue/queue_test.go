@@ -0,0 +1,264 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+var server = redistest.NewServer()
+
+var r = &redis.Redis{
+	Host: server.Host(),
+	Port: server.Port(),
+}
+
+func init() {
+	r.SetDefaults()
+	r.Init()
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+
+	q := NewQueue(r, "test")
+	q.VisibilityTimeout = 50 * time.Millisecond
+	q.MaxAttempts = 2
+
+	t.Run("fifo with ack", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := q.Enqueue(ctx, i)
+			NewWithT(t).Expect(err).To(BeNil())
+		}
+
+		for i := 0; i < 3; i++ {
+			j, err := q.Dequeue(ctx, 10*time.Millisecond)
+			NewWithT(t).Expect(err).To(BeNil())
+
+			v := 0
+			NewWithT(t).Expect(j.Decode(&v)).To(BeNil())
+			NewWithT(t).Expect(v).To(Equal(i))
+			NewWithT(t).Expect(q.Ack(ctx, j)).To(BeNil())
+			NewWithT(t).Expect(q.Ack(ctx, j)).To(Equal(ErrJobLost))
+		}
+
+		j, err := q.Dequeue(ctx, 10*time.Millisecond)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(j).To(BeNil())
+	})
+
+	t.Run("nack until dead", func(t *testing.T) {
+		_, err := q.Enqueue(ctx, "fail")
+		NewWithT(t).Expect(err).To(BeNil())
+
+		for i := 0; i < 2; i++ {
+			j, err := q.Dequeue(ctx, 0)
+			NewWithT(t).Expect(err).To(BeNil())
+			NewWithT(t).Expect(j.Attempts).To(Equal(i))
+			NewWithT(t).Expect(q.Nack(ctx, j, errors.New("boom"))).To(BeNil())
+		}
+
+		NewWithT(t).Expect(q.Len(ctx)).To(Equal(0))
+
+		dead, err := q.Dead(ctx)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(dead).To(HaveLen(1))
+		NewWithT(t).Expect(dead[0].Attempts).To(Equal(2))
+		NewWithT(t).Expect(dead[0].LastError).To(Equal("boom"))
+	})
+
+	t.Run("requeue after visibility timeout", func(t *testing.T) {
+		_, err := q.Enqueue(ctx, "stuck")
+		NewWithT(t).Expect(err).To(BeNil())
+
+		stuck, err := q.Dequeue(ctx, 0)
+		NewWithT(t).Expect(err).To(BeNil())
+
+		NewWithT(t).Expect(q.Requeue(ctx)).To(Equal(0))
+
+		time.Sleep(60 * time.Millisecond)
+		NewWithT(t).Expect(q.Requeue(ctx)).To(Equal(1))
+
+		NewWithT(t).Expect(q.Ack(ctx, stuck)).To(Equal(ErrJobLost))
+
+		j, err := q.Dequeue(ctx, 0)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(j.ID).To(Equal(stuck.ID))
+		NewWithT(t).Expect(j.Attempts).To(Equal(1))
+
+		NewWithT(t).Expect(q.Extend(ctx, j, time.Minute)).To(BeNil())
+		time.Sleep(60 * time.Millisecond)
+		NewWithT(t).Expect(q.Requeue(ctx)).To(Equal(0))
+		NewWithT(t).Expect(q.Ack(ctx, j)).To(BeNil())
+	})
+
+	t.Run("orphans get a deadline", func(t *testing.T) {
+		_, err := q.Enqueue(ctx, "orphan")
+		NewWithT(t).Expect(err).To(BeNil())
+
+		// a worker died between moving the job and tracking it
+		_, err = r.Exec(redis.Command("LMOVE", q.readyKey(), q.processingKey(), "RIGHT", "LEFT"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		// seen once without a deadline, then tracked
+		NewWithT(t).Expect(q.Requeue(ctx)).To(Equal(0))
+		NewWithT(t).Expect(q.Requeue(ctx)).To(Equal(0))
+		time.Sleep(60 * time.Millisecond)
+		NewWithT(t).Expect(q.Requeue(ctx)).To(Equal(1))
+		NewWithT(t).Expect(q.Len(ctx)).To(Equal(1))
+	})
+}
+
+func TestWorker(t *testing.T) {
+	ctx := context.Background()
+
+	q := NewQueue(r, "worker")
+
+	for i := 0; i < 20; i++ {
+		_, err := q.Enqueue(ctx, i)
+		NewWithT(t).Expect(err).To(BeNil())
+	}
+
+	mu := sync.Mutex{}
+	handled := map[int]int{}
+	done := make(chan struct{})
+
+	w := NewWorker(q, func(ctx context.Context, j *Job) error {
+		v := 0
+		if err := j.Decode(&v); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		handled[v]++
+		if v == 0 && handled[v] == 1 {
+			return errors.New("retry once")
+		}
+		if len(handled) == 20 && handled[0] == 2 {
+			close(done)
+		}
+		return nil
+	}, 4)
+	w.PollTimeout = 10 * time.Millisecond
+	w.OnError = func(j *Job, err error) {}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	stopped := make(chan error)
+	go func() {
+		stopped <- w.Run(runCtx)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("jobs not handled")
+	}
+
+	cancel()
+	NewWithT(t).Expect(<-stopped).To(BeNil())
+
+	NewWithT(t).Expect(handled[0]).To(Equal(2))
+	NewWithT(t).Expect(q.Len(ctx)).To(Equal(0))
+	n, err := redigo.Int(r.Exec(redis.Command("LLEN", q.processingKey())))
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(n).To(Equal(0))
+}
+
+func TestWorkerShutdownTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	q := NewQueue(r, "shutdown")
+	_, err := q.Enqueue(ctx, "slow")
+	NewWithT(t).Expect(err).To(BeNil())
+
+	started := make(chan struct{})
+	w := NewWorker(q, func(ctx context.Context, j *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, 1)
+	w.PollTimeout = 10 * time.Millisecond
+	w.ShutdownTimeout = 20 * time.Millisecond
+	w.OnError = func(j *Job, err error) {}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	stopped := make(chan error)
+	go func() {
+		stopped <- w.Run(runCtx)
+	}()
+
+	<-started
+	cancel()
+	NewWithT(t).Expect(<-stopped).NotTo(BeNil())
+
+	// the canceled job is nacked and ready again
+	NewWithT(t).Expect(q.Len(ctx)).To(Equal(1))
+}
+
+func TestWorkerHeartbeat(t *testing.T) {
+	ctx := context.Background()
+
+	q := NewQueue(r, "heartbeat")
+	q.VisibilityTimeout = 60 * time.Millisecond
+	_, err := q.Enqueue(ctx, "long")
+	NewWithT(t).Expect(err).To(BeNil())
+
+	mu := sync.Mutex{}
+	handled := 0
+	done := make(chan struct{})
+
+	// the job runs several visibility timeouts, it isn't requeued meanwhile
+	w := NewWorker(q, func(ctx context.Context, j *Job) error {
+		mu.Lock()
+		handled++
+		mu.Unlock()
+
+		time.Sleep(5 * q.VisibilityTimeout)
+		close(done)
+		return nil
+	}, 2)
+	w.PollTimeout = 10 * time.Millisecond
+	w.RequeueInterval = 10 * time.Millisecond
+	w.OnError = func(j *Job, err error) {}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	stopped := make(chan error)
+	go func() {
+		stopped <- w.Run(runCtx)
+	}()
+
+	<-done
+	cancel()
+	NewWithT(t).Expect(<-stopped).To(BeNil())
+
+	NewWithT(t).Expect(handled).To(Equal(1))
+	NewWithT(t).Expect(q.Len(ctx)).To(Equal(0))
+}
+
+func TestWorkerDefaults(t *testing.T) {
+	// a queue without visibility timeout gets the default one, which the intervals of the worker derive from
+	q := NewQueue(r, "defaults")
+	q.VisibilityTimeout = 0
+
+	w := NewWorker(q, func(ctx context.Context, j *Job) error { return nil }, 1)
+	NewWithT(t).Expect(q.VisibilityTimeout).To(Equal(30 * time.Second))
+	NewWithT(t).Expect(w.RequeueInterval).To(Equal(15 * time.Second))
+
+	q.VisibilityTimeout = time.Nanosecond
+	w = NewWorker(q, func(ctx context.Context, j *Job) error { return nil }, 1)
+	NewWithT(t).Expect(w.RequeueInterval).To(Equal(time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	NewWithT(t).Expect(w.Run(ctx)).To(BeNil())
+}
@@ -0,0 +1,232 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Handler func(ctx context.Context, j *Job) error
+
+func NewWorker(q *Queue, handler Handler, concurrency int) *Worker {
+	w := &Worker{
+		Queue:       q,
+		Handler:     handler,
+		Concurrency: concurrency,
+	}
+	w.SetDefaults()
+	return w
+}
+
+// Worker runs Handler for the jobs of Queue, a job is acked when Handler returns nil and nacked otherwise.
+// The visibility timeout of a job is extended every HeartbeatInterval while Handler runs, so long jobs aren't handed to other workers.
+type Worker struct {
+	Queue   *Queue
+	Handler Handler
+	// Concurrency is the number of jobs handled at the same time
+	Concurrency int
+	// PollTimeout bounds how long a dequeue blocks, and so how long shutdown waits for idle goroutines
+	PollTimeout time.Duration
+	// RequeueInterval is how often jobs past their visibility timeout are requeued, half of it by default
+	RequeueInterval time.Duration
+	// HeartbeatInterval is how often the visibility timeout of a running job is extended, a third of it by default.
+	// HeartbeatInterval < 0 never extends it, the jobs running longer than the visibility timeout then run again on other workers.
+	HeartbeatInterval time.Duration
+	// ShutdownTimeout is how long running jobs may finish after the context of Run is done,
+	// the context of the jobs is canceled after it. ShutdownTimeout < 0 waits for them without a limit.
+	ShutdownTimeout time.Duration
+	// OnError is called with the errors of the queue and of Handler, by default they are logged
+	OnError func(j *Job, err error)
+}
+
+func (w *Worker) SetDefaults() {
+	// the queue may not come from NewQueue, the intervals below derive from its visibility timeout
+	w.Queue.SetDefaults()
+
+	if w.Concurrency <= 0 {
+		w.Concurrency = 1
+	}
+	if w.PollTimeout == 0 {
+		w.PollTimeout = time.Second
+	}
+	if w.RequeueInterval <= 0 {
+		w.RequeueInterval = w.Queue.VisibilityTimeout / 2
+	}
+	// tickers need a positive interval
+	if w.RequeueInterval < time.Millisecond {
+		w.RequeueInterval = time.Millisecond
+	}
+	if w.HeartbeatInterval == 0 {
+		w.HeartbeatInterval = w.Queue.VisibilityTimeout / 3
+	}
+	if w.ShutdownTimeout == 0 {
+		w.ShutdownTimeout = 30 * time.Second
+	}
+	if w.OnError == nil {
+		w.OnError = func(j *Job, err error) {
+			if j != nil {
+				logrus.Errorf("queue %s: job %s failed: %s", w.Queue.Name, j.ID, err)
+				return
+			}
+			logrus.Errorf("queue %s: %s", w.Queue.Name, err)
+		}
+	}
+}
+
+// Run handles jobs until ctx is done, then stops dequeuing and waits for the running jobs before it returns.
+func (w *Worker) Run(ctx context.Context) error {
+	// jobs keep running after ctx is done, until the shutdown timeout passes
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx, jobCtx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.requeue(ctx)
+	}()
+
+	<-ctx.Done()
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	if w.ShutdownTimeout < 0 {
+		<-stopped
+		return nil
+	}
+
+	select {
+	case <-stopped:
+		return nil
+	case <-time.After(w.ShutdownTimeout):
+		cancelJobs()
+		<-stopped
+		return fmt.Errorf("queue %s: jobs canceled after shutdown timeout %s", w.Queue.Name, w.ShutdownTimeout)
+	}
+}
+
+func (w *Worker) loop(ctx context.Context, jobCtx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		j, err := w.Queue.Dequeue(ctx, w.PollTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			w.OnError(nil, err)
+			// avoid spinning while Redis is down
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.PollTimeout):
+			}
+			continue
+		}
+		if j == nil {
+			continue
+		}
+
+		w.handle(jobCtx, j)
+	}
+}
+
+func (w *Worker) handle(ctx context.Context, j *Job) {
+	stop := w.heartbeat(j)
+	err := w.call(ctx, j)
+	stop()
+
+	// the result is recorded even when the job was canceled
+	if err != nil {
+		w.OnError(j, err)
+		err = w.Queue.Nack(context.Background(), j, err)
+	} else {
+		err = w.Queue.Ack(context.Background(), j)
+	}
+	if err != nil {
+		w.OnError(j, err)
+	}
+}
+
+// heartbeat extends the visibility timeout of j until the returned func is called, which waits for the last extension
+func (w *Worker) heartbeat(j *Job) (stop func()) {
+	if w.HeartbeatInterval <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(w.HeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := w.Queue.Extend(ctx, j, w.Queue.VisibilityTimeout)
+				if err == nil || ctx.Err() != nil {
+					continue
+				}
+				w.OnError(j, err)
+				// the job was requeued, extending it again would fail the same way
+				if err == ErrJobLost {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
+
+func (w *Worker) call(ctx context.Context, j *Job) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("panic: %v", e)
+		}
+	}()
+	return w.Handler(ctx, j)
+}
+
+func (w *Worker) requeue(ctx context.Context) {
+	ticker := time.NewTicker(w.RequeueInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Queue.Requeue(ctx); err != nil && ctx.Err() == nil {
+				w.OnError(nil, err)
+			}
+		}
+	}
+}
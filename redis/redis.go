@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"time"
 
@@ -10,9 +11,12 @@ import (
 )
 
 type Redis struct {
-	Protocol       string
-	Host           string `env:",upstream"`
-	Port           int
+	// Protocol is tcp, or unix to dial the socket at the path of Host
+	Protocol string
+	Host     string `env:",upstream"`
+	Port     int
+	// Username authenticates as a Redis 6 ACL user along with Password, the default user when empty
+	Username       string
 	Password       envconf.Password `env:""`
 	ConnectTimeout envconf.Duration
 	ReadTimeout    envconf.Duration
@@ -22,11 +26,38 @@ type Redis struct {
 	MaxIdle        int
 	Wait           bool
 	DB             int
-	pool           *redis.Pool
+	// PipelineChunkSize is the number of commands Pipeline sends before reading their replies
+	PipelineChunkSize int
+	// TxMaxAttempts is the number of times Tx runs a transaction whose watched keys changed
+	TxMaxAttempts int
+	// Retry runs again the commands of Exec failing with transient errors
+	Retry RetryPolicy
+	// Hooks are called around each Exec, Pipeline and Tx
+	Hooks []Hook
+	// KeyPrefix is the namespace of the keys of Prefix, set to DefaultKeyPrefix by Init when empty
+	KeyPrefix string
+	// DisablePrefix leaves the keys of Prefix as they are
+	DisablePrefix bool
+	// TLS dials the server with TLS
+	TLS bool
+	// TLSCAFile is the PEM bundle of the CAs verifying the server certificate, the system ones when empty
+	TLSCAFile string
+	// TLSCertFile and TLSKeyFile are the PEM client certificate and its key, for servers requiring one
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSServerName is the name verified in the server certificate, Host when empty
+	TLSServerName string
+	// TLSSkipVerify skips the verification of the server certificate, for development only
+	TLSSkipVerify bool
+	pool          *redis.Pool
 }
 
+// Get returns a connection of the pool, whose commands fail with ErrNotInitialized when Init wasn't called
 func (r *Redis) Get() Conn {
-	c, _ := r.GetContext(context.Background())
+	c, err := r.GetContext(context.Background())
+	if err != nil {
+		return errorConn{err: err}
+	}
 	return c
 }
 
@@ -34,50 +65,24 @@ func (r *Redis) GetContext(ctx context.Context) (Conn, error) {
 	if r.pool != nil {
 		return r.pool.GetContext(ctx)
 	}
-	return nil, nil
+	return nil, ErrNotInitialized
 }
 
 func (r *Redis) Exec(cmd *CMD, others ...*CMD) (interface{}, error) {
 	return r.ExecContext(context.Background(), cmd, others...)
 }
 
+// ExecContext runs cmd, in a transaction with others when there are any, retried by Retry on transient errors, between Hooks
 func (r *Redis) ExecContext(ctx context.Context, cmd *CMD, others ...*CMD) (interface{}, error) {
-	c, err := r.GetContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer c.Close()
-
-	if (len(others)) == 0 {
-		return c.Do(cmd.name, cmd.args...)
-	}
-
-	err = c.Send("MULTI")
-	if err != nil {
-		return nil, err
-	}
-
-	err = c.Send(cmd.name, cmd.args...)
-	if err != nil {
-		return nil, err
-	}
-
-	for i := range others {
-		o := others[i]
-		if o == nil {
-			continue
-		}
-		err := c.Send(o.name, o.args...)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return c.Do("EXEC")
+	return execWithHooks(ctx, r.Hooks, cmd, others, func(ctx context.Context) (interface{}, error) {
+		return r.Retry.do(ctx, cmd, others, func() (interface{}, error) {
+			return exec(ctx, r.GetContext, cmd, others...)
+		})
+	})
 }
 
 func (r *Redis) Prefix(key string) string {
-	return fmt.Sprintf("%s:%s", prefix, key)
+	return prefixKey(r.KeyPrefix, r.DisablePrefix, key)
 }
 
 func (r *Redis) LivenessCheck() map[string]string {
@@ -127,6 +132,13 @@ func (r *Redis) SetDefaults() {
 	if r.DB == 0 {
 		r.DB = 10
 	}
+	if r.PipelineChunkSize == 0 {
+		r.PipelineChunkSize = defaultPipelineChunkSize
+	}
+	if r.TxMaxAttempts == 0 {
+		r.TxMaxAttempts = defaultTxMaxAttempts
+	}
+	r.Retry.SetDefaults()
 }
 
 func (r *Redis) Init() {
@@ -136,18 +148,41 @@ func (r *Redis) Init() {
 }
 
 func (r *Redis) initial() {
-	dialFunc := func() (c redis.Conn, err error) {
-		c, err = redis.Dial(
-			r.Protocol,
-			fmt.Sprintf("%s:%d", r.Host, r.Port),
+	if r.KeyPrefix == "" {
+		r.KeyPrefix = DefaultKeyPrefix()
+	}
+
+	address := fmt.Sprintf("%s:%d", r.Host, r.Port)
+	if r.Protocol == "unix" {
+		address = r.Host
+	}
 
-			redis.DialWriteTimeout(time.Duration(r.WriteTimeout)),
-			redis.DialConnectTimeout(time.Duration(r.ConnectTimeout)),
-			redis.DialReadTimeout(time.Duration(r.ReadTimeout)),
-			redis.DialPassword(r.Password.String()),
-			redis.DialDatabase(r.DB),
+	options := []redis.DialOption{
+		redis.DialWriteTimeout(time.Duration(r.WriteTimeout)),
+		redis.DialConnectTimeout(time.Duration(r.ConnectTimeout)),
+		redis.DialReadTimeout(time.Duration(r.ReadTimeout)),
+		redis.DialUsername(r.Username),
+		redis.DialPassword(r.Password.String()),
+		redis.DialDatabase(r.DB),
+	}
+
+	var err error
+	if r.TLS {
+		var config *tls.Config
+		config, err = tlsConfig(r.TLSCAFile, r.TLSCertFile, r.TLSKeyFile, r.TLSServerName, r.TLSSkipVerify)
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(config))
+	}
+
+	dialFunc := func() (redis.Conn, error) {
+		// the TLS files are reported by every dial, as Init can't fail
+		if err != nil {
+			return nil, err
+		}
+		return redis.Dial(
+			r.Protocol,
+			address,
+			options...,
 		)
-		return
 	}
 
 	r.pool = &redis.Pool{
@@ -0,0 +1,251 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("redis: circuit breaker is open")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type CircuitBreakerOptions struct {
+	// Window is the period over which the error rate is computed
+	Window time.Duration
+	// MinRequests is the number of requests in a window before the breaker may open
+	MinRequests int
+	// ErrorRate in (0, 1] opens the breaker when reached
+	ErrorRate float64
+	// OpenTimeout is how long the breaker stays open before letting trial requests through
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of concurrent trial requests while half-open
+	HalfOpenRequests int
+	// IsFailure reports whether err counts as a failure of Redis,
+	// by default replies of Redis like WRONGTYPE and canceled contexts do not count
+	IsFailure func(err error) bool
+	// OnStateChange is called after every state change
+	OnStateChange func(from CircuitState, to CircuitState)
+}
+
+func (o *CircuitBreakerOptions) SetDefaults() {
+	if o.Window == 0 {
+		o.Window = 10 * time.Second
+	}
+	if o.MinRequests == 0 {
+		o.MinRequests = 20
+	}
+	if o.ErrorRate == 0 {
+		o.ErrorRate = 0.5
+	}
+	if o.OpenTimeout == 0 {
+		o.OpenTimeout = 5 * time.Second
+	}
+	if o.HalfOpenRequests == 0 {
+		o.HalfOpenRequests = 1
+	}
+	if o.IsFailure == nil {
+		o.IsFailure = isFailure
+	}
+}
+
+func isFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var e Error
+	return !errors.As(err, &e)
+}
+
+var (
+	_ RedisOperator = (*CircuitBreaker)(nil)
+	_ KeyRouter     = (*CircuitBreaker)(nil)
+)
+
+// NewCircuitBreaker wraps op, failing fast with ErrCircuitOpen while Redis keeps failing.
+// Only Exec and ExecContext are tracked, connections from Get are not.
+func NewCircuitBreaker(op RedisOperator, opt CircuitBreakerOptions) *CircuitBreaker {
+	opt.SetDefaults()
+	return &CircuitBreaker{
+		op:  op,
+		opt: opt,
+	}
+}
+
+type CircuitBreaker struct {
+	op  RedisOperator
+	opt CircuitBreakerOptions
+
+	mu          sync.Mutex
+	state       CircuitState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	trials      int
+	// changes are reported to OnStateChange once the lock is released
+	changes [][2]CircuitState
+}
+
+func (b *CircuitBreaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+
+	if b.opt.OnStateChange != nil {
+		for _, c := range changes {
+			b.opt.OnStateChange(c[0], c[1])
+		}
+	}
+}
+
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.unlock()
+	b.refresh(time.Now())
+	return b.state
+}
+
+func (b *CircuitBreaker) Prefix(key string) string {
+	return b.op.Prefix(key)
+}
+
+func (b *CircuitBreaker) Get() Conn {
+	c, err := b.GetContext(context.Background())
+	if err != nil {
+		return errorConn{err: err}
+	}
+	return c
+}
+
+func (b *CircuitBreaker) GetContext(ctx context.Context) (Conn, error) {
+	if b.State() == CircuitOpen {
+		return nil, ErrCircuitOpen
+	}
+	return b.op.GetContext(ctx)
+}
+
+func (b *CircuitBreaker) GetContextForKey(ctx context.Context, key string) (Conn, error) {
+	if b.State() == CircuitOpen {
+		return nil, ErrCircuitOpen
+	}
+	return GetContextForKey(ctx, b.op, key)
+}
+
+func (b *CircuitBreaker) Exec(cmd *CMD, others ...*CMD) (interface{}, error) {
+	return b.ExecContext(context.Background(), cmd, others...)
+}
+
+func (b *CircuitBreaker) ExecContext(ctx context.Context, cmd *CMD, others ...*CMD) (interface{}, error) {
+	trial, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := b.op.ExecContext(ctx, cmd, others...)
+	b.done(trial, b.opt.IsFailure(err))
+	return reply, err
+}
+
+// allow reports whether a request may run and whether it is a trial of the half-open state
+func (b *CircuitBreaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.unlock()
+
+	b.refresh(time.Now())
+
+	switch b.state {
+	case CircuitOpen:
+		return false, ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.trials >= b.opt.HalfOpenRequests {
+			return false, ErrCircuitOpen
+		}
+		b.trials++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *CircuitBreaker) done(trial bool, failed bool) {
+	b.mu.Lock()
+	defer b.unlock()
+
+	now := time.Now()
+
+	if trial {
+		b.trials--
+		if b.state != CircuitHalfOpen {
+			return
+		}
+		if failed {
+			b.setState(CircuitOpen, now)
+		} else {
+			b.setState(CircuitClosed, now)
+		}
+		return
+	}
+
+	if b.state != CircuitClosed {
+		return
+	}
+
+	b.refresh(now)
+	b.requests++
+	if failed {
+		b.failures++
+	}
+
+	if b.requests >= b.opt.MinRequests && float64(b.failures)/float64(b.requests) >= b.opt.ErrorRate {
+		b.setState(CircuitOpen, now)
+	}
+}
+
+// refresh moves to half-open once the open timeout passed and starts a new window once the current one ends
+func (b *CircuitBreaker) refresh(now time.Time) {
+	if b.state == CircuitOpen && now.Sub(b.openedAt) >= b.opt.OpenTimeout {
+		b.setState(CircuitHalfOpen, now)
+	}
+	if now.Sub(b.windowStart) >= b.opt.Window {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	}
+}
+
+func (b *CircuitBreaker) setState(state CircuitState, now time.Time) {
+	from := b.state
+	b.state = state
+
+	switch state {
+	case CircuitOpen:
+		b.openedAt = now
+	case CircuitClosed:
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	}
+
+	if from != state {
+		b.changes = append(b.changes, [2]CircuitState{from, state})
+	}
+}
@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+type failingOperator struct {
+	recordOperator
+	err error
+}
+
+func (o *failingOperator) Exec(cmd *CMD, others ...*CMD) (interface{}, error) {
+	return o.ExecContext(context.Background(), cmd, others...)
+}
+
+func (o *failingOperator) ExecContext(ctx context.Context, cmd *CMD, others ...*CMD) (interface{}, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	return "OK", nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	op := &failingOperator{}
+	changes := make([]CircuitState, 0)
+
+	b := NewCircuitBreaker(op, CircuitBreakerOptions{
+		MinRequests: 4,
+		ErrorRate:   0.5,
+		OpenTimeout: 50 * time.Millisecond,
+		OnStateChange: func(from CircuitState, to CircuitState) {
+			changes = append(changes, to)
+		},
+	})
+
+	t.Run("stays closed below the error rate", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			_, err := b.Exec(Command("GET", "key"))
+			NewWithT(t).Expect(err).To(BeNil())
+		}
+		NewWithT(t).Expect(b.State()).To(Equal(CircuitClosed))
+	})
+
+	t.Run("replies of redis are not failures", func(t *testing.T) {
+		op.err = Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+		for i := 0; i < 10; i++ {
+			_, err := b.Exec(Command("GET", "key"))
+			NewWithT(t).Expect(err).To(Equal(op.err))
+		}
+		NewWithT(t).Expect(b.State()).To(Equal(CircuitClosed))
+	})
+
+	t.Run("opens once the error rate is reached", func(t *testing.T) {
+		op.err = io.EOF
+		for i := 0; i < 20; i++ {
+			_, _ = b.Exec(Command("GET", "key"))
+		}
+		NewWithT(t).Expect(b.State()).To(Equal(CircuitOpen))
+
+		_, err := b.Exec(Command("GET", "key"))
+		NewWithT(t).Expect(errors.Is(err, ErrCircuitOpen)).To(BeTrue())
+
+		_, err = b.Get().Do("GET", "key")
+		NewWithT(t).Expect(errors.Is(err, ErrCircuitOpen)).To(BeTrue())
+	})
+
+	t.Run("failed trial opens again", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+		NewWithT(t).Expect(b.State()).To(Equal(CircuitHalfOpen))
+
+		_, err := b.Exec(Command("GET", "key"))
+		NewWithT(t).Expect(err).To(Equal(io.EOF))
+		NewWithT(t).Expect(b.State()).To(Equal(CircuitOpen))
+	})
+
+	t.Run("successful trial closes", func(t *testing.T) {
+		op.err = nil
+		time.Sleep(60 * time.Millisecond)
+
+		_, err := b.Exec(Command("GET", "key"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(b.State()).To(Equal(CircuitClosed))
+	})
+
+	NewWithT(t).Expect(changes).To(Equal([]CircuitState{
+		CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed,
+	}))
+}
@@ -0,0 +1,612 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-courier/envconf"
+	"github.com/gomodule/redigo/redis"
+)
+
+var ErrCrossSlot = errors.New("redis: CROSSSLOT keys of a command, or of the commands in one Exec, map to different cluster slots, use {hashtag} to co-locate them")
+
+const clusterSlots = 16384
+
+var (
+	_ RedisOperator = (*ClusterRedis)(nil)
+	_ Pipeliner     = (*ClusterRedis)(nil)
+	_ Transactor    = (*ClusterRedis)(nil)
+	_ KeyRouter     = (*ClusterRedis)(nil)
+)
+
+// ClusterRedis routes each command to the node of a Redis Cluster serving the slot of its key.
+// The slot map is fetched from the nodes on first use and again when a node answers MOVED or fails,
+// MOVED and ASK redirects are followed.
+type ClusterRedis struct {
+	// Nodes are the addresses, as host:port, of some nodes of the cluster, the others are discovered
+	Nodes []string
+	// Username authenticates as a Redis 6 ACL user along with Password, the default user when empty
+	Username       string
+	Password       envconf.Password `env:""`
+	ConnectTimeout envconf.Duration
+	ReadTimeout    envconf.Duration
+	WriteTimeout   envconf.Duration
+	IdleTimeout    envconf.Duration
+	// MaxActive and MaxIdle limit the connections of each node
+	MaxActive int
+	MaxIdle   int
+	// MaxRedirects is the number of MOVED and ASK redirects followed by a command
+	MaxRedirects int
+	// PipelineChunkSize is the number of commands Pipeline sends before reading their replies
+	PipelineChunkSize int
+	// TxMaxAttempts is the number of times Tx runs a transaction whose watched keys changed
+	TxMaxAttempts int
+	// KeyPrefix is the namespace of the keys of Prefix, set to DefaultKeyPrefix by Init when empty
+	KeyPrefix string
+	// DisablePrefix leaves the keys of Prefix as they are
+	DisablePrefix bool
+	// TLS dials the nodes with TLS
+	TLS bool
+	// TLSCAFile is the PEM bundle of the CAs verifying the node certificates, the system ones when empty
+	TLSCAFile string
+	// TLSCertFile and TLSKeyFile are the PEM client certificate and its key, for nodes requiring one
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSServerName is the name verified in the node certificates, the host of each node when empty
+	TLSServerName string
+	// TLSSkipVerify skips the verification of the node certificates, for development only
+	TLSSkipVerify bool
+
+	mu sync.RWMutex
+	// slots holds the address of the node serving each slot, nil until fetched
+	slots []string
+	pools map[string]*redis.Pool
+	// refreshing is 1 while the slot map is fetched in the background
+	refreshing int32
+}
+
+// KeySlot returns the cluster hash slot of key, only the {hashtag} of key is hashed when it has one
+func KeySlot(key string) int {
+	return int(crc16([]byte(HashTag(key)))) % clusterSlots
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) the cluster hashes keys with
+func crc16(b []byte) uint16 {
+	crc := uint16(0)
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func (c *ClusterRedis) SetDefaults() {
+	if c.ConnectTimeout == 0 {
+		c.ConnectTimeout = envconf.Duration(10 * time.Second)
+	}
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = envconf.Duration(10 * time.Second)
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = envconf.Duration(10 * time.Second)
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = envconf.Duration(240 * time.Second)
+	}
+	if c.MaxActive == 0 {
+		c.MaxActive = 5
+	}
+	if c.MaxIdle == 0 {
+		c.MaxIdle = 3
+	}
+	if c.MaxRedirects == 0 {
+		c.MaxRedirects = 3
+	}
+	if c.PipelineChunkSize == 0 {
+		c.PipelineChunkSize = defaultPipelineChunkSize
+	}
+	if c.TxMaxAttempts == 0 {
+		c.TxMaxAttempts = defaultTxMaxAttempts
+	}
+}
+
+// Init prepares the pools, the slot map is fetched by the first command
+func (c *ClusterRedis) Init() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pools == nil {
+		c.pools = map[string]*redis.Pool{}
+	}
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = DefaultKeyPrefix()
+	}
+}
+
+func (c *ClusterRedis) Prefix(key string) string {
+	return prefixKey(c.KeyPrefix, c.DisablePrefix, key)
+}
+
+// Get returns a connection of any node, use GetContextForKey for key bound connections
+func (c *ClusterRedis) Get() Conn {
+	conn, err := c.GetContext(context.Background())
+	if err != nil {
+		return errorConn{err: err}
+	}
+	return conn
+}
+
+// GetContext returns a connection of any node, use GetContextForKey for key bound connections
+func (c *ClusterRedis) GetContext(ctx context.Context) (Conn, error) {
+	addr, err := c.nodeAddr(-1)
+	if err != nil {
+		return nil, err
+	}
+	return c.pool(addr).GetContext(ctx)
+}
+
+// GetContextForKey returns a connection of the node serving the slot of key, key should be prefixed already
+func (c *ClusterRedis) GetContextForKey(ctx context.Context, key string) (Conn, error) {
+	addr, err := c.nodeAddr(KeySlot(key))
+	if err != nil {
+		return nil, err
+	}
+	return c.pool(addr).GetContext(ctx)
+}
+
+func (c *ClusterRedis) Exec(cmd *CMD, others ...*CMD) (interface{}, error) {
+	return c.ExecContext(context.Background(), cmd, others...)
+}
+
+// ExecContext runs the commands on the node of their slot, the keys of several commands must share a slot
+func (c *ClusterRedis) ExecContext(ctx context.Context, cmd *CMD, others ...*CMD) (interface{}, error) {
+	slot, err := commandsSlot(append([]*CMD{cmd}, others...))
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := c.nodeAddr(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	asking := false
+	for redirects := 0; ; redirects++ {
+		get := c.getter(addr)
+		if asking {
+			get = askingGetter(get)
+		}
+
+		reply, err := exec(ctx, get, cmd, others...)
+
+		kind, movedSlot, target, ok := parseRedirect(err)
+		if !ok || redirects >= c.MaxRedirects {
+			if isFailover(err) {
+				c.refreshLater()
+			}
+			return reply, err
+		}
+
+		asking = kind == "ASK"
+		if !asking {
+			c.moved(movedSlot, target)
+		}
+		addr = target
+	}
+}
+
+// Pipeline pipelines the commands of each node concurrently, then runs again one by one the commands redirected by MOVED or ASK
+func (c *ClusterRedis) Pipeline(ctx context.Context, cmds ...*CMD) ([]Reply, error) {
+	replies := make([]Reply, len(cmds))
+
+	indexes := map[string][]int{}
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		slot, err := commandsSlot([]*CMD{cmd})
+		if err != nil {
+			replies[i].Err = err
+			continue
+		}
+		addr, err := c.nodeAddr(slot)
+		if err != nil {
+			replies[i].Err = err
+			continue
+		}
+		indexes[addr] = append(indexes[addr], i)
+	}
+
+	mu := sync.Mutex{}
+	var firstErr error
+	wg := sync.WaitGroup{}
+
+	for addr := range indexes {
+		wg.Add(1)
+		go func(addr string, indexes []int) {
+			defer wg.Done()
+
+			nodeCmds := make([]*CMD, len(indexes))
+			for i, j := range indexes {
+				nodeCmds[i] = cmds[j]
+			}
+
+			nodeReplies, err := pipeline(ctx, c.getter(addr), nodeCmds, c.PipelineChunkSize)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for i, j := range indexes {
+				replies[j] = nodeReplies[i]
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(addr, indexes[addr])
+	}
+
+	wg.Wait()
+
+	if isFailover(firstErr) {
+		c.refreshLater()
+	}
+
+	for i := range replies {
+		if _, _, _, ok := parseRedirect(replies[i].Err); ok {
+			replies[i].Value, replies[i].Err = c.ExecContext(ctx, cmds[i])
+		}
+	}
+
+	return replies, firstErr
+}
+
+// Tx runs the transaction on the node of watchKeys, which must share a slot, on any node without watchKeys.
+// The queued commands must belong to the same slot. The transaction runs again on the new node when the slot moved.
+func (c *ClusterRedis) Tx(ctx context.Context, watchKeys []string, fn func(tx *Transaction) error) ([]interface{}, error) {
+	cmds := make([]*CMD, len(watchKeys))
+	for i := range watchKeys {
+		cmds[i] = Command("WATCH", watchKeys[i])
+	}
+	slot, err := commandsSlot(cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := c.nodeAddr(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	queuedFn := func(tx *Transaction) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		// the queued commands share the slot of the watched keys
+		_, err := commandsSlot(append(cmds[:len(cmds):len(cmds)], tx.queued...))
+		return err
+	}
+
+	for redirects := 0; ; redirects++ {
+		replies, err := tx(ctx, c.getter(addr), nil, watchKeys, queuedFn, c.TxMaxAttempts)
+
+		kind, movedSlot, target, ok := parseRedirect(err)
+		if !ok || kind != "MOVED" || redirects >= c.MaxRedirects {
+			if isFailover(err) {
+				c.refreshLater()
+			}
+			return replies, err
+		}
+
+		c.moved(movedSlot, target)
+		addr = target
+	}
+}
+
+// LivenessCheck pings every node of the slot map, or the seed nodes when it can't be fetched
+func (c *ClusterRedis) LivenessCheck() map[string]string {
+	m := map[string]string{}
+
+	addrs, err := c.nodeAddrs()
+	if err != nil {
+		for _, addr := range c.Nodes {
+			m[addr] = err.Error()
+		}
+		return m
+	}
+
+	for _, addr := range addrs {
+		_, err := exec(context.Background(), c.getter(addr), Command("PING"))
+		if err != nil {
+			m[addr] = err.Error()
+		} else {
+			m[addr] = "ok"
+		}
+	}
+
+	return m
+}
+
+// commandsSlot returns the slot of all the keys of cmds, -1 when they have no key.
+// Keys in different slots fail with ErrCrossSlot before reaching the cluster, which would answer CROSSSLOT.
+func commandsSlot(cmds []*CMD) (int, error) {
+	slot, first := -1, ""
+
+	for _, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		for _, key := range cmd.keys() {
+			s := KeySlot(key)
+			if slot >= 0 && s != slot {
+				return 0, fmt.Errorf("%w: %s in slot %d, %s in slot %d", ErrCrossSlot, first, slot, key, s)
+			}
+			slot, first = s, key
+		}
+	}
+
+	return slot, nil
+}
+
+// parseRedirect parses the MOVED and ASK errors, as MOVED 3999 127.0.0.1:6381
+func parseRedirect(err error) (kind string, slot int, addr string, ok bool) {
+	e, isRedisErr := err.(redis.Error)
+	if !isRedisErr {
+		return "", 0, "", false
+	}
+	fields := strings.Fields(string(e))
+	if len(fields) != 3 || fields[0] != "MOVED" && fields[0] != "ASK" {
+		return "", 0, "", false
+	}
+	slot, convErr := strconv.Atoi(fields[1])
+	if convErr != nil {
+		return "", 0, "", false
+	}
+	return fields[0], slot, fields[2], true
+}
+
+// askingGetter sends ASKING on the connections of get, so the next command or transaction runs on a slot being imported
+func askingGetter(get func(ctx context.Context) (Conn, error)) func(ctx context.Context) (Conn, error) {
+	return func(ctx context.Context) (Conn, error) {
+		conn, err := get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := redis.DoContext(conn, ctx, "ASKING"); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+func (c *ClusterRedis) getter(addr string) func(ctx context.Context) (Conn, error) {
+	return func(ctx context.Context) (Conn, error) {
+		return c.pool(addr).GetContext(ctx)
+	}
+}
+
+// pool returns the pool of the node at addr, created on first use
+func (c *ClusterRedis) pool(addr string) *redis.Pool {
+	c.mu.RLock()
+	p, ok := c.pools[addr]
+	c.mu.RUnlock()
+	if ok {
+		return p
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pools == nil {
+		c.pools = map[string]*redis.Pool{}
+	}
+	if p, ok := c.pools[addr]; ok {
+		return p
+	}
+
+	options := []redis.DialOption{
+		redis.DialWriteTimeout(time.Duration(c.WriteTimeout)),
+		redis.DialConnectTimeout(time.Duration(c.ConnectTimeout)),
+		redis.DialReadTimeout(time.Duration(c.ReadTimeout)),
+		redis.DialUsername(c.Username),
+		redis.DialPassword(c.Password.String()),
+	}
+
+	var err error
+	if c.TLS {
+		var config *tls.Config
+		config, err = tlsConfig(c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile, c.TLSServerName, c.TLSSkipVerify)
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(config))
+	}
+
+	p = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			// the TLS files are reported by every dial, as Init can't fail
+			if err != nil {
+				return nil, err
+			}
+			return redis.Dial("tcp", addr, options...)
+		},
+		MaxIdle:     c.MaxIdle,
+		MaxActive:   c.MaxActive,
+		IdleTimeout: time.Duration(c.IdleTimeout),
+		Wait:        true,
+	}
+	c.pools[addr] = p
+	return p
+}
+
+// nodeAddr returns the address of the node serving slot, of any node when slot is -1, fetching the slot map when it isn't yet
+func (c *ClusterRedis) nodeAddr(slot int) (string, error) {
+	c.mu.RLock()
+	slots := c.slots
+	c.mu.RUnlock()
+
+	if slots == nil {
+		if err := c.refresh(); err != nil {
+			return "", err
+		}
+		c.mu.RLock()
+		slots = c.slots
+		c.mu.RUnlock()
+	}
+
+	if slot < 0 {
+		slot = rand.Intn(clusterSlots)
+		for i := 0; i < clusterSlots && slots[slot] == ""; i++ {
+			slot = (slot + 1) % clusterSlots
+		}
+	}
+
+	addr := slots[slot]
+	if addr == "" {
+		return "", fmt.Errorf("redis: no node of the cluster serves slot %d", slot)
+	}
+	return addr, nil
+}
+
+// nodeAddrs returns the addresses of the nodes of the slot map
+func (c *ClusterRedis) nodeAddrs() ([]string, error) {
+	if _, err := c.nodeAddr(-1); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	addrs := make([]string, 0)
+	seen := map[string]bool{}
+	for _, addr := range c.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// moved records that slot is served by the node at addr, then fetches the whole slot map in the background
+func (c *ClusterRedis) moved(slot int, addr string) {
+	c.mu.Lock()
+	if c.slots != nil && slot >= 0 && slot < clusterSlots {
+		slots := make([]string, clusterSlots)
+		copy(slots, c.slots)
+		slots[slot] = addr
+		c.slots = slots
+	}
+	c.mu.Unlock()
+
+	c.refreshLater()
+}
+
+// refreshLater fetches the slot map in the background, unless it is being fetched already
+func (c *ClusterRedis) refreshLater() {
+	if !atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		_ = c.refresh()
+	}()
+}
+
+// refresh fetches the slot map from the first node answering CLUSTER SLOTS, known nodes first then seed nodes,
+// and closes the pools of the nodes which left the cluster
+func (c *ClusterRedis) refresh() error {
+	c.mu.RLock()
+	addrs := make([]string, 0, len(c.pools)+len(c.Nodes))
+	for addr := range c.pools {
+		addrs = append(addrs, addr)
+	}
+	c.mu.RUnlock()
+	addrs = append(addrs, c.Nodes...)
+
+	err := errors.New("redis: no cluster node to fetch the slot map from")
+	for _, addr := range addrs {
+		var slots []string
+		slots, err = c.fetchSlots(addr)
+		if err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		c.slots = slots
+		used := map[string]bool{}
+		for _, a := range slots {
+			used[a] = true
+		}
+		for _, a := range c.Nodes {
+			used[a] = true
+		}
+		for a, p := range c.pools {
+			if !used[a] {
+				_ = p.Close()
+				delete(c.pools, a)
+			}
+		}
+		c.mu.Unlock()
+		return nil
+	}
+
+	return fmt.Errorf("redis: fetch cluster slots: %w", err)
+}
+
+// fetchSlots reads the slot map of CLUSTER SLOTS, whose entries are start, end, then the master as host, port and node id, then its replicas
+func (c *ClusterRedis) fetchSlots(addr string) ([]string, error) {
+	values, err := redis.Values(exec(context.Background(), c.getter(addr), Command("CLUSTER", "SLOTS")))
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]string, clusterSlots)
+	for _, v := range values {
+		entry, err := redis.Values(v, nil)
+		if err != nil || len(entry) < 3 {
+			return nil, fmt.Errorf("redis: unexpected CLUSTER SLOTS entry %v", v)
+		}
+		start, err := redis.Int(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		end, err := redis.Int(entry[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		master, err := redis.Values(entry[2], nil)
+		if err != nil || len(master) < 2 {
+			return nil, fmt.Errorf("redis: unexpected CLUSTER SLOTS node %v", entry[2])
+		}
+		host, err := redis.String(master[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		port, err := redis.Int(master[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" {
+			// the node doesn't know its own address, it is the one asked
+			host, _, _ = net.SplitHostPort(addr)
+		}
+		if start < 0 || end >= clusterSlots || start > end {
+			return nil, fmt.Errorf("redis: unexpected CLUSTER SLOTS range %d-%d", start, end)
+		}
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+	return slots, nil
+}
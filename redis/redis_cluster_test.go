@@ -0,0 +1,205 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+func TestKeySlot(t *testing.T) {
+	NewWithT(t).Expect(KeySlot("foo")).To(Equal(12182))
+	NewWithT(t).Expect(KeySlot("123456789")).To(Equal(12739))
+	NewWithT(t).Expect(KeySlot("env:project:{user1000}.following")).To(Equal(KeySlot("{user1000}.followers")))
+	NewWithT(t).Expect(KeySlot("foo{}{bar}")).To(Equal(int(crc16([]byte("foo{}{bar}"))) % clusterSlots))
+}
+
+func TestClusterRedis(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := make([]*redistest.Server, 3)
+	for i := range nodes {
+		nodes[i] = redistest.NewServer()
+		defer nodes[i].Close()
+	}
+
+	assign := func(ranges ...redistest.SlotRange) {
+		for _, n := range nodes {
+			n.SetClusterSlots(ranges...)
+		}
+	}
+	assign(
+		redistest.SlotRange{Start: 0, End: 5460, Addr: nodes[0].Addr()},
+		redistest.SlotRange{Start: 5461, End: 10922, Addr: nodes[1].Addr()},
+		redistest.SlotRange{Start: 10923, End: 16383, Addr: nodes[2].Addr()},
+	)
+
+	c := &ClusterRedis{Nodes: []string{nodes[1].Addr()}}
+	c.SetDefaults()
+	c.Init()
+
+	// foo is in slot 12182 and bar in slot 5061
+	foo, bar := c.Prefix("{foo}"), c.Prefix("{bar}")
+
+	t.Run("routes by slot", func(t *testing.T) {
+		_, err := c.Exec(Command("SET", foo, "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+		_, err = c.Exec(Command("SET", bar, "2"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		NewWithT(t).Expect(readNode(t, nodes[2], foo)).To(Equal("1"))
+		NewWithT(t).Expect(readNode(t, nodes[0], bar)).To(Equal("2"))
+
+		values, err := redis.Values(c.Exec(Command("INCR", foo), Command("GET", c.Prefix("{foo}:other"))))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(values).To(Equal([]interface{}{int64(2), nil}))
+
+		_, err = c.Exec(Command("GET", foo), Command("GET", bar))
+		NewWithT(t).Expect(errors.Is(err, ErrCrossSlot)).To(BeTrue())
+
+		_, err = c.Exec(Command("DEL", foo, bar))
+		NewWithT(t).Expect(errors.Is(err, ErrCrossSlot)).To(BeTrue())
+
+		NewWithT(t).Expect(c.LivenessCheck()).To(Equal(map[string]string{
+			nodes[0].Addr(): "ok",
+			nodes[1].Addr(): "ok",
+			nodes[2].Addr(): "ok",
+		}))
+	})
+
+	t.Run("follows MOVED", func(t *testing.T) {
+		// the slot of foo moves to the first node
+		assign(
+			redistest.SlotRange{Start: 0, End: 5460, Addr: nodes[0].Addr()},
+			redistest.SlotRange{Start: 5461, End: 10922, Addr: nodes[1].Addr()},
+			redistest.SlotRange{Start: 10923, End: 12181, Addr: nodes[2].Addr()},
+			redistest.SlotRange{Start: 12182, End: 12182, Addr: nodes[0].Addr()},
+			redistest.SlotRange{Start: 12183, End: 16383, Addr: nodes[2].Addr()},
+		)
+
+		values, err := redis.Values(c.Exec(Command("SET", foo, "moved"), Command("GET", foo)))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(values[1]).To(Equal([]byte("moved")))
+		NewWithT(t).Expect(readNode(t, nodes[0], foo)).To(Equal("moved"))
+
+		addr, err := c.nodeAddr(KeySlot(foo))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(addr).To(Equal(nodes[0].Addr()))
+	})
+
+	t.Run("follows ASK while a slot migrates", func(t *testing.T) {
+		// bar is still on the first node, the missing keys of its slot are on the second one
+		nodes[0].SetMigrating(KeySlot(bar), nodes[1].Addr())
+		defer nodes[0].SetMigrating(KeySlot(bar), "")
+
+		v, err := redis.String(c.Exec(Command("GET", bar)))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal("2"))
+
+		_, err = c.Exec(Command("SET", c.Prefix("{bar}:new"), "1"), Command("INCR", c.Prefix("{bar}:new")))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(readNode(t, nodes[1], c.Prefix("{bar}:new"))).To(Equal("2"))
+
+		// the slot map isn't changed by ASK
+		addr, err := c.nodeAddr(KeySlot(bar))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(addr).To(Equal(nodes[0].Addr()))
+	})
+
+	t.Run("pipelines and transactions", func(t *testing.T) {
+		replies, err := c.Pipeline(ctx, Command("GET", foo), Command("GET", bar), nil, Command("GET", c.Prefix("{baz}")))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(replies[0].Value).To(Equal([]byte("moved")))
+		NewWithT(t).Expect(replies[1].Value).To(Equal([]byte("2")))
+		NewWithT(t).Expect(replies[3].Value).To(BeNil())
+
+		replies, err = c.Pipeline(ctx, Command("MGET", foo, bar), Command("GET", foo))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(errors.Is(replies[0].Err, ErrCrossSlot)).To(BeTrue())
+		NewWithT(t).Expect(replies[1].Value).To(Equal([]byte("moved")))
+
+		values, err := c.Tx(ctx, []string{foo}, func(tx *Transaction) error {
+			v, err := redis.String(tx.Do(Command("GET", foo)))
+			if err != nil {
+				return err
+			}
+			tx.Queue(Command("SET", foo, v+"!"))
+			return nil
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(values).To(Equal([]interface{}{"OK"}))
+		NewWithT(t).Expect(readNode(t, nodes[0], foo)).To(Equal("moved!"))
+
+		_, err = c.Tx(ctx, []string{foo, bar}, func(tx *Transaction) error { return nil })
+		NewWithT(t).Expect(errors.Is(err, ErrCrossSlot)).To(BeTrue())
+
+		_, err = c.Tx(ctx, []string{foo}, func(tx *Transaction) error {
+			tx.Queue(Command("SET", bar, "1"))
+			return nil
+		})
+		NewWithT(t).Expect(errors.Is(err, ErrCrossSlot)).To(BeTrue())
+		NewWithT(t).Expect(readNode(t, nodes[0], bar)).To(Equal("2"))
+	})
+
+	t.Run("ranges the nodes", func(t *testing.T) {
+		_, err := c.Exec(Command("SET", c.Prefix("{baz}"), "3"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		ranged := 0
+		keys := make([]string, 0)
+		NewWithT(t).Expect(RangeNodes(ctx, c, func(conn Conn) error {
+			ranged++
+			nodeKeys, err := redis.Strings(conn.Do("KEYS", "*"))
+			keys = append(keys, nodeKeys...)
+			return err
+		})).To(BeNil())
+
+		addrs, err := c.nodeAddrs()
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(ranged).To(Equal(len(addrs)))
+		NewWithT(t).Expect(keys).To(ContainElements(foo, bar, c.Prefix("{baz}")))
+	})
+}
+
+func TestCommandsSlot(t *testing.T) {
+	for _, c := range []struct {
+		cmds []*CMD
+		slot int
+	}{
+		{[]*CMD{Command("PING")}, -1},
+		{[]*CMD{Command("GET", "{foo}")}, KeySlot("foo")},
+		{[]*CMD{Command("XGROUP", "CREATE", "{foo}", "group", "$")}, KeySlot("foo")},
+		{[]*CMD{Command("XREADGROUP", "GROUP", "group", "consumer", "STREAMS", "{foo}", ">")}, KeySlot("foo")},
+		{[]*CMD{Command("MSET", "{foo}:a", 1, "{foo}:b", 2), Command("DEL", "{foo}")}, KeySlot("foo")},
+	} {
+		slot, err := commandsSlot(c.cmds)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(slot).To(Equal(c.slot))
+	}
+
+	for _, cmds := range [][]*CMD{
+		{Command("DEL", "{foo}", "{bar}")},
+		{Command("MSET", "{foo}", 1, "{bar}", 2)},
+		{Command("XREAD", "STREAMS", "{foo}", "{bar}", "0", "0")},
+		{Command("EVAL", "return 1", 2, "{foo}", "{bar}")},
+		{Command("GET", "{foo}"), Command("GET", "{bar}")},
+	} {
+		_, err := commandsSlot(cmds)
+		NewWithT(t).Expect(errors.Is(err, ErrCrossSlot)).To(BeTrue())
+	}
+}
+
+func readNode(t *testing.T, s *redistest.Server, key string) string {
+	c, err := redis.Dial("tcp", s.Addr())
+	NewWithT(t).Expect(err).To(BeNil())
+	defer c.Close()
+	// reads the key even when the node is only importing its slot
+	_, err = c.Do("ASKING")
+	NewWithT(t).Expect(err).To(BeNil())
+	v, err := redis.String(c.Do("GET", key))
+	NewWithT(t).Expect(err).To(BeNil())
+	return v
+}
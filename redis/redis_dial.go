@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Dialer is implemented by the operators which open connections outside of their pools, see DialContext
+type Dialer interface {
+	DialContext(ctx context.Context) (Conn, error)
+}
+
+var (
+	_ Dialer = (*Redis)(nil)
+	_ Dialer = (*RedisEndpoint)(nil)
+	_ Dialer = (*ClusterRedis)(nil)
+	_ Dialer = (*ShardedRedis)(nil)
+	_ Dialer = (*CircuitBreaker)(nil)
+)
+
+// DialContext opens a connection of op outside of its pool, for the connections held for long, as subscriptions,
+// which would otherwise take the connections of Exec, MaxActive counting them. The caller closes it.
+// Operators which aren't Dialers return a connection of GetContext.
+func DialContext(ctx context.Context, op RedisOperator) (Conn, error) {
+	if d, ok := op.(Dialer); ok {
+		return d.DialContext(ctx)
+	}
+	return op.GetContext(ctx)
+}
+
+// dialPool dials a connection as the pool p does, without counting it in p
+func dialPool(ctx context.Context, p *redis.Pool) (Conn, error) {
+	if p.DialContext != nil {
+		return p.DialContext(ctx)
+	}
+	return p.Dial()
+}
+
+func (r *Redis) DialContext(ctx context.Context) (Conn, error) {
+	if r.pool == nil {
+		return nil, ErrNotInitialized
+	}
+	return dialPool(ctx, r.pool)
+}
+
+// DialContext connects to the master when the endpoint is a sentinel
+func (r *RedisEndpoint) DialContext(ctx context.Context) (Conn, error) {
+	if r.pool == nil {
+		return nil, ErrNotInitialized
+	}
+	return dialPool(ctx, r.pool)
+}
+
+// DialContext connects to any node
+func (c *ClusterRedis) DialContext(ctx context.Context) (Conn, error) {
+	addr, err := c.nodeAddr(-1)
+	if err != nil {
+		return nil, err
+	}
+	return dialPool(ctx, c.pool(addr))
+}
+
+// DialContext connects to the first shard, as GetContext does
+func (s *ShardedRedis) DialContext(ctx context.Context) (Conn, error) {
+	return DialContext(ctx, s.shards[s.names[0]])
+}
+
+func (b *CircuitBreaker) DialContext(ctx context.Context) (Conn, error) {
+	if b.State() == CircuitOpen {
+		return nil, ErrCircuitOpen
+	}
+	return DialContext(ctx, b.op)
+}
@@ -0,0 +1,38 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+func TestDialContext(t *testing.T) {
+	ctx := context.Background()
+
+	s := redistest.NewServer()
+	defer s.Close()
+
+	r := &Redis{Host: s.Host(), Port: s.Port(), MaxActive: 1}
+	r.SetDefaults()
+	r.Init()
+
+	c, err := DialContext(ctx, r)
+	NewWithT(t).Expect(err).To(BeNil())
+	defer c.Close()
+
+	// the dialed connection isn't one of the pool
+	NewWithT(t).Expect(r.pool.ActiveCount()).To(Equal(0))
+	NewWithT(t).Expect(r.Exec(Command("SET", r.Prefix("dial"), "1"))).To(Equal("OK"))
+	NewWithT(t).Expect(c.Do("GET", r.Prefix("dial"))).To(Equal([]byte("1")))
+
+	_, err = DialContext(ctx, &Redis{})
+	NewWithT(t).Expect(err).To(Equal(ErrNotInitialized))
+
+	sharded := NewShardedRedis(map[string]RedisOperator{"a": r}, 0)
+	c, err = DialContext(ctx, NewCircuitBreaker(sharded, CircuitBreakerOptions{}))
+	NewWithT(t).Expect(err).To(BeNil())
+	defer c.Close()
+	NewWithT(t).Expect(c.Do("PING")).To(Equal("PONG"))
+}
@@ -0,0 +1,35 @@
+package redis
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-courier/envconf"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+)
+
+func TestRedisEndpoint(t *testing.T) {
+	endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("redis://%s?db=2", server.Addr()))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	r := &RedisEndpoint{Endpoint: *endpoint}
+	r.Init()
+
+	t.Run("Multi", func(t *testing.T) {
+		values, err := redis.Values(
+			r.Exec(
+				Command("SET", r.Prefix("KEY"), "1"),
+				Command("GET", r.Prefix("KEY")),
+				Command("DEL", r.Prefix("KEY")),
+			),
+		)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(values[0]).To(Equal("OK"))
+		NewWithT(t).Expect(values[1]).To(Equal([]byte("1")))
+	})
+
+	t.Run("LivenessCheck", func(t *testing.T) {
+		NewWithT(t).Expect(r.LivenessCheck()).To(Equal(map[string]string{server.Addr(): "ok"}))
+	})
+}
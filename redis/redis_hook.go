@@ -0,0 +1,200 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Hook is called around each Exec and Pipeline of an operator, to trace, log or audit the commands.
+// Hooks run in order for Before and in reverse order for After, as nested middlewares.
+// Within Tx, each Do is an event, as is the EXEC of the queued commands of each attempt.
+type Hook interface {
+	// Before is called before the commands run, the context it returns goes to the next hooks, to the commands and to its After
+	Before(ctx context.Context, e *HookEvent) context.Context
+	// After is called once the commands ran, retries included, with the Duration and the Err of the event set
+	After(ctx context.Context, e *HookEvent)
+}
+
+// HookEvent describes the commands of one Exec or Pipeline to hooks
+type HookEvent struct {
+	// Names and Args are the names and the args of the commands, several for a transaction or a pipeline.
+	// Args is a copy, hooks may change it to hide values from the next hooks, the commands sent don't change.
+	Names []string
+	Args  [][]interface{}
+	// Pipeline is set for the commands of Pipeline, which aren't a transaction
+	Pipeline bool
+	// Duration is the time the commands took
+	Duration time.Duration
+	// Err is the error returned by Exec, or the error of the connection of Pipeline
+	Err error
+}
+
+// String formats the commands as SET key value; GET key, each arg is cut to 64 characters
+func (e *HookEvent) String() string {
+	b := strings.Builder{}
+	for i := range e.Names {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(strings.ToUpper(e.Names[i]))
+		for _, arg := range e.Args[i] {
+			s := toKey(arg)
+			if len(s) > 64 {
+				s = s[:64] + "..."
+			}
+			b.WriteByte(' ')
+			b.WriteString(strconv.Quote(s))
+		}
+	}
+	return b.String()
+}
+
+func newHookEvent(cmds []*CMD) *HookEvent {
+	e := &HookEvent{}
+	for _, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		e.Names = append(e.Names, cmd.name)
+		e.Args = append(e.Args, append([]interface{}{}, cmd.args...))
+	}
+	return e
+}
+
+// withHooks runs run between the Before and After of hooks
+func withHooks(ctx context.Context, hooks []Hook, e func() *HookEvent, run func(ctx context.Context) error) {
+	if len(hooks) == 0 {
+		_ = run(ctx)
+		return
+	}
+
+	event := e()
+	ctxs := make([]context.Context, len(hooks))
+	for i, h := range hooks {
+		ctx = h.Before(ctx, event)
+		ctxs[i] = ctx
+	}
+
+	start := time.Now()
+	event.Err = run(ctx)
+	event.Duration = time.Since(start)
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i].After(ctxs[i], event)
+	}
+}
+
+// execWithHooks runs exec between the hooks, as Exec of cmd and others
+func execWithHooks(ctx context.Context, hooks []Hook, cmd *CMD, others []*CMD, exec func(ctx context.Context) (interface{}, error)) (reply interface{}, err error) {
+	withHooks(ctx, hooks, func() *HookEvent {
+		return newHookEvent(append([]*CMD{cmd}, others...))
+	}, func(ctx context.Context) error {
+		reply, err = exec(ctx)
+		return err
+	})
+	return reply, err
+}
+
+// pipelineWithHooks runs pipeline between the hooks, as Pipeline of cmds
+func pipelineWithHooks(ctx context.Context, hooks []Hook, cmds []*CMD, pipeline func(ctx context.Context) ([]Reply, error)) (replies []Reply, err error) {
+	withHooks(ctx, hooks, func() *HookEvent {
+		e := newHookEvent(cmds)
+		e.Pipeline = true
+		return e
+	}, func(ctx context.Context) error {
+		replies, err = pipeline(ctx)
+		return err
+	})
+	return replies, err
+}
+
+var _ Hook = (*SlowLogHook)(nil)
+
+// SlowLogHook logs the commands which took at least Threshold as warnings with logrus.
+// Put a RedactHook before it to keep values out of the logs.
+type SlowLogHook struct {
+	Threshold time.Duration
+	// Logger is the standard logger of logrus by default
+	Logger logrus.FieldLogger
+}
+
+func (h *SlowLogHook) Before(ctx context.Context, e *HookEvent) context.Context {
+	return ctx
+}
+
+func (h *SlowLogHook) After(ctx context.Context, e *HookEvent) {
+	if e.Duration < h.Threshold {
+		return
+	}
+
+	logger := h.Logger
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	if e.Err != nil {
+		logger = logger.WithError(e.Err)
+	}
+	logger.Warnf("redis: slow command %s took %s", e, e.Duration)
+}
+
+var _ Hook = (*RedactHook)(nil)
+
+// RedactHook hides the values of the args from the next hooks, only the keys and the names of the commands are left.
+// It must come first, before the hooks logging or tracing the args.
+type RedactHook struct {
+	// Redact returns the args of a command to show, by default the keys are kept and the other args are replaced by Placeholder
+	Redact func(name string, args []interface{}) []interface{}
+	// Placeholder replaces the values, *** by default
+	Placeholder string
+}
+
+func (h *RedactHook) Before(ctx context.Context, e *HookEvent) context.Context {
+	redact := h.Redact
+	if redact == nil {
+		redact = h.redactValues
+	}
+	for i := range e.Args {
+		e.Args[i] = redact(e.Names[i], e.Args[i])
+	}
+	return ctx
+}
+
+func (h *RedactHook) After(ctx context.Context, e *HookEvent) {
+}
+
+// redactValues keeps the keys of the command, at their positions in its args, and the number of keys of EVAL and the like
+func (h *RedactHook) redactValues(name string, args []interface{}) []interface{} {
+	placeholder := h.Placeholder
+	if placeholder == "" {
+		placeholder = "***"
+	}
+
+	redacted := make([]interface{}, len(args))
+	for i := range args {
+		redacted[i] = placeholder
+	}
+
+	cmd := Command(name, args...)
+	for _, i := range cmd.keyIndexes() {
+		redacted[i] = args[i]
+	}
+	if nk, ok := numKeysCommands[strings.ToUpper(name)]; ok && nk.index < len(args) {
+		redacted[nk.index] = args[nk.index]
+	}
+	return redacted
+}
+
+// HookFunc adapts a function called after the commands into a Hook, for logging or metrics
+type HookFunc func(ctx context.Context, e *HookEvent)
+
+func (f HookFunc) Before(ctx context.Context, e *HookEvent) context.Context {
+	return ctx
+}
+
+func (f HookFunc) After(ctx context.Context, e *HookEvent) {
+	f(ctx, e)
+}
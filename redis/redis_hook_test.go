@@ -0,0 +1,170 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-courier/envconf"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+type hookKey struct{}
+
+// recordHook records the calls of its hooks, and passes its name in the context
+type recordHook struct {
+	name   string
+	calls  *[]string
+	events []HookEvent
+}
+
+func (h *recordHook) Before(ctx context.Context, e *HookEvent) context.Context {
+	*h.calls = append(*h.calls, "before "+h.name)
+	return context.WithValue(ctx, hookKey{}, h.name)
+}
+
+func (h *recordHook) After(ctx context.Context, e *HookEvent) {
+	*h.calls = append(*h.calls, fmt.Sprintf("after %s %v", h.name, ctx.Value(hookKey{})))
+	h.events = append(h.events, *e)
+}
+
+func TestHooks(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+
+	calls := []string{}
+	first, second := &recordHook{name: "first", calls: &calls}, &recordHook{name: "second", calls: &calls}
+
+	r := &Redis{Host: s.Host(), Port: s.Port(), Hooks: []Hook{first, second}}
+	r.SetDefaults()
+	r.Init()
+
+	key := r.Prefix("hook")
+
+	t.Run("commands", func(t *testing.T) {
+		_, err := r.Exec(Command("SET", key, "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		NewWithT(t).Expect(calls).To(Equal([]string{"before first", "before second", "after second second", "after first first"}))
+		NewWithT(t).Expect(second.events[0].Names).To(Equal([]string{"SET"}))
+		NewWithT(t).Expect(second.events[0].Args).To(Equal([][]interface{}{{key, "1"}}))
+		NewWithT(t).Expect(second.events[0].Err).To(BeNil())
+	})
+
+	t.Run("transactions and errors", func(t *testing.T) {
+		s.InjectError("EXEC", "ERR unexpected", 1)
+
+		_, err := r.Exec(Command("INCR", key), Command("GET", key))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		e := second.events[len(second.events)-1]
+		NewWithT(t).Expect(e.Names).To(Equal([]string{"INCR", "GET"}))
+		NewWithT(t).Expect(e.Err).To(Equal(err))
+		NewWithT(t).Expect(e.Pipeline).To(BeFalse())
+	})
+
+	t.Run("retries are a single event", func(t *testing.T) {
+		r.Retry.MinBackoff = envconf.Duration(time.Millisecond)
+		s.InjectError("GET", "LOADING Redis is loading the dataset in memory", 2)
+
+		n := len(second.events)
+		_, err := r.Exec(Command("GET", key))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(second.events).To(HaveLen(n + 1))
+	})
+
+	t.Run("transactions of Tx", func(t *testing.T) {
+		n := len(second.events)
+		_, err := r.Tx(context.Background(), []string{key}, func(tx *Transaction) error {
+			if _, err := tx.Do(Command("GET", key)); err != nil {
+				return err
+			}
+			tx.Queue(Command("SET", key, "2"), Command("GET", key))
+			return nil
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+
+		events := second.events[n:]
+		NewWithT(t).Expect(events).To(HaveLen(2))
+		NewWithT(t).Expect(events[0].Names).To(Equal([]string{"GET"}))
+		NewWithT(t).Expect(events[1].Names).To(Equal([]string{"SET", "GET"}))
+		NewWithT(t).Expect(events[1].Err).To(BeNil())
+	})
+
+	t.Run("pipelines", func(t *testing.T) {
+		_, err := r.Pipeline(context.Background(), Command("GET", key), Command("GET", key))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		e := second.events[len(second.events)-1]
+		NewWithT(t).Expect(e.Names).To(Equal([]string{"GET", "GET"}))
+		NewWithT(t).Expect(e.Pipeline).To(BeTrue())
+	})
+}
+
+func TestSlowLogHook(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+
+	buf := bytes.NewBuffer(nil)
+	logger := logrus.New()
+	logger.SetOutput(buf)
+
+	slowLog := &SlowLogHook{Threshold: time.Hour, Logger: logger}
+
+	endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("redis://%s", s.Addr()))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	r := &RedisEndpoint{Endpoint: *endpoint, Hooks: []Hook{&RedactHook{}, slowLog}}
+	r.Init()
+
+	key := r.Prefix("slow")
+
+	_, err = r.Exec(Command("SET", key, "secret"))
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(buf.String()).To(BeEmpty())
+
+	slowLog.Threshold = 0
+	_, err = r.Exec(Command("SET", key, "secret"))
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(buf.String()).To(ContainSubstring("level=warning"))
+	NewWithT(t).Expect(buf.String()).To(ContainSubstring(key))
+	NewWithT(t).Expect(buf.String()).To(ContainSubstring("***"))
+	NewWithT(t).Expect(buf.String()).NotTo(ContainSubstring("secret"))
+}
+
+func TestRedactHook(t *testing.T) {
+	h := &RedactHook{}
+
+	e := newHookEvent([]*CMD{
+		Command("SET", "k", "v", "EX", 10),
+		Command("PING", "hello"),
+		Command("EVAL", "return 1", 2, "k1", "k2", "v"),
+		Command("XREADGROUP", "GROUP", "g", "c", "STREAMS", "k", ">"),
+		Command("MSET", "k1", "v1", "k2", "v2"),
+		Command("AUTH", "user", "secret"),
+		Command("HELLO", 3, "AUTH", "user", "secret"),
+	})
+	h.Before(context.Background(), e)
+
+	NewWithT(t).Expect(e.Args).To(Equal([][]interface{}{
+		{"k", "***", "***", "***"},
+		{"***"},
+		{"***", 2, "k1", "k2", "***"},
+		{"***", "***", "***", "***", "k", "***"},
+		{"k1", "***", "k2", "***"},
+		// the credentials aren't keys
+		{"***", "***"},
+		{"***", "***", "***", "***"},
+	}))
+	NewWithT(t).Expect(e.String()).To(HavePrefix(`SET "k" "***" "***" "***"; PING "***"; EVAL "***" "2" "k1" "k2" "***"; XREADGROUP`))
+
+	// the commands sent keep their args
+	cmd := Command("SET", "k", "v")
+	e = newHookEvent([]*CMD{cmd})
+	h.Before(context.Background(), e)
+	NewWithT(t).Expect(cmd.args).To(Equal([]interface{}{"k", "v"}))
+}
@@ -0,0 +1,68 @@
+package redis
+
+import (
+	"context"
+)
+
+// NodeRanger is implemented by the operators spreading keys over several servers, see RangeNodes
+type NodeRanger interface {
+	RangeNodes(ctx context.Context, fn func(c Conn) error) error
+}
+
+var (
+	_ NodeRanger = (*ShardedRedis)(nil)
+	_ NodeRanger = (*ClusterRedis)(nil)
+	_ NodeRanger = (*CircuitBreaker)(nil)
+)
+
+// RangeNodes calls fn with a connection of each server holding keys of op, for the keyless commands
+// which only see the keys of their server, as SCAN, KEYS or DBSIZE. The connection is closed once fn returns,
+// the first error stops the ranging and is returned.
+// Operators which aren't NodeRangers hold every key on the server of GetContext.
+func RangeNodes(ctx context.Context, op RedisOperator, fn func(c Conn) error) error {
+	if n, ok := op.(NodeRanger); ok {
+		return n.RangeNodes(ctx, fn)
+	}
+	return rangeNode(ctx, op.GetContext, fn)
+}
+
+func rangeNode(ctx context.Context, get func(ctx context.Context) (Conn, error), fn func(c Conn) error) error {
+	c, err := get(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return fn(c)
+}
+
+// RangeNodes ranges the nodes of each shard in the order of the shard names
+func (s *ShardedRedis) RangeNodes(ctx context.Context, fn func(c Conn) error) error {
+	for _, name := range s.names {
+		if err := RangeNodes(ctx, s.shards[name], fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RangeNodes ranges the masters of the slot map, a slot migrating meanwhile may be missed or seen twice
+func (c *ClusterRedis) RangeNodes(ctx context.Context, fn func(c Conn) error) error {
+	addrs, err := c.nodeAddrs()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := rangeNode(ctx, c.pool(addr).GetContext, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RangeNodes fails with ErrCircuitOpen while the breaker is open, the ranging isn't counted as a request
+func (b *CircuitBreaker) RangeNodes(ctx context.Context, fn func(c Conn) error) error {
+	if b.State() == CircuitOpen {
+		return ErrCircuitOpen
+	}
+	return RangeNodes(ctx, b.op, fn)
+}
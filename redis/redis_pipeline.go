@@ -0,0 +1,182 @@
+package redis
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Reply is the reply of a pipelined command, Err is the error reply of Redis or the error of the connection
+type Reply struct {
+	Value interface{}
+	Err   error
+}
+
+// Pipeliner is implemented by the operators which pipeline commands themselves, see Pipeline
+type Pipeliner interface {
+	Pipeline(ctx context.Context, cmds ...*CMD) ([]Reply, error)
+}
+
+var (
+	_ Pipeliner = (*Redis)(nil)
+	_ Pipeliner = (*RedisEndpoint)(nil)
+	_ Pipeliner = (*ShardedRedis)(nil)
+	_ Pipeliner = (*CircuitBreaker)(nil)
+)
+
+const defaultPipelineChunkSize = 1000
+
+// Pipeline sends cmds without waiting for each reply, which saves a round trip per command.
+// Unlike Exec the commands aren't a transaction: commands of other clients may run in between,
+// and a failing command doesn't stop the others.
+// It returns a reply per command, nil commands get an empty one, and the error of the connection when it failed,
+// which is the Err of the commands left without reply.
+// Operators which aren't Pipeliners pipeline on a connection of GetContext.
+func Pipeline(ctx context.Context, op RedisOperator, cmds ...*CMD) ([]Reply, error) {
+	if p, ok := op.(Pipeliner); ok {
+		return p.Pipeline(ctx, cmds...)
+	}
+	return pipeline(ctx, op.GetContext, cmds, defaultPipelineChunkSize)
+}
+
+// pipeline sends cmds on one connection in chunks of chunkSize, reading the replies of a chunk before sending the next,
+// so huge batches don't pile up replies in the buffers of both sides
+func pipeline(ctx context.Context, get func(ctx context.Context) (Conn, error), cmds []*CMD, chunkSize int) ([]Reply, error) {
+	replies := make([]Reply, len(cmds))
+	if len(cmds) == 0 {
+		return replies, nil
+	}
+
+	fail := func(from int, err error) ([]Reply, error) {
+		for i := from; i < len(cmds); i++ {
+			if cmds[i] != nil {
+				replies[i].Err = err
+			}
+		}
+		return replies, err
+	}
+
+	c, err := get(ctx)
+	if err != nil {
+		return fail(0, err)
+	}
+	defer c.Close()
+
+	if chunkSize <= 0 {
+		chunkSize = defaultPipelineChunkSize
+	}
+
+	for start := 0; start < len(cmds); start += chunkSize {
+		end := start + chunkSize
+		if end > len(cmds) {
+			end = len(cmds)
+		}
+
+		for _, cmd := range cmds[start:end] {
+			if cmd == nil {
+				continue
+			}
+			if err := c.Send(cmd.name, cmd.args...); err != nil {
+				return fail(start, err)
+			}
+		}
+		if err := c.Flush(); err != nil {
+			return fail(start, err)
+		}
+
+		for i := start; i < end; i++ {
+			if cmds[i] == nil {
+				continue
+			}
+			v, err := redis.ReceiveContext(c, ctx)
+			if err != nil {
+				if _, ok := err.(redis.Error); !ok {
+					return fail(i, err)
+				}
+			}
+			replies[i] = Reply{Value: v, Err: err}
+		}
+	}
+
+	return replies, nil
+}
+
+func (r *Redis) Pipeline(ctx context.Context, cmds ...*CMD) ([]Reply, error) {
+	return pipelineWithHooks(ctx, r.Hooks, cmds, func(ctx context.Context) ([]Reply, error) {
+		return pipeline(ctx, r.GetContext, cmds, r.PipelineChunkSize)
+	})
+}
+
+func (r *RedisEndpoint) Pipeline(ctx context.Context, cmds ...*CMD) ([]Reply, error) {
+	return pipelineWithHooks(ctx, r.Hooks, cmds, func(ctx context.Context) ([]Reply, error) {
+		return pipeline(ctx, r.GetContext, cmds, r.pipelineChunkSize)
+	})
+}
+
+// Pipeline pipelines the commands of each shard concurrently, keyless commands go to the first shard,
+// the replies of the commands whose keys map to several shards are ErrCrossShard
+func (s *ShardedRedis) Pipeline(ctx context.Context, cmds ...*CMD) ([]Reply, error) {
+	replies := make([]Reply, len(cmds))
+
+	indexes := map[string][]int{}
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		name, err := s.commandsShard(cmd)
+		if err != nil {
+			replies[i].Err = err
+			continue
+		}
+		indexes[name] = append(indexes[name], i)
+	}
+
+	mu := sync.Mutex{}
+	var firstErr error
+	wg := sync.WaitGroup{}
+
+	for name := range indexes {
+		wg.Add(1)
+		go func(name string, indexes []int) {
+			defer wg.Done()
+
+			shardCmds := make([]*CMD, len(indexes))
+			for i, j := range indexes {
+				shardCmds[i] = cmds[j]
+			}
+
+			shardReplies, err := Pipeline(ctx, s.shards[name], shardCmds...)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for i, j := range indexes {
+				replies[j] = shardReplies[i]
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(name, indexes[name])
+	}
+
+	wg.Wait()
+	return replies, firstErr
+}
+
+// Pipeline counts a pipeline as one request, which fails when its connection fails
+func (b *CircuitBreaker) Pipeline(ctx context.Context, cmds ...*CMD) ([]Reply, error) {
+	trial, err := b.allow()
+	if err != nil {
+		replies := make([]Reply, len(cmds))
+		for i := range cmds {
+			if cmds[i] != nil {
+				replies[i].Err = err
+			}
+		}
+		return replies, err
+	}
+
+	replies, err := Pipeline(ctx, b.op, cmds...)
+	b.done(trial, b.opt.IsFailure(err))
+	return replies, err
+}
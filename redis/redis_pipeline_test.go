@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-courier/envconf"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+// plainOperator hides the Pipeline of the operator it wraps
+type plainOperator struct {
+	RedisOperator
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+
+	r := &Redis{
+		Host:              server.Host(),
+		Port:              server.Port(),
+		PipelineChunkSize: 3,
+	}
+	r.SetDefaults()
+	r.Init()
+
+	endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("redis://%s?pipelineChunkSize=2", server.Addr()))
+	NewWithT(t).Expect(err).To(BeNil())
+	e := &RedisEndpoint{Endpoint: *endpoint}
+	e.Init()
+
+	operators := map[string]RedisOperator{
+		"redis":    r,
+		"endpoint": e,
+		"plain":    &plainOperator{RedisOperator: r},
+	}
+
+	for name, op := range operators {
+		t.Run(name, func(t *testing.T) {
+			key := op.Prefix(name)
+
+			cmds := []*CMD{Command("DEL", key)}
+			for i := 0; i < 10; i++ {
+				cmds = append(cmds, Command("INCR", key))
+			}
+			cmds = append(cmds, nil, Command("LPUSH", key, "x"), Command("GET", key))
+
+			replies, err := Pipeline(ctx, op, cmds...)
+			NewWithT(t).Expect(err).To(BeNil())
+			NewWithT(t).Expect(replies).To(HaveLen(len(cmds)))
+
+			for i := 1; i <= 10; i++ {
+				NewWithT(t).Expect(replies[i].Err).To(BeNil())
+				NewWithT(t).Expect(redis.Int(replies[i].Value, nil)).To(Equal(i))
+			}
+			NewWithT(t).Expect(replies[11]).To(Equal(Reply{}))
+			NewWithT(t).Expect(replies[12].Err).To(MatchError(ContainSubstring("WRONGTYPE")))
+			NewWithT(t).Expect(redis.Int(replies[13].Value, replies[13].Err)).To(Equal(10))
+		})
+	}
+
+	t.Run("connection errors", func(t *testing.T) {
+		broken := &Redis{Host: "127.0.0.1", Port: 1}
+		broken.SetDefaults()
+		broken.Init()
+
+		replies, err := Pipeline(ctx, broken, Command("GET", "a"), nil)
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(replies[0].Err).To(Equal(err))
+		NewWithT(t).Expect(replies[1].Err).To(BeNil())
+	})
+}
+
+func TestShardedPipeline(t *testing.T) {
+	ctx := context.Background()
+
+	servers := []*redistest.Server{redistest.NewServer(), redistest.NewServer()}
+	operators := map[string]RedisOperator{}
+	for i, s := range servers {
+		defer s.Close()
+		r := &Redis{Host: s.Host(), Port: s.Port()}
+		r.SetDefaults()
+		r.Init()
+		operators[fmt.Sprintf("shard-%d", i)] = r
+	}
+
+	sharded := NewShardedRedis(operators, 0)
+
+	cmds := make([]*CMD, 0)
+	for i := 0; i < 20; i++ {
+		cmds = append(cmds, Command("SET", sharded.Prefix(fmt.Sprint(i)), i))
+	}
+	for i := 0; i < 20; i++ {
+		cmds = append(cmds, Command("GET", sharded.Prefix(fmt.Sprint(i))))
+	}
+
+	replies, err := sharded.Pipeline(ctx, cmds...)
+	NewWithT(t).Expect(err).To(BeNil())
+	for i := 0; i < 20; i++ {
+		NewWithT(t).Expect(redis.Int(replies[20+i].Value, replies[20+i].Err)).To(Equal(i))
+	}
+
+	// keys were spread across the shards
+	for _, op := range operators {
+		NewWithT(t).Expect(redis.Int(op.Exec(Command("DBSIZE")))).To(BeNumerically(">", 0))
+	}
+}
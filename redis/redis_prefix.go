@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultKeyPrefix is the prefix of the keys of operators without KeyPrefix, as env:project
+// from the environment variables GOENV and PROJECT_NAME, lowercased.
+// The operators read it once when Init is called, later changes of the environment don't move their keys.
+func DefaultKeyPrefix() string {
+	return fmt.Sprintf("%s:%s", strings.ToLower(os.Getenv("GOENV")), strings.ToLower(os.Getenv("PROJECT_NAME")))
+}
+
+// prefixKey returns key in the namespace keyPrefix, or key as it is when disabled.
+// keyPrefix is only empty for operators used before Init, DefaultKeyPrefix is read for them.
+func prefixKey(keyPrefix string, disabled bool, key string) string {
+	if disabled {
+		return key
+	}
+	if keyPrefix == "" {
+		keyPrefix = DefaultKeyPrefix()
+	}
+	return fmt.Sprintf("%s:%s", keyPrefix, key)
+}
+
+// Unprefix removes the prefix of op from key, as for the keys returned by SCAN or KEYS, keys of other namespaces are returned as they are
+func Unprefix(op RedisOperator, key string) string {
+	return strings.TrimPrefix(key, op.Prefix(""))
+}
@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-courier/envconf"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+func TestPrefix(t *testing.T) {
+	t.Setenv("GOENV", "TEST")
+	t.Setenv("PROJECT_NAME", "Helper")
+
+	NewWithT(t).Expect((&Redis{}).Prefix("k")).To(Equal("test:helper:k"))
+	NewWithT(t).Expect((&Redis{KeyPrefix: "app"}).Prefix("k")).To(Equal("app:k"))
+	NewWithT(t).Expect((&Redis{KeyPrefix: "app", DisablePrefix: true}).Prefix("k")).To(Equal("k"))
+	NewWithT(t).Expect((&ClusterRedis{KeyPrefix: "app"}).Prefix("{k}")).To(Equal("app:{k}"))
+
+	s := redistest.NewServer()
+	defer s.Close()
+
+	t.Run("the default prefix is read by Init", func(t *testing.T) {
+		r := &Redis{Host: s.Host(), Port: s.Port()}
+		r.SetDefaults()
+		r.Init()
+
+		t.Setenv("GOENV", "PROD")
+		NewWithT(t).Expect(r.Prefix("k")).To(Equal("test:helper:k"))
+		NewWithT(t).Expect((&Redis{}).Prefix("k")).To(Equal("prod:helper:k"))
+	})
+
+	t.Run("instances have their namespaces", func(t *testing.T) {
+		a := &Redis{Host: s.Host(), Port: s.Port(), KeyPrefix: "a"}
+		a.SetDefaults()
+		a.Init()
+
+		endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("redis://%s?keyPrefix=b", s.Addr()))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		b := &RedisEndpoint{Endpoint: *endpoint}
+		b.Init()
+		NewWithT(t).Expect(b.Prefix("k")).To(Equal("b:k"))
+
+		for _, op := range []RedisOperator{a, b} {
+			_, err := op.Exec(Command("SET", op.Prefix("k"), op.Prefix("")))
+			NewWithT(t).Expect(err).To(BeNil())
+		}
+		for _, op := range []RedisOperator{a, b} {
+			NewWithT(t).Expect(redis.String(op.Exec(Command("GET", op.Prefix("k"))))).To(Equal(op.Prefix("")))
+		}
+	})
+
+	t.Run("prefixing can be disabled by the extra", func(t *testing.T) {
+		endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("redis://%s?keyPrefix=b&disablePrefix=true", s.Addr()))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		r := &RedisEndpoint{Endpoint: *endpoint}
+		r.Init()
+		NewWithT(t).Expect(r.Prefix("k")).To(Equal("k"))
+		NewWithT(t).Expect(Unprefix(r, "b:k")).To(Equal("b:k"))
+	})
+
+	t.Run("Unprefix", func(t *testing.T) {
+		r := &Redis{Host: s.Host(), Port: s.Port(), KeyPrefix: "scan"}
+		r.SetDefaults()
+		r.Init()
+
+		s.FlushAll()
+		_, err := r.Exec(Command("SET", r.Prefix("k"), "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		values, err := redis.Values(r.Exec(Command("SCAN", 0, "MATCH", r.Prefix("*"))))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		keys, err := redis.Strings(values[1], nil)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(keys).To(HaveLen(1))
+		NewWithT(t).Expect(Unprefix(r, keys[0])).To(Equal("k"))
+		NewWithT(t).Expect(Unprefix(r, "other:k")).To(Equal("other:k"))
+	})
+}
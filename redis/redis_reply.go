@@ -0,0 +1,232 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-courier/reflectx"
+	"github.com/gomodule/redigo/redis"
+	"github.com/zj-open-source/helper/internal/fields"
+)
+
+// ReplyError is the error of decoding the reply of a command, or the error reply of the command
+type ReplyError struct {
+	// Index is the position of the command among the commands of Exec
+	Index   int
+	Command string
+	Err     error
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("redis: reply of command %d %s: %s", e.Index, e.Command, e.Err)
+}
+
+func (e *ReplyError) Unwrap() error {
+	return e.Err
+}
+
+// ExecResult runs the commands as ExecContext does, in a transaction when there are several, and returns their replies to decode
+func ExecResult(ctx context.Context, op RedisOperator, cmd *CMD, others ...*CMD) *Result {
+	cmds := make([]*CMD, 0, len(others)+1)
+	for _, c := range append([]*CMD{cmd}, others...) {
+		if c != nil {
+			cmds = append(cmds, c)
+		}
+	}
+	reply, err := op.ExecContext(ctx, cmd, others...)
+	return &Result{cmds: cmds, reply: reply, err: err}
+}
+
+// Result holds the replies of the commands run by ExecResult
+type Result struct {
+	cmds  []*CMD
+	reply interface{}
+	err   error
+}
+
+// Err returns the error of running the commands, the error replies of commands in a transaction are returned by Scan
+func (r *Result) Err() error {
+	if e, ok := r.err.(redis.Error); ok && len(r.cmds) == 1 {
+		return r.replyError(0, e)
+	}
+	return r.err
+}
+
+// Replies returns the reply of each command
+func (r *Result) Replies() ([]interface{}, error) {
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	if len(r.cmds) == 1 {
+		return []interface{}{r.reply}, nil
+	}
+	values, err := redis.Values(r.reply, nil)
+	if err != nil {
+		return nil, fmt.Errorf("redis: unexpected EXEC reply: %w", err)
+	}
+	if len(values) != len(r.cmds) {
+		return nil, fmt.Errorf("redis: EXEC replied %d values for %d commands", len(values), len(r.cmds))
+	}
+	return values, nil
+}
+
+// Scan decodes the reply of each command into the pointer at the same index of dests, a nil dest skips its reply.
+// See Decode for the supported types.
+func (r *Result) Scan(dests ...interface{}) error {
+	replies, err := r.Replies()
+	if err != nil {
+		return err
+	}
+	if len(dests) > len(replies) {
+		return fmt.Errorf("redis: %d dests for %d commands", len(dests), len(replies))
+	}
+	for i, dest := range dests {
+		if dest == nil {
+			continue
+		}
+		if err := Decode(replies[i], dest); err != nil {
+			return r.replyError(i, err)
+		}
+	}
+	return nil
+}
+
+func (r *Result) replyError(i int, err error) error {
+	return &ReplyError{Index: i, Command: strings.ToUpper(r.cmds[i].name), Err: err}
+}
+
+// Decode decodes reply into the value dest points to:
+// bulk strings, integers and status replies into strings, []byte, numbers, bools and encoding.TextUnmarshaler,
+// arrays into slices, and arrays of field value pairs, as HGETALL replies, into maps and structs,
+// whose fields are named by the tag `redis:"name"` and by the field name without it.
+// A nil reply sets pointers, slices, maps and interfaces to nil and returns redis.ErrNil for other types.
+// An error reply is returned as it is.
+func Decode(reply interface{}, dest interface{}) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("redis: decode into non-pointer %T", dest)
+	}
+	return decode(reply, rv.Elem())
+}
+
+func decode(reply interface{}, rv reflect.Value) error {
+	if e, ok := reply.(redis.Error); ok {
+		return e
+	}
+
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		if reply == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		rv.Set(reflect.ValueOf(reply))
+		return nil
+	}
+
+	if reply == nil {
+		switch rv.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		return redis.ErrNil
+	}
+
+	if rv.Kind() == reflect.Ptr && !fields.IsTextUnmarshaler(rv.Type()) {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decode(reply, rv.Elem())
+	}
+
+	if fields.IsText(rv.Type()) {
+		b, err := replyBytes(reply)
+		if err != nil {
+			return fmt.Errorf("cannot decode into %s: %w", rv.Type(), err)
+		}
+		if rv.Kind() == reflect.Slice {
+			rv.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+		if err := reflectx.UnmarshalText(rv, b); err != nil {
+			return fmt.Errorf("cannot decode %q into %s: %w", b, rv.Type(), err)
+		}
+		return nil
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok {
+		return fmt.Errorf("cannot decode %T into %s", reply, rv.Type())
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(rv.Type(), len(values), len(values))
+		for i := range values {
+			if err := decode(values[i], s.Index(i)); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		rv.Set(s)
+		return nil
+
+	case reflect.Map:
+		if len(values)%2 != 0 {
+			return fmt.Errorf("cannot decode %d values into %s, want field value pairs", len(values), rv.Type())
+		}
+		m := reflect.MakeMapWithSize(rv.Type(), len(values)/2)
+		for i := 0; i < len(values); i += 2 {
+			k := reflect.New(rv.Type().Key()).Elem()
+			if err := decode(values[i], k); err != nil {
+				return fmt.Errorf("key %d: %w", i/2, err)
+			}
+			v := reflect.New(rv.Type().Elem()).Elem()
+			if err := decode(values[i+1], v); err != nil {
+				return fmt.Errorf("field %v: %w", k.Interface(), err)
+			}
+			m.SetMapIndex(k, v)
+		}
+		rv.Set(m)
+		return nil
+
+	case reflect.Struct:
+		if len(values)%2 != 0 {
+			return fmt.Errorf("cannot decode %d values into %s, want field value pairs", len(values), rv.Type())
+		}
+		indexes := map[string]int{}
+		for _, f := range fields.Of(rv.Type()) {
+			indexes[f.Name] = f.Index
+		}
+		for i := 0; i < len(values); i += 2 {
+			name, err := redis.String(values[i], nil)
+			if err != nil {
+				return fmt.Errorf("field name %d: %w", i/2, err)
+			}
+			index, ok := indexes[name]
+			if !ok {
+				continue
+			}
+			if err := decode(values[i+1], rv.Field(index)); err != nil {
+				return fmt.Errorf("field %s: %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("cannot decode an array into %s", rv.Type())
+}
+
+func replyBytes(reply interface{}) ([]byte, error) {
+	switch v := reply.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	}
+	return nil, fmt.Errorf("unexpected reply type %T", reply)
+}
@@ -0,0 +1,100 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+)
+
+type user struct {
+	Name      string    `redis:"name"`
+	Age       int       `redis:"age"`
+	Admin     bool      `redis:"admin"`
+	CreatedAt time.Time `redis:"createdAt"`
+	Nickname  *string   `redis:"nickname"`
+	Ignored   string    `redis:"-"`
+}
+
+func TestResult(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+
+	r := &Redis{
+		Host: server.Host(),
+		Port: server.Port(),
+	}
+	r.SetDefaults()
+	r.Init()
+
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("single reply into struct", func(t *testing.T) {
+		_, err := r.Exec(Command("HSET", r.Prefix("user:1"), "name", "alice", "age", 30, "admin", 1, "createdAt", createdAt.Format(time.RFC3339), "Ignored", "x"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		u := user{}
+		NewWithT(t).Expect(ExecResult(ctx, r, Command("HGETALL", r.Prefix("user:1"))).Scan(&u)).To(BeNil())
+		NewWithT(t).Expect(u).To(Equal(user{Name: "alice", Age: 30, Admin: true, CreatedAt: createdAt}))
+	})
+
+	t.Run("exec replies", func(t *testing.T) {
+		status := ""
+		n := int64(0)
+		missing := (*string)(nil)
+		list := []int{}
+		ages := map[string]int{}
+		var raw interface{}
+
+		err := ExecResult(ctx, r,
+			Command("SET", r.Prefix("str"), "1"),
+			Command("INCR", r.Prefix("str")),
+			nil,
+			Command("GET", r.Prefix("missing")),
+			Command("RPUSH", r.Prefix("list"), 1, 2, 3),
+			Command("LRANGE", r.Prefix("list"), 0, -1),
+			Command("HGETALL", r.Prefix("ages")),
+			Command("GET", r.Prefix("str")),
+		).Scan(&status, &n, &missing, nil, &list, &ages, &raw)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(status).To(Equal("OK"))
+		NewWithT(t).Expect(n).To(Equal(int64(2)))
+		NewWithT(t).Expect(missing).To(BeNil())
+		NewWithT(t).Expect(list).To(Equal([]int{1, 2, 3}))
+		NewWithT(t).Expect(ages).To(BeEmpty())
+		NewWithT(t).Expect(raw).To(Equal([]byte("2")))
+
+		_, err = r.Exec(Command("HSET", r.Prefix("ages"), "alice", 30, "bob", 40))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(ExecResult(ctx, r, Command("HGETALL", r.Prefix("ages"))).Scan(&ages)).To(BeNil())
+		NewWithT(t).Expect(ages).To(Equal(map[string]int{"alice": 30, "bob": 40}))
+	})
+
+	t.Run("errors name the command", func(t *testing.T) {
+		n := 0
+		err := ExecResult(ctx, r,
+			Command("SET", r.Prefix("str"), "abc"),
+			Command("GET", r.Prefix("str")),
+		).Scan(nil, &n)
+		e := &ReplyError{}
+		NewWithT(t).Expect(errors.As(err, &e)).To(BeTrue())
+		NewWithT(t).Expect(e.Index).To(Equal(1))
+		NewWithT(t).Expect(e.Command).To(Equal("GET"))
+
+		err = ExecResult(ctx, r,
+			Command("GET", r.Prefix("str")),
+			Command("LPUSH", r.Prefix("str"), "x"),
+		).Scan(nil, &n)
+		NewWithT(t).Expect(err).To(MatchError(ContainSubstring("command 1 LPUSH: WRONGTYPE")))
+
+		err = ExecResult(ctx, r, Command("LPUSH", r.Prefix("str"), "x")).Err()
+		NewWithT(t).Expect(err).To(MatchError(ContainSubstring("command 0 LPUSH: WRONGTYPE")))
+
+		s := ""
+		err = ExecResult(ctx, r, Command("GET", r.Prefix("missing"))).Scan(&s)
+		NewWithT(t).Expect(errors.Is(err, redis.ErrNil)).To(BeTrue())
+	})
+}
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-courier/envconf"
+	"github.com/gomodule/redigo/redis"
+)
+
+// RetryPolicy runs again the commands of Exec failing with transient errors, waiting longer before each attempt.
+// Commands refused by Redis, as with LOADING, and commands which couldn't be sent are always safe to run again.
+// When the connection failed once they were sent, whether they ran is unknown,
+// so only idempotent commands run alone are retried, as GET, SET or HSET, and never the commands of a transaction.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a command runs at most, 1 disables retries
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry, doubled before each next one up to MaxBackoff.
+	// A random part of up to half of each wait is skipped, so clients failing together don't retry together.
+	MinBackoff envconf.Duration
+	MaxBackoff envconf.Duration
+	// RetryNonIdempotent retries the commands which may be applied twice too, as INCR or LPUSH
+	RetryNonIdempotent bool
+	// IsRetryable reports whether err is transient, IsTransient by default
+	IsRetryable func(err error) bool
+}
+
+func (p *RetryPolicy) SetDefaults() {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.MinBackoff == 0 {
+		p.MinBackoff = envconf.Duration(10 * time.Millisecond)
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = envconf.Duration(500 * time.Millisecond)
+	}
+	if p.IsRetryable == nil {
+		p.IsRetryable = IsTransient
+	}
+}
+
+// transientReplies are the codes, the first words, of the error replies of a server which isn't ready for now
+var transientReplies = map[string]bool{
+	"LOADING":     true,
+	"TRYAGAIN":    true,
+	"BUSY":        true,
+	"MASTERDOWN":  true,
+	"CLUSTERDOWN": true,
+	"READONLY":    true,
+}
+
+// IsTransient reports whether err may go away by itself: connection failures, an exhausted pool,
+// and error replies of a server loading its data, failing over or running a long script
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if e, ok := err.(redis.Error); ok {
+		// BUSYGROUP isn't BUSY
+		code := strings.SplitN(string(e), " ", 2)[0]
+		return transientReplies[code]
+	}
+	return errors.Is(err, redis.ErrPoolExhausted) || isConnError(err)
+}
+
+func isConnError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// unsent reports whether err was returned before the commands were sent, as the connection couldn't be made
+func unsent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, redis.ErrPoolExhausted)
+}
+
+// do calls exec until it succeeds, fails with an error which can't be retried or the attempts run out, and returns its last result
+func (p *RetryPolicy) do(ctx context.Context, cmd *CMD, others []*CMD, exec func() (interface{}, error)) (interface{}, error) {
+	for attempt := 1; ; attempt++ {
+		reply, err := exec()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err, cmd, others) {
+			return reply, err
+		}
+
+		t := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return reply, err
+		case <-t.C:
+		}
+	}
+}
+
+func (p *RetryPolicy) retryable(err error, cmd *CMD, others []*CMD) bool {
+	isRetryable := p.IsRetryable
+	if isRetryable == nil {
+		isRetryable = IsTransient
+	}
+	if !isRetryable(err) {
+		return false
+	}
+
+	// refused by Redis, EXEC included, so nothing ran
+	if _, ok := err.(redis.Error); ok {
+		return true
+	}
+	if unsent(err) {
+		return true
+	}
+
+	for _, o := range others {
+		if o != nil {
+			// EXEC may have run
+			return false
+		}
+	}
+	return p.RetryNonIdempotent || cmd.idempotent()
+}
+
+// backoff returns the wait before the retry, 1 for the first one
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d, max := time.Duration(p.MinBackoff), time.Duration(p.MaxBackoff)
+	for i := 1; i < retry && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-courier/envconf"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+func TestRetry(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+
+	r := &Redis{Host: s.Host(), Port: s.Port()}
+	r.SetDefaults()
+	r.Retry.MinBackoff = envconf.Duration(time.Millisecond)
+	r.Init()
+
+	key := r.Prefix("retry")
+
+	t.Run("error replies are retried", func(t *testing.T) {
+		s.FlushAll()
+		s.InjectError("INCR", "LOADING Redis is loading the dataset in memory", 2)
+
+		// INCR isn't idempotent, but it didn't run
+		NewWithT(t).Expect(redis.Int(r.Exec(Command("INCR", key)))).To(Equal(1))
+	})
+
+	t.Run("until the attempts run out", func(t *testing.T) {
+		s.FlushAll()
+		s.InjectError("GET", "TRYAGAIN Multiple keys request during rehashing of slot", 3)
+
+		_, err := r.Exec(Command("GET", key))
+		NewWithT(t).Expect(err).To(MatchError(HavePrefix("TRYAGAIN")))
+	})
+
+	t.Run("other errors aren't retried", func(t *testing.T) {
+		s.FlushAll()
+		s.InjectError("GET", "ERR unexpected", 1)
+		s.InjectError("SET", "TRYAGAIN", 1)
+
+		_, err := r.Exec(Command("GET", key))
+		NewWithT(t).Expect(err).To(MatchError("ERR unexpected"))
+
+		// the next command succeeds, the injected error was consumed once
+		_, err = r.Exec(Command("SET", key, "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+	})
+
+	t.Run("transactions refused by EXEC are retried", func(t *testing.T) {
+		s.FlushAll()
+		s.InjectError("EXEC", "LOADING Redis is loading the dataset in memory", 1)
+
+		values, err := redis.Values(r.Exec(Command("INCR", key), Command("INCR", key)))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(values).To(Equal([]interface{}{int64(1), int64(2)}))
+	})
+
+	t.Run("idempotent commands are retried on broken connections", func(t *testing.T) {
+		s.FlushAll()
+		_, err := r.Exec(Command("SET", key, "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		s.DropConnections()
+		NewWithT(t).Expect(redis.String(r.Exec(Command("GET", key)))).To(Equal("1"))
+
+		s.DropConnections()
+		_, err = r.Exec(Command("SET", key, "2"))
+		NewWithT(t).Expect(err).To(BeNil())
+	})
+
+	t.Run("other commands and transactions aren't retried on broken connections", func(t *testing.T) {
+		s.FlushAll()
+		_, err := r.Exec(Command("SET", key, "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		s.DropConnections()
+		_, err = r.Exec(Command("INCR", key))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		_, err = r.Exec(Command("SET", key, "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		s.DropConnections()
+		_, err = r.Exec(Command("SET", key, "2"), Command("GET", key))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+
+	t.Run("connections which couldn't be made are retried", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		NewWithT(t).Expect(err).To(BeNil())
+		addr := l.Addr().(*net.TCPAddr)
+		_ = l.Close()
+
+		endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("redis://%s?retryMinBackoff=1ms", addr))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		e := &RedisEndpoint{Endpoint: *endpoint}
+		e.Init()
+		NewWithT(t).Expect(e.Retry.MaxAttempts).To(Equal(3))
+		NewWithT(t).Expect(e.Retry.MinBackoff).To(Equal(envconf.Duration(time.Millisecond)))
+
+		attempts := 0
+		e.Retry.IsRetryable = func(err error) bool {
+			attempts++
+			return IsTransient(err)
+		}
+
+		_, err = e.Exec(Command("INCR", key), Command("INCR", key))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(attempts).To(Equal(2))
+	})
+}
+
+func TestIsTransient(t *testing.T) {
+	NewWithT(t).Expect(IsTransient(redis.Error("BUSY Redis is busy running a script"))).To(BeTrue())
+	NewWithT(t).Expect(IsTransient(redis.Error("TRYAGAIN"))).To(BeTrue())
+	NewWithT(t).Expect(IsTransient(redis.Error("BUSYGROUP Consumer Group name already exists"))).To(BeFalse())
+	NewWithT(t).Expect(IsTransient(redis.Error("ERR unknown command"))).To(BeFalse())
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{}
+	p.SetDefaults()
+
+	for retry, max := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 20: 500 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			d := p.backoff(retry)
+			NewWithT(t).Expect(d).To(BeNumerically(">=", max/2))
+			NewWithT(t).Expect(d).To(BeNumerically("<=", max))
+		}
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	for cmd, idempotent := range map[*CMD]bool{
+		Command("GET", "k"):                     true,
+		Command("SET", "k", "v", "EX", 10):      true,
+		Command("SET", "k", "NX"):               true,
+		Command("SET", "k", "v", "NX"):          false,
+		Command("SET", "k", []byte("v"), "GET"): false,
+		Command("ZADD", "k", 1, "m"):            true,
+		Command("ZADD", "k", "INCR", 1, "m"):    false,
+		Command("INCR", "k"):                    false,
+		Command("LTRIM", "k", 1, -1):            false,
+		Command("ZREMRANGEBYRANK", "k", 0, 0):   false,
+		Command("EVAL", "return 1", 0):          false,
+	} {
+		NewWithT(t).Expect(cmd.idempotent()).To(Equal(idempotent), fmt.Sprint(cmd.name, cmd.args))
+	}
+}
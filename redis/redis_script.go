@@ -0,0 +1,91 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// NewScript creates a Lua script taking keyCount keys, which come first in the keys and args of its methods
+func NewScript(keyCount int, src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{
+		keyCount: keyCount,
+		src:      src,
+		hash:     hex.EncodeToString(sum[:]),
+	}
+}
+
+// Script runs Lua on Redis atomically, its keys are prefixed by the operator.
+// Declare scripts once, for example as package variables, as Redis caches them by SHA1.
+type Script struct {
+	keyCount int
+	src      string
+	hash     string
+}
+
+// Hash returns the SHA1 of the script, which EVALSHA takes
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// Load loads the script into the script cache, Do loads it on its first call anyway
+func (s *Script) Load(ctx context.Context, op RedisOperator) error {
+	_, err := op.ExecContext(ctx, Command("SCRIPT", "LOAD", s.src))
+	return err
+}
+
+// Do runs the script with EVALSHA and with EVAL when Redis doesn't know it yet, which caches it for the next calls
+func (s *Script) Do(ctx context.Context, op RedisOperator, keysAndArgs ...interface{}) (interface{}, error) {
+	args, err := s.args(op, keysAndArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := op.ExecContext(ctx, Command("EVALSHA", append([]interface{}{s.hash}, args...)...))
+	if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "NOSCRIPT") {
+		return op.ExecContext(ctx, Command("EVAL", append([]interface{}{s.src}, args...)...))
+	}
+	return reply, err
+}
+
+// Command returns the script as EVAL, to be run with other commands by Exec.
+// EVALSHA isn't used there, as its NOSCRIPT error would come with EXEC once the other commands ran.
+func (s *Script) Command(op RedisOperator, keysAndArgs ...interface{}) (*CMD, error) {
+	args, err := s.args(op, keysAndArgs)
+	if err != nil {
+		return nil, err
+	}
+	return Command("EVAL", append([]interface{}{s.src}, args...)...), nil
+}
+
+// args returns numkeys followed by the prefixed keys and the args
+func (s *Script) args(op RedisOperator, keysAndArgs []interface{}) ([]interface{}, error) {
+	if len(keysAndArgs) < s.keyCount {
+		return nil, fmt.Errorf("redis: script takes %d keys, got %d values", s.keyCount, len(keysAndArgs))
+	}
+
+	args := make([]interface{}, 0, len(keysAndArgs)+1)
+	args = append(args, s.keyCount)
+	for i, v := range keysAndArgs {
+		if i < s.keyCount {
+			v = op.Prefix(toKey(v))
+		}
+		args = append(args, v)
+	}
+	return args, nil
+}
+
+func toKey(v interface{}) string {
+	switch k := v.(type) {
+	case string:
+		return k
+	case []byte:
+		return string(k)
+	}
+	return fmt.Sprint(v)
+}
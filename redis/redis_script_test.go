@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+const incrScript = "return redis.call('INCRBY', KEYS[1], ARGV[1])"
+
+func init() {
+	// the test server can't run Lua
+	redistest.RegisterScript(incrScript, func(call func(name string, args ...interface{}) interface{}, keys []string, args [][]byte) interface{} {
+		return call("INCRBY", keys[0], args[0])
+	})
+}
+
+func TestScript(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+
+	r := &Redis{
+		Host: server.Host(),
+		Port: server.Port(),
+	}
+	r.SetDefaults()
+	r.Init()
+
+	incr := NewScript(1, incrScript)
+
+	t.Run("falls back to EVAL once", func(t *testing.T) {
+		NewWithT(t).Expect(redis.Ints(r.Exec(Command("SCRIPT", "EXISTS", incr.Hash())))).To(Equal([]int{0}))
+
+		NewWithT(t).Expect(redis.Int(incr.Do(ctx, r, "counter", 2))).To(Equal(2))
+		NewWithT(t).Expect(redis.Ints(r.Exec(Command("SCRIPT", "EXISTS", incr.Hash())))).To(Equal([]int{1}))
+		NewWithT(t).Expect(redis.Int(incr.Do(ctx, r, "counter", 3))).To(Equal(5))
+
+		// the key is prefixed
+		NewWithT(t).Expect(redis.Int(r.Exec(Command("GET", r.Prefix("counter"))))).To(Equal(5))
+	})
+
+	t.Run("load", func(t *testing.T) {
+		_, err := r.Exec(Command("SCRIPT", "FLUSH"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		NewWithT(t).Expect(incr.Load(ctx, r)).To(BeNil())
+		NewWithT(t).Expect(redis.Ints(r.Exec(Command("SCRIPT", "EXISTS", incr.Hash())))).To(Equal([]int{1}))
+	})
+
+	t.Run("within exec", func(t *testing.T) {
+		cmd, err := incr.Command(r, "batch", 10)
+		NewWithT(t).Expect(err).To(BeNil())
+
+		values, err := redis.Values(r.Exec(
+			Command("SET", r.Prefix("batch"), 1),
+			cmd,
+			Command("GET", r.Prefix("batch")),
+		))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(redis.Int(values[1], nil)).To(Equal(11))
+		NewWithT(t).Expect(redis.Int(values[2], nil)).To(Equal(11))
+	})
+
+	t.Run("missing keys", func(t *testing.T) {
+		_, err := incr.Do(ctx, r)
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		cmd, err := incr.Command(r)
+		NewWithT(t).Expect(err).NotTo(BeNil())
+		NewWithT(t).Expect(cmd).To(BeNil())
+	})
+}
@@ -0,0 +1,190 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+)
+
+var ErrCrossShard = errors.New("redis: keys of a command, or of the commands in one Exec, map to different shards, use {hashtag} to co-locate them")
+
+// ErrNoShards is the panic of NewShardedRedis without shards
+var ErrNoShards = errors.New("redis: sharded redis needs at least one shard")
+
+var (
+	_ RedisOperator = (*ShardedRedis)(nil)
+	_ KeyRouter     = (*ShardedRedis)(nil)
+)
+
+// NewShardedRedis distributes keys across shards with a consistent-hash ring.
+// Shards are identified by their names, so the ring stays stable when shards are reordered or added.
+// virtualNodes <= 0 falls back to 160 virtual nodes per shard. It panics with ErrNoShards without shards.
+func NewShardedRedis(shards map[string]RedisOperator, virtualNodes int) *ShardedRedis {
+	if len(shards) == 0 {
+		panic(ErrNoShards)
+	}
+	if virtualNodes <= 0 {
+		virtualNodes = 160
+	}
+
+	s := &ShardedRedis{
+		shards: make(map[string]RedisOperator, len(shards)),
+	}
+
+	for name, op := range shards {
+		s.names = append(s.names, name)
+		s.shards[name] = op
+
+		for i := 0; i < virtualNodes; i++ {
+			s.ring = append(s.ring, ringNode{
+				hash: crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", name, i))),
+				name: name,
+			})
+		}
+	}
+
+	sort.Strings(s.names)
+	sort.Slice(s.ring, func(i, j int) bool {
+		if s.ring[i].hash == s.ring[j].hash {
+			return s.ring[i].name < s.ring[j].name
+		}
+		return s.ring[i].hash < s.ring[j].hash
+	})
+
+	return s
+}
+
+type ShardedRedis struct {
+	names  []string
+	shards map[string]RedisOperator
+	ring   []ringNode
+}
+
+type ringNode struct {
+	hash uint32
+	name string
+}
+
+// Shard returns the shard owning key, key should be prefixed already
+func (s *ShardedRedis) Shard(key string) RedisOperator {
+	return s.shards[s.shardName(key)]
+}
+
+func (s *ShardedRedis) shardName(key string) string {
+	h := crc32.ChecksumIEEE([]byte(HashTag(key)))
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].name
+}
+
+// Prefix uses the first shard, shards are expected to share the same prefix
+func (s *ShardedRedis) Prefix(key string) string {
+	return s.shards[s.names[0]].Prefix(key)
+}
+
+// Get returns a connection of the first shard, for keyless commands, use GetContextForKey for the commands of a key
+func (s *ShardedRedis) Get() Conn {
+	return s.shards[s.names[0]].Get()
+}
+
+// GetContext returns a connection of the first shard, for keyless commands, use GetContextForKey for the commands of a key
+func (s *ShardedRedis) GetContext(ctx context.Context) (Conn, error) {
+	return s.shards[s.names[0]].GetContext(ctx)
+}
+
+// GetContextForKey returns a connection of the shard owning key, where Exec runs the commands of key, key should be prefixed already
+func (s *ShardedRedis) GetContextForKey(ctx context.Context, key string) (Conn, error) {
+	return GetContextForKey(ctx, s.Shard(key), key)
+}
+
+func (s *ShardedRedis) Exec(cmd *CMD, others ...*CMD) (interface{}, error) {
+	return s.ExecContext(context.Background(), cmd, others...)
+}
+
+// ExecContext runs the commands on the shard owning all their keys, on the first shard without keys,
+// it fails with ErrCrossShard when the keys map to several shards
+func (s *ShardedRedis) ExecContext(ctx context.Context, cmd *CMD, others ...*CMD) (interface{}, error) {
+	name, err := s.commandsShard(append([]*CMD{cmd}, others...)...)
+	if err != nil {
+		return nil, err
+	}
+	return s.shards[name].ExecContext(ctx, cmd, others...)
+}
+
+// commandsShard returns the name of the shard owning every key of cmds, the first shard when they have no key
+func (s *ShardedRedis) commandsShard(cmds ...*CMD) (string, error) {
+	name, err := s.keysShard(cmds)
+	if name == "" && err == nil {
+		name = s.names[0]
+	}
+	return name, err
+}
+
+// keysShard returns the name of the shard owning every key of cmds, empty when they have no key
+func (s *ShardedRedis) keysShard(cmds []*CMD) (string, error) {
+	name := ""
+
+	for _, c := range cmds {
+		if c == nil {
+			continue
+		}
+		for _, key := range c.keys() {
+			n := s.shardName(key)
+			if name != "" && name != n {
+				return "", ErrCrossShard
+			}
+			name = n
+		}
+	}
+
+	return name, nil
+}
+
+func (s *ShardedRedis) LivenessCheck() map[string]string {
+	m := map[string]string{}
+
+	for _, name := range s.names {
+		_, err := s.shards[name].Exec(Command("PING"))
+		if err != nil {
+			m[name] = err.Error()
+		} else {
+			m[name] = "ok"
+		}
+	}
+
+	return m
+}
+
+// KeyRouter is implemented by the operators spreading keys over several servers,
+// whose connections run the commands of one key only on the server owning it
+type KeyRouter interface {
+	GetContextForKey(ctx context.Context, key string) (Conn, error)
+}
+
+// GetContextForKey returns a connection of op where the commands of key run, as Exec runs them,
+// for WATCH or for commands sent on the connection itself.
+// Operators which aren't KeyRouters serve every key on the connections of GetContext.
+func GetContextForKey(ctx context.Context, op RedisOperator, key string) (Conn, error) {
+	if r, ok := op.(KeyRouter); ok {
+		return r.GetContextForKey(ctx, key)
+	}
+	return op.GetContext(ctx)
+}
+
+// HashTag returns the part of key between the first '{' and the following '}' when it is not empty,
+// otherwise the whole key. Keys sharing a hash tag are placed on the same shard.
+func HashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
@@ -0,0 +1,221 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+type recordOperator struct {
+	name string
+	keys []string
+}
+
+func (o *recordOperator) Prefix(key string) string {
+	return "test:" + key
+}
+
+func (o *recordOperator) Get() Conn {
+	return nil
+}
+
+func (o *recordOperator) GetContext(ctx context.Context) (Conn, error) {
+	return nil, nil
+}
+
+func (o *recordOperator) Exec(cmd *CMD, others ...*CMD) (interface{}, error) {
+	return o.ExecContext(context.Background(), cmd, others...)
+}
+
+func (o *recordOperator) ExecContext(ctx context.Context, cmd *CMD, others ...*CMD) (interface{}, error) {
+	if key, ok := cmd.key(); ok {
+		o.keys = append(o.keys, key)
+	}
+	return o.name, nil
+}
+
+func TestShardedRedis(t *testing.T) {
+	shards := map[string]*recordOperator{}
+	operators := map[string]RedisOperator{}
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("shard-%d", i)
+		shards[name] = &recordOperator{name: name}
+		operators[name] = shards[name]
+	}
+
+	s := NewShardedRedis(operators, 0)
+
+	t.Run("distribution", func(t *testing.T) {
+		for i := 0; i < 3000; i++ {
+			_, err := s.Exec(Command("GET", s.Prefix(fmt.Sprint(i))))
+			NewWithT(t).Expect(err).To(BeNil())
+		}
+		for _, shard := range shards {
+			NewWithT(t).Expect(len(shard.keys)).To(BeNumerically(">", 500))
+		}
+	})
+
+	t.Run("stable routing", func(t *testing.T) {
+		name, _ := s.Exec(Command("GET", s.Prefix("key")))
+		again, _ := NewShardedRedis(operators, 0).Exec(Command("GET", s.Prefix("key")))
+		NewWithT(t).Expect(again).To(Equal(name))
+	})
+
+	t.Run("hashtag", func(t *testing.T) {
+		NewWithT(t).Expect(HashTag("test:{user:1}:profile")).To(Equal("user:1"))
+		NewWithT(t).Expect(HashTag("test:{}:profile")).To(Equal("test:{}:profile"))
+
+		name, err := s.Exec(
+			Command("SET", s.Prefix("{user:1}:profile"), "1"),
+			Command("SET", s.Prefix("{user:1}:orders"), "1"),
+		)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(s.Shard(s.Prefix("{user:1}:any"))).To(Equal(operators[name.(string)]))
+	})
+
+	t.Run("cross shard", func(t *testing.T) {
+		cmds := make([]*CMD, 0)
+		for i := 0; i < 20; i++ {
+			cmds = append(cmds, Command("SET", s.Prefix(fmt.Sprint(i)), "1"))
+		}
+		_, err := s.Exec(cmds[0], cmds[1:]...)
+		NewWithT(t).Expect(err).To(Equal(ErrCrossShard))
+	})
+
+	t.Run("eval keys", func(t *testing.T) {
+		key, ok := Command("EVAL", "return 1", 1, "k").key()
+		NewWithT(t).Expect(ok).To(BeTrue())
+		NewWithT(t).Expect(key).To(Equal("k"))
+
+		_, ok = Command("EVAL", "return 1", 0).key()
+		NewWithT(t).Expect(ok).To(BeFalse())
+	})
+
+	t.Run("multi-key commands", func(t *testing.T) {
+		for _, cmd := range []*CMD{
+			Command("DEL", s.Prefix("1"), s.Prefix("2"), s.Prefix("3")),
+			Command("MGET", s.Prefix("1"), s.Prefix("2"), s.Prefix("3")),
+			Command("MSET", s.Prefix("1"), "v", s.Prefix("2"), "v", s.Prefix("3"), "v"),
+			Command("PFMERGE", s.Prefix("1"), s.Prefix("2"), s.Prefix("3")),
+			Command("EVAL", "return 1", 3, s.Prefix("1"), s.Prefix("2"), s.Prefix("3")),
+		} {
+			_, err := s.Exec(cmd)
+			NewWithT(t).Expect(err).To(Equal(ErrCrossShard), cmd.name)
+
+			replies, err := s.Pipeline(context.Background(), cmd)
+			NewWithT(t).Expect(err).To(BeNil())
+			NewWithT(t).Expect(replies[0].Err).To(Equal(ErrCrossShard), cmd.name)
+		}
+
+		_, err := s.Exec(Command("DEL", s.Prefix("{user:1}:profile"), s.Prefix("{user:1}:orders")))
+		NewWithT(t).Expect(err).To(BeNil())
+	})
+
+	t.Run("no shards", func(t *testing.T) {
+		NewWithT(t).Expect(func() {
+			NewShardedRedis(map[string]RedisOperator{}, 0)
+		}).To(PanicWith(ErrNoShards))
+	})
+}
+
+func TestCommandKeys(t *testing.T) {
+	for _, c := range []struct {
+		cmd  *CMD
+		keys []string
+	}{
+		{Command("GET", "k"), []string{"k"}},
+		{Command("SET", "k", "v"), []string{"k"}},
+		{Command("PING"), nil},
+		{Command("DEL", "k1", "k2"), []string{"k1", "k2"}},
+		{Command("MSET", "k1", "v1", "k2", "v2"), []string{"k1", "k2"}},
+		{Command("RPOPLPUSH", "k1", "k2"), []string{"k1", "k2"}},
+		{Command("BLMOVE", "k1", "k2", "LEFT", "RIGHT", 0), []string{"k1", "k2"}},
+		{Command("BLPOP", "k1", "k2", 0), []string{"k1", "k2"}},
+		{Command("PFCOUNT", "k1", "k2"), []string{"k1", "k2"}},
+		{Command("BITOP", "AND", "dest", "k1"), []string{"dest", "k1"}},
+		{Command("EVAL", "return 1", 2, "k1", "k2", "arg"), []string{"k1", "k2"}},
+		{Command("EVALSHA", "sha", 0, "arg"), nil},
+		{Command("ZUNIONSTORE", "dest", 2, "k1", "k2", "WEIGHTS", 1, 2), []string{"dest", "k1", "k2"}},
+		{Command("ZUNION", 2, "k1", "k2"), []string{"k1", "k2"}},
+		{Command("XGROUP", "CREATE", "k", "group", "$", "MKSTREAM"), []string{"k"}},
+		{Command("XGROUP", "CREATECONSUMER", "k", "group", "consumer"), []string{"k"}},
+		{Command("XINFO", "STREAM", "k"), []string{"k"}},
+		{Command("XINFO", "HELP"), nil},
+		{Command("XREAD", "COUNT", 1, "STREAMS", "k1", "k2", "0", "0"), []string{"k1", "k2"}},
+		{Command("XREADGROUP", "GROUP", "group", "consumer", "streams", "k", ">"), []string{"k"}},
+		{Command("XAUTOCLAIM", "k", "group", "consumer", 10, "0"), []string{"k"}},
+	} {
+		NewWithT(t).Expect(c.cmd.keys()).To(Equal(c.keys), fmt.Sprint(c.cmd.name, c.cmd.args))
+	}
+}
+
+func TestShardedRedisServers(t *testing.T) {
+	servers := map[string]*redistest.Server{}
+	operators := map[string]RedisOperator{}
+	for _, name := range []string{"a", "b"} {
+		servers[name] = redistest.NewServer()
+		defer servers[name].Close()
+
+		r := &Redis{Host: servers[name].Host(), Port: servers[name].Port()}
+		r.SetDefaults()
+		r.Init()
+		operators[name] = r
+	}
+
+	s := NewShardedRedis(operators, 0)
+
+	// keys of both shards
+	keys := map[string]string{}
+	for i := 0; len(keys) < 2; i++ {
+		key := s.Prefix(fmt.Sprint("k", i))
+		keys[s.shardName(key)] = key
+	}
+
+	for _, key := range keys {
+		_, err := s.Exec(Command("SET", key, "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+	}
+
+	t.Run("cross shard commands fail without deleting", func(t *testing.T) {
+		_, err := s.Exec(Command("DEL", keys["a"], keys["b"]))
+		NewWithT(t).Expect(err).To(Equal(ErrCrossShard))
+
+		for _, key := range keys {
+			NewWithT(t).Expect(redis.String(s.Exec(Command("GET", key)))).To(Equal("1"))
+		}
+	})
+
+	t.Run("connections of a key", func(t *testing.T) {
+		for name, key := range keys {
+			c, err := GetContextForKey(context.Background(), s, key)
+			NewWithT(t).Expect(err).To(BeNil())
+
+			NewWithT(t).Expect(redis.String(c.Do("GET", key))).To(Equal("1"), name)
+			_ = c.Close()
+		}
+	})
+
+	t.Run("transactions queuing keys of another shard", func(t *testing.T) {
+		for _, watchKeys := range [][]string{{keys["a"]}, nil} {
+			_, err := s.Tx(context.Background(), watchKeys, func(tx *Transaction) error {
+				tx.Queue(Command("SET", keys["a"], "2"), Command("SET", keys["b"], "2"))
+				return nil
+			})
+			NewWithT(t).Expect(err).To(Equal(ErrCrossShard))
+		}
+
+		_, err := s.Tx(context.Background(), nil, func(tx *Transaction) error {
+			tx.Queue(Command("SET", keys["b"], "2"))
+			return nil
+		})
+		NewWithT(t).Expect(err).To(Equal(ErrCrossShard))
+
+		for _, key := range keys {
+			NewWithT(t).Expect(redis.String(s.Exec(Command("GET", key)))).To(Equal("1"))
+		}
+	})
+}
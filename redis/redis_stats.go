@@ -0,0 +1,107 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrNotInitialized is returned by the connections of an operator whose Init wasn't called
+var ErrNotInitialized = errors.New("redis: not initialized, Init must be called first")
+
+// Stats is a snapshot of the connection pool of an operator and of the server it connects to
+type Stats struct {
+	// ActiveCount is the number of connections of the pool, in use or idle
+	ActiveCount int
+	// IdleCount is the number of idle connections of the pool
+	IdleCount int
+	// WaitCount is the number of times a connection was waited for, as the pool was full
+	WaitCount int64
+	// WaitDuration is the total time spent waiting for connections
+	WaitDuration time.Duration
+	// PingLatency is the round trip of a PING
+	PingLatency time.Duration
+	// Version, Role, UsedMemory, MaxMemory and ConnectedClients are the fields of INFO
+	// redis_version, role, used_memory, maxmemory and connected_clients
+	Version          string
+	Role             string
+	UsedMemory       int64
+	MaxMemory        int64
+	ConnectedClients int
+	// Info holds all the fields of INFO
+	Info map[string]string
+	// Err is the error of reaching the server, the stats of the pool are set anyway
+	Err error
+}
+
+// Stats returns the stats of the pool, then pings the server and reads its INFO
+func (r *Redis) Stats() Stats {
+	return poolStats(context.Background(), r.pool)
+}
+
+// Stats returns the stats of the pool of the master, then pings the server and reads its INFO
+func (r *RedisEndpoint) Stats() Stats {
+	return poolStats(context.Background(), r.pool)
+}
+
+func poolStats(ctx context.Context, pool *redis.Pool) Stats {
+	if pool == nil {
+		return Stats{Err: ErrNotInitialized}
+	}
+
+	ps := pool.Stats()
+	s := Stats{
+		ActiveCount:  ps.ActiveCount,
+		IdleCount:    ps.IdleCount,
+		WaitCount:    ps.WaitCount,
+		WaitDuration: ps.WaitDuration,
+	}
+
+	c, err := pool.GetContext(ctx)
+	if err != nil {
+		s.Err = err
+		return s
+	}
+	defer c.Close()
+
+	start := time.Now()
+	if _, err := redis.DoContext(c, ctx, "PING"); err != nil {
+		s.Err = err
+		return s
+	}
+	s.PingLatency = time.Since(start)
+
+	info, err := redis.String(redis.DoContext(c, ctx, "INFO"))
+	if err != nil {
+		s.Err = err
+		return s
+	}
+
+	s.Info = parseInfo(info)
+	s.Version = s.Info["redis_version"]
+	s.Role = s.Info["role"]
+	s.UsedMemory, _ = strconv.ParseInt(s.Info["used_memory"], 10, 64)
+	s.MaxMemory, _ = strconv.ParseInt(s.Info["maxmemory"], 10, 64)
+	s.ConnectedClients, _ = strconv.Atoi(s.Info["connected_clients"])
+
+	return s
+}
+
+// parseInfo parses the field:value lines of INFO, skipping the # Section lines
+func parseInfo(info string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			fields[line[:i]] = line[i+1:]
+		}
+	}
+	return fields
+}
@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-courier/envconf"
+	. "github.com/onsi/gomega"
+)
+
+func TestStats(t *testing.T) {
+	t.Run("Redis", func(t *testing.T) {
+		r := &Redis{Host: server.Host(), Port: server.Port()}
+		r.SetDefaults()
+		r.Init()
+
+		s := r.Stats()
+		NewWithT(t).Expect(s.Err).To(BeNil())
+		NewWithT(t).Expect(s.Version).To(Equal("7.0.0"))
+		NewWithT(t).Expect(s.Role).To(Equal("master"))
+		NewWithT(t).Expect(s.ConnectedClients).To(BeNumerically(">", 0))
+		NewWithT(t).Expect(s.PingLatency).To(BeNumerically(">", 0))
+		NewWithT(t).Expect(s.Info).To(HaveKeyWithValue("redis_mode", "standalone"))
+
+		// the connection of the first call is idle now
+		s = r.Stats()
+		NewWithT(t).Expect(s.ActiveCount).To(Equal(1))
+		NewWithT(t).Expect(s.IdleCount).To(Equal(1))
+	})
+
+	t.Run("RedisEndpoint", func(t *testing.T) {
+		endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("redis://%s", server.Addr()))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		r := &RedisEndpoint{Endpoint: *endpoint}
+		r.Init()
+
+		s := r.Stats()
+		NewWithT(t).Expect(s.Err).To(BeNil())
+		NewWithT(t).Expect(s.Role).To(Equal("master"))
+	})
+
+	t.Run("not initialized", func(t *testing.T) {
+		r := &Redis{Host: server.Host(), Port: server.Port()}
+		r.SetDefaults()
+
+		NewWithT(t).Expect(r.Stats().Err).To(Equal(ErrNotInitialized))
+		NewWithT(t).Expect(r.LivenessCheck()).To(Equal(map[string]string{server.Host(): ErrNotInitialized.Error()}))
+
+		_, err := r.Exec(Command("PING"))
+		NewWithT(t).Expect(err).To(Equal(ErrNotInitialized))
+
+		e := &RedisEndpoint{}
+		NewWithT(t).Expect(e.Stats().Err).To(Equal(ErrNotInitialized))
+		NewWithT(t).Expect(e.LivenessCheck()).To(Equal(map[string]string{"": ErrNotInitialized.Error()}))
+	})
+}
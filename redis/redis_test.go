@@ -5,12 +5,15 @@ import (
 
 	"github.com/gomodule/redigo/redis"
 	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
 )
 
+var server = redistest.NewServer()
+
 func Test(t *testing.T) {
 	r := &Redis{
-		Host: "127.0.0.1",
-		Port: 6379,
+		Host: server.Host(),
+		Port: server.Port(),
 	}
 
 	r.SetDefaults()
@@ -0,0 +1,234 @@
+package redis
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-courier/envconf"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, caKey := newCert(t, nil, nil, "ca")
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.Raw)
+
+	serverCert, serverKey := newCert(t, ca, caKey, "redis.local")
+	clientCert, clientKey := newCert(t, ca, caKey, "client")
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", clientCert.Raw)
+	writeKey(t, filepath.Join(dir, "client-key.pem"), clientKey)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	s := redistest.NewTLSServer(serverConfig)
+	defer s.Close()
+	s.AddUser("app", "secret")
+
+	t.Run("Redis", func(t *testing.T) {
+		r := &Redis{
+			Host:          s.Host(),
+			Port:          s.Port(),
+			Username:      "app",
+			Password:      "secret",
+			TLS:           true,
+			TLSCAFile:     filepath.Join(dir, "ca.pem"),
+			TLSCertFile:   filepath.Join(dir, "client.pem"),
+			TLSKeyFile:    filepath.Join(dir, "client-key.pem"),
+			TLSServerName: "redis.local",
+		}
+		r.SetDefaults()
+		r.Init()
+
+		NewWithT(t).Expect(r.Exec(Command("PING"))).To(Equal("PONG"))
+
+		r = &Redis{Host: s.Host(), Port: s.Port(), Username: "app", Password: "secret", TLS: true, TLSCAFile: filepath.Join(dir, "ca.pem")}
+		r.SetDefaults()
+		r.Init()
+
+		// without the client certificate, nor the name of the server certificate
+		_, err := r.Exec(Command("PING"))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+
+	t.Run("RedisEndpoint", func(t *testing.T) {
+		extra := url.Values{}
+		extra.Set("tlsCAFile", filepath.Join(dir, "ca.pem"))
+		extra.Set("tlsCertFile", filepath.Join(dir, "client.pem"))
+		extra.Set("tlsKeyFile", filepath.Join(dir, "client-key.pem"))
+		extra.Set("tlsServerName", "redis.local")
+
+		endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("rediss://app:secret@%s?%s", s.Addr(), extra.Encode()))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		r := &RedisEndpoint{Endpoint: *endpoint}
+		r.Init()
+		NewWithT(t).Expect(r.Exec(Command("PING"))).To(Equal("PONG"))
+
+		extra.Del("tlsServerName")
+		extra.Set("tlsSkipVerify", "true")
+		extra.Set("username", "app")
+
+		endpoint, err = envconf.ParseEndpoint(fmt.Sprintf("rediss://:secret@%s?%s", s.Addr(), extra.Encode()))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		r = &RedisEndpoint{Endpoint: *endpoint}
+		r.Init()
+		NewWithT(t).Expect(r.Exec(Command("PING"))).To(Equal("PONG"))
+
+		endpoint, err = envconf.ParseEndpoint(fmt.Sprintf("rediss://app:wrong@%s?%s", s.Addr(), extra.Encode()))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		r = &RedisEndpoint{Endpoint: *endpoint}
+		r.Init()
+		_, err = r.Exec(Command("PING"))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		// a bad TLS config fails the dials, as for Redis, rather than Init
+		extra.Set("tlsCAFile", filepath.Join(dir, "missing.pem"))
+		endpoint, err = envconf.ParseEndpoint(fmt.Sprintf("rediss://app:secret@%s?%s", s.Addr(), extra.Encode()))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		r = &RedisEndpoint{Endpoint: *endpoint}
+		NewWithT(t).Expect(r.Init).NotTo(Panic())
+		_, err = r.Exec(Command("PING"))
+		NewWithT(t).Expect(err).To(MatchError(ContainSubstring("missing.pem")))
+	})
+
+	t.Run("sentinel", func(t *testing.T) {
+		sentinel := redistest.NewTLSServer(serverConfig)
+		defer sentinel.Close()
+		sentinel.SetSentinelMaster("mymaster", s.Addr())
+
+		extra := url.Values{}
+		extra.Set("tlsCAFile", filepath.Join(dir, "ca.pem"))
+		extra.Set("tlsCertFile", filepath.Join(dir, "client.pem"))
+		extra.Set("tlsKeyFile", filepath.Join(dir, "client-key.pem"))
+		extra.Set("tlsServerName", "redis.local")
+		extra.Set("masterName", "mymaster")
+
+		endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("rediss://app:secret@%s?%s", sentinel.Addr(), extra.Encode()))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		r := &RedisEndpoint{Endpoint: *endpoint}
+		r.Init()
+		NewWithT(t).Expect(r.Exec(Command("PING"))).To(Equal("PONG"))
+		NewWithT(t).Expect(r.sentinel.current()).To(Equal(s.Addr()))
+	})
+
+	t.Run("ClusterRedis", func(t *testing.T) {
+		s.SetClusterSlots(redistest.SlotRange{Start: 0, End: 16383, Addr: s.Addr()})
+		defer s.SetClusterSlots()
+
+		c := &ClusterRedis{
+			Nodes:         []string{s.Addr()},
+			Username:      "app",
+			Password:      "secret",
+			TLS:           true,
+			TLSCAFile:     filepath.Join(dir, "ca.pem"),
+			TLSCertFile:   filepath.Join(dir, "client.pem"),
+			TLSKeyFile:    filepath.Join(dir, "client-key.pem"),
+			TLSServerName: "redis.local",
+		}
+		c.SetDefaults()
+		c.Init()
+
+		_, err := c.Exec(Command("SET", c.Prefix("{tls}"), "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		c = &ClusterRedis{Nodes: []string{s.Addr()}, Username: "app", Password: "secret", TLS: true, TLSCAFile: filepath.Join(dir, "ca.pem")}
+		c.SetDefaults()
+		c.Init()
+
+		_, err = c.Exec(Command("SET", c.Prefix("{tls}"), "1"))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+	})
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	s := redistest.NewUnixServer(path)
+	defer s.Close()
+
+	r := &Redis{Protocol: "unix", Host: path}
+	r.SetDefaults()
+	r.Init()
+
+	_, err := r.Exec(Command("SET", r.Prefix("unix"), "1"))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	endpoint, err := envconf.ParseEndpoint("redis://localhost?socket=" + url.QueryEscape(path))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	e := &RedisEndpoint{Endpoint: *endpoint}
+	e.Init()
+
+	v, err := redis.String(e.Exec(Command("GET", e.Prefix("unix"))))
+	NewWithT(t).Expect(err).To(BeNil())
+	NewWithT(t).Expect(v).To(Equal("1"))
+}
+
+// newCert creates a certificate for name signed by parent, self-signed CA without parent
+func newCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NewWithT(t).Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.IPAddresses = nil
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	NewWithT(t).Expect(err).To(BeNil())
+
+	cert, err := x509.ParseCertificate(der)
+	NewWithT(t).Expect(err).To(BeNil())
+	return cert, key
+}
+
+func writePEM(t *testing.T, path string, typ string, der []byte) {
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600)
+	NewWithT(t).Expect(err).To(BeNil())
+}
+
+func writeKey(t *testing.T, path string, key *ecdsa.PrivateKey) {
+	der, err := x509.MarshalECPrivateKey(key)
+	NewWithT(t).Expect(err).To(BeNil())
+	writePEM(t, path, "EC PRIVATE KEY", der)
+}
@@ -0,0 +1,177 @@
+package redis
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrTxConflict is returned by Tx when the watched keys kept changing until the attempts ran out
+var ErrTxConflict = errors.New("redis: watched keys changed by other clients, transaction attempts exhausted")
+
+// errTxAborted is the nil reply of EXEC, the watched keys changed
+var errTxAborted = errors.New("redis: transaction aborted")
+
+const defaultTxMaxAttempts = 10
+
+// Transaction is passed to the callback of Tx, it reads with Do and queues the writes run by EXEC with Queue
+type Transaction struct {
+	ctx    context.Context
+	c      Conn
+	hooks  []Hook
+	queued []*CMD
+}
+
+// Do runs cmd right away on the connection of the transaction, to read the watched keys
+func (tx *Transaction) Do(cmd *CMD) (interface{}, error) {
+	return execWithHooks(tx.ctx, tx.hooks, cmd, nil, func(ctx context.Context) (interface{}, error) {
+		return redis.DoContext(tx.c, ctx, cmd.name, cmd.args...)
+	})
+}
+
+// Queue adds cmds to the commands run atomically by EXEC once the callback returns
+func (tx *Transaction) Queue(cmds ...*CMD) {
+	for _, cmd := range cmds {
+		if cmd != nil {
+			tx.queued = append(tx.queued, cmd)
+		}
+	}
+}
+
+// Transactor is implemented by the operators which run transactions themselves, see Tx
+type Transactor interface {
+	Tx(ctx context.Context, watchKeys []string, fn func(tx *Transaction) error) ([]interface{}, error)
+}
+
+var (
+	_ Transactor = (*Redis)(nil)
+	_ Transactor = (*RedisEndpoint)(nil)
+	_ Transactor = (*ShardedRedis)(nil)
+	_ Transactor = (*CircuitBreaker)(nil)
+)
+
+// Tx runs a check-and-set transaction: it WATCHes watchKeys on a pinned connection, calls fn to read them and queue writes,
+// then runs the queued commands with MULTI/EXEC and returns their replies.
+// When a watched key changed before EXEC, nothing is written and fn is called again, up to a limit after which ErrTxConflict is returned,
+// so fn must not have side effects besides the transaction.
+// An error of fn discards the transaction and is returned as it is, without queued commands EXEC is skipped.
+// watchKeys are prefixed already, as the keys of Command.
+// Operators which aren't Transactors run it on a connection of GetContext.
+func Tx(ctx context.Context, op RedisOperator, watchKeys []string, fn func(tx *Transaction) error) ([]interface{}, error) {
+	if t, ok := op.(Transactor); ok {
+		return t.Tx(ctx, watchKeys, fn)
+	}
+	return tx(ctx, op.GetContext, nil, watchKeys, fn, defaultTxMaxAttempts)
+}
+
+// tx runs the reads of fn and the EXEC of each attempt between hooks
+func tx(ctx context.Context, get func(ctx context.Context) (Conn, error), hooks []Hook, watchKeys []string, fn func(tx *Transaction) error, maxAttempts int) ([]interface{}, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultTxMaxAttempts
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		replies, err := txAttempt(ctx, get, hooks, watchKeys, fn)
+		if err != errTxAborted {
+			return replies, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrTxConflict
+}
+
+// txAttempt returns errTxAborted when a watched key changed
+func txAttempt(ctx context.Context, get func(ctx context.Context) (Conn, error), hooks []Hook, watchKeys []string, fn func(tx *Transaction) error) ([]interface{}, error) {
+	c, err := get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the pool unwatches and discards for connections closed in a transaction
+	defer c.Close()
+
+	if len(watchKeys) > 0 {
+		keys := make([]interface{}, len(watchKeys))
+		for i := range watchKeys {
+			keys[i] = watchKeys[i]
+		}
+		if _, err := redis.DoContext(c, ctx, "WATCH", keys...); err != nil {
+			return nil, err
+		}
+	}
+
+	t := &Transaction{ctx: ctx, c: c, hooks: hooks}
+	if err := fn(t); err != nil {
+		return nil, err
+	}
+
+	if len(t.queued) == 0 {
+		_, err := redis.DoContext(c, ctx, "UNWATCH")
+		return []interface{}{}, err
+	}
+
+	return redis.Values(execWithHooks(ctx, hooks, t.queued[0], t.queued[1:], func(ctx context.Context) (interface{}, error) {
+		if err := c.Send("MULTI"); err != nil {
+			return nil, err
+		}
+		for _, cmd := range t.queued {
+			if err := c.Send(cmd.name, cmd.args...); err != nil {
+				return nil, err
+			}
+		}
+
+		reply, err := redis.DoContext(c, ctx, "EXEC")
+		if err == nil && reply == nil {
+			return nil, errTxAborted
+		}
+		return reply, err
+	}))
+}
+
+func (r *Redis) Tx(ctx context.Context, watchKeys []string, fn func(tx *Transaction) error) ([]interface{}, error) {
+	return tx(ctx, r.GetContext, r.Hooks, watchKeys, fn, r.TxMaxAttempts)
+}
+
+func (r *RedisEndpoint) Tx(ctx context.Context, watchKeys []string, fn func(tx *Transaction) error) ([]interface{}, error) {
+	return tx(ctx, r.GetContext, r.Hooks, watchKeys, fn, r.txMaxAttempts)
+}
+
+// Tx runs the transaction on the shard of watchKeys, which must share a shard, on the first shard without watchKeys.
+// The queued commands must belong to the same shard, otherwise nothing runs and ErrCrossShard is returned.
+func (s *ShardedRedis) Tx(ctx context.Context, watchKeys []string, fn func(tx *Transaction) error) ([]interface{}, error) {
+	name := s.names[0]
+	for i, key := range watchKeys {
+		n := s.shardName(key)
+		if i > 0 && n != name {
+			return nil, ErrCrossShard
+		}
+		name = n
+	}
+	return Tx(ctx, s.shards[name], watchKeys, func(tx *Transaction) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if n, err := s.keysShard(tx.queued); err != nil || n != "" && n != name {
+			return ErrCrossShard
+		}
+		return nil
+	})
+}
+
+// Tx counts a transaction as one request, conflicts and errors of fn aren't failures
+func (b *CircuitBreaker) Tx(ctx context.Context, watchKeys []string, fn func(tx *Transaction) error) ([]interface{}, error) {
+	trial, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	var fnErr error
+	replies, err := Tx(ctx, b.op, watchKeys, func(tx *Transaction) error {
+		fnErr = fn(tx)
+		return fnErr
+	})
+	b.done(trial, err != fnErr && err != ErrTxConflict && b.opt.IsFailure(err))
+	return replies, err
+}
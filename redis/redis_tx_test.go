@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+)
+
+func TestTx(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+
+	r := &Redis{
+		Host:      server.Host(),
+		Port:      server.Port(),
+		MaxActive: 20,
+	}
+	r.SetDefaults()
+	r.Init()
+
+	key := r.Prefix("counter")
+
+	incr := func(tx *Transaction) error {
+		n, err := redis.Int(tx.Do(Command("GET", key)))
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+		tx.Queue(Command("SET", key, n+1))
+		return nil
+	}
+
+	t.Run("check and set", func(t *testing.T) {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := Tx(ctx, r, []string{key}, incr)
+				NewWithT(t).Expect(err).To(BeNil())
+			}()
+		}
+		wg.Wait()
+
+		NewWithT(t).Expect(redis.Int(r.Exec(Command("GET", key)))).To(Equal(10))
+	})
+
+	t.Run("conflicts until attempts run out", func(t *testing.T) {
+		attempts := 0
+		_, err := r.Tx(ctx, []string{key}, func(tx *Transaction) error {
+			attempts++
+			// another client changes the watched key
+			if _, err := r.Exec(Command("INCR", key)); err != nil {
+				return err
+			}
+			return incr(tx)
+		})
+		NewWithT(t).Expect(err).To(Equal(ErrTxConflict))
+		NewWithT(t).Expect(attempts).To(Equal(r.TxMaxAttempts))
+		NewWithT(t).Expect(redis.Int(r.Exec(Command("GET", key)))).To(Equal(10 + r.TxMaxAttempts))
+	})
+
+	t.Run("error of fn discards", func(t *testing.T) {
+		boom := errors.New("boom")
+		_, err := r.Tx(ctx, []string{key}, func(tx *Transaction) error {
+			tx.Queue(Command("DEL", key))
+			return boom
+		})
+		NewWithT(t).Expect(err).To(Equal(boom))
+		NewWithT(t).Expect(redis.Int(r.Exec(Command("EXISTS", key)))).To(Equal(1))
+	})
+
+	t.Run("replies", func(t *testing.T) {
+		replies, err := r.Tx(ctx, []string{key}, func(tx *Transaction) error {
+			tx.Queue(Command("INCR", key), nil, Command("GET", key))
+			return nil
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(replies).To(HaveLen(2))
+		NewWithT(t).Expect(redis.Int(replies[1], nil)).To(Equal(11 + r.TxMaxAttempts))
+
+		replies, err = r.Tx(ctx, []string{key}, func(tx *Transaction) error {
+			return nil
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(replies).To(BeEmpty())
+	})
+
+	t.Run("cross shard", func(t *testing.T) {
+		s := NewShardedRedis(map[string]RedisOperator{"a": &recordOperator{name: "a"}, "b": &recordOperator{name: "b"}}, 0)
+		keys := []string{}
+		for i := 0; i < 20; i++ {
+			keys = append(keys, s.Prefix(string(rune('a'+i))))
+		}
+		_, err := s.Tx(ctx, keys, incr)
+		NewWithT(t).Expect(err).To(Equal(ErrCrossShard))
+	})
+}
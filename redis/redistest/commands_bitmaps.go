@@ -0,0 +1,95 @@
+package redistest
+
+import (
+	"math/bits"
+)
+
+var (
+	errBitOffset = errorReply("ERR bit offset is not an integer or out of range")
+	errBitValue  = errorReply("ERR bit is not an integer or out of range")
+)
+
+// parseBitOffset parses an offset of SETBIT and GETBIT, which Redis limits to 512MB strings
+func parseBitOffset(b []byte) (int64, bool) {
+	offset, ok := parseInt(b)
+	return offset, ok && offset >= 0 && offset < 1<<32
+}
+
+func init() {
+	register("SETBIT", 3, 3, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		offset, ok := parseBitOffset(args[1])
+		if !ok {
+			return errBitOffset
+		}
+		bit := string(args[2])
+		if bit != "0" && bit != "1" {
+			return errBitValue
+		}
+
+		old, errReply := c.d().getString(c.s, key)
+		if errReply != nil {
+			return errReply
+		}
+
+		i, mask := offset/8, byte(0x80>>uint(offset%8))
+		v := append([]byte{}, old...)
+		if int64(len(v)) <= i {
+			v = append(v, make([]byte, i+1-int64(len(v)))...)
+		}
+
+		previous := 0
+		if v[i]&mask != 0 {
+			previous = 1
+		}
+		if bit == "1" {
+			v[i] |= mask
+		} else {
+			v[i] &^= mask
+		}
+
+		c.setKeepTTL(key, v)
+		return previous
+	})
+
+	register("GETBIT", 2, 2, func(c *client, args [][]byte) interface{} {
+		offset, ok := parseBitOffset(args[1])
+		if !ok {
+			return errBitOffset
+		}
+		v, errReply := c.d().getString(c.s, string(args[0]))
+		if errReply != nil {
+			return errReply
+		}
+		i := offset / 8
+		if i >= int64(len(v)) || v[i]&(0x80>>uint(offset%8)) == 0 {
+			return 0
+		}
+		return 1
+	})
+
+	// BITCOUNT key [start end], start and end are byte indexes
+	register("BITCOUNT", 1, 3, func(c *client, args [][]byte) interface{} {
+		if len(args) == 2 {
+			return errSyntax
+		}
+		v, errReply := c.d().getString(c.s, string(args[0]))
+		if errReply != nil {
+			return errReply
+		}
+		if len(args) == 3 {
+			start, ok1 := parseInt(args[1])
+			end, ok2 := parseInt(args[2])
+			if !ok1 || !ok2 {
+				return errNotInteger
+			}
+			i, j := normalizeRange(start, end, len(v))
+			v = v[i:j]
+		}
+		n := 0
+		for _, b := range v {
+			n += bits.OnesCount8(b)
+		}
+		return n
+	})
+}
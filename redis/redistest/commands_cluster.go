@@ -0,0 +1,154 @@
+package redistest
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SlotRange assigns the hash slots from Start to End, both included, to the node at Addr
+type SlotRange struct {
+	Start int
+	End   int
+	Addr  string
+}
+
+// SetClusterSlots makes the server a node of a cluster whose slots are assigned by ranges, which CLUSTER SLOTS replies.
+// Commands whose key belongs to another node are answered with MOVED.
+func (s *Server) SetClusterSlots(ranges ...SlotRange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clusterSlots = ranges
+}
+
+// SetMigrating marks the slot as migrating to the node at addr: commands whose key of the slot doesn't exist here are answered with ASK.
+// An empty addr ends the migration.
+func (s *Server) SetMigrating(slot int, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.migrating == nil {
+		s.migrating = map[int]string{}
+	}
+	if addr == "" {
+		delete(s.migrating, slot)
+		return
+	}
+	s.migrating[slot] = addr
+}
+
+// keylessCommands don't take a key as first argument
+var keylessCommands = map[string]bool{
+	"PING": true, "ECHO": true, "AUTH": true, "HELLO": true, "SELECT": true, "QUIT": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true, "UNWATCH": true,
+	"INFO": true, "ROLE": true, "SENTINEL": true, "CLUSTER": true, "ASKING": true, "TIME": true, "SCRIPT": true,
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PSUBSCRIBE": true, "PUNSUBSCRIBE": true, "PUBLISH": true,
+	"DBSIZE": true, "FLUSHDB": true, "FLUSHALL": true, "KEYS": true, "SCAN": true, "RANDOMKEY": true,
+}
+
+// commandKey returns the first key of the command
+func commandKey(name string, args [][]byte) (string, bool) {
+	if keylessCommands[name] {
+		return "", false
+	}
+	i := 0
+	if name == "EVAL" || name == "EVALSHA" {
+		if len(args) < 3 || string(args[1]) == "0" {
+			return "", false
+		}
+		i = 2
+	}
+	if len(args) <= i {
+		return "", false
+	}
+	return string(args[i]), true
+}
+
+// keySlot returns the hash slot of key, hashing only its {hashtag} when it has one
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % 16384
+}
+
+// redirect returns the MOVED or ASK error of a command the node doesn't serve, nil when it serves it, s.mu must be held
+func (c *client) redirect(name string, args [][]byte, asking bool) interface{} {
+	if c.s.clusterSlots == nil {
+		return nil
+	}
+	key, ok := commandKey(name, args)
+	if !ok {
+		return nil
+	}
+
+	slot := keySlot(key)
+	owner := ""
+	for _, r := range c.s.clusterSlots {
+		if slot >= r.Start && slot <= r.End {
+			owner = r.Addr
+			break
+		}
+	}
+
+	if owner == "" {
+		return errorReply("CLUSTERDOWN Hash slot not served")
+	}
+	if owner != c.s.Addr() {
+		if asking {
+			return nil
+		}
+		return errorf("MOVED %d %s", slot, owner)
+	}
+	if addr, ok := c.s.migrating[slot]; ok && c.d().get(c.s, key) == nil {
+		return errorf("ASK %d %s", slot, addr)
+	}
+	return nil
+}
+
+func init() {
+	// ASKING lets the next command, or the next transaction, run on a slot being imported
+	register("ASKING", 0, 0, func(c *client, args [][]byte) interface{} {
+		if c.s.clusterSlots == nil {
+			return errorReply("ERR This instance has cluster support disabled")
+		}
+		c.asking = true
+		return okReply
+	})
+
+	// CLUSTER SLOTS | KEYSLOT key
+	register("CLUSTER", 1, 2, func(c *client, args [][]byte) interface{} {
+		if c.s.clusterSlots == nil {
+			return errorReply("ERR This instance has cluster support disabled")
+		}
+
+		switch strings.ToUpper(string(args[0])) {
+		case "SLOTS":
+			slots := make([]interface{}, 0, len(c.s.clusterSlots))
+			for _, r := range c.s.clusterSlots {
+				host, port := splitAddr(r.Addr)
+				p, _ := strconv.Atoi(port)
+				slots = append(slots, []interface{}{r.Start, r.End, []interface{}{host, p, r.Addr}})
+			}
+			return slots
+		case "KEYSLOT":
+			if len(args) != 2 {
+				return errWrongArgs("CLUSTER|KEYSLOT")
+			}
+			return keySlot(string(args[1]))
+		}
+		return errorf("ERR Unknown subcommand or wrong number of arguments for '%s'", string(args[0]))
+	})
+}
@@ -0,0 +1,177 @@
+package redistest
+
+import (
+	"sort"
+	"strconv"
+)
+
+func init() {
+	hset := func(c *client, args [][]byte) interface{} {
+		if len(args)%2 != 1 {
+			return errWrongArgs("HSET")
+		}
+		key := string(args[0])
+		h, errReply := c.d().getHash(c.s, key, true)
+		if errReply != nil {
+			return errReply
+		}
+		n := 0
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := h[string(args[i])]; !ok {
+				n++
+			}
+			h[string(args[i])] = args[i+1]
+		}
+		c.s.touch(c.d(), key)
+		return n
+	}
+	register("HSET", 3, -1, hset)
+	register("HMSET", 3, -1, func(c *client, args [][]byte) interface{} {
+		if reply, isErr := hset(c, args).(errorReply); isErr {
+			return reply
+		}
+		return okReply
+	})
+
+	register("HSETNX", 3, 3, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		h, errReply := c.d().getHash(c.s, key, true)
+		if errReply != nil {
+			return errReply
+		}
+		if _, ok := h[string(args[1])]; ok {
+			return 0
+		}
+		h[string(args[1])] = args[2]
+		c.s.touch(c.d(), key)
+		return 1
+	})
+
+	register("HGET", 2, 2, func(c *client, args [][]byte) interface{} {
+		h, errReply := c.d().getHash(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		if v, ok := h[string(args[1])]; ok {
+			return v
+		}
+		return nil
+	})
+
+	register("HMGET", 2, -1, func(c *client, args [][]byte) interface{} {
+		h, errReply := c.d().getHash(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		values := make([]interface{}, len(args)-1)
+		for i, field := range args[1:] {
+			if v, ok := h[string(field)]; ok {
+				values[i] = v
+			}
+		}
+		return values
+	})
+
+	register("HGETALL", 1, 1, func(c *client, args [][]byte) interface{} {
+		h, errReply := c.d().getHash(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		values := make([]interface{}, 0, len(h)*2)
+		for _, field := range sortedFields(h) {
+			values = append(values, field, h[field])
+		}
+		return values
+	})
+
+	register("HKEYS", 1, 1, func(c *client, args [][]byte) interface{} {
+		h, errReply := c.d().getHash(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		return sortedFields(h)
+	})
+
+	register("HVALS", 1, 1, func(c *client, args [][]byte) interface{} {
+		h, errReply := c.d().getHash(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		values := make([]interface{}, 0, len(h))
+		for _, field := range sortedFields(h) {
+			values = append(values, h[field])
+		}
+		return values
+	})
+
+	register("HLEN", 1, 1, func(c *client, args [][]byte) interface{} {
+		h, errReply := c.d().getHash(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		return len(h)
+	})
+
+	register("HEXISTS", 2, 2, func(c *client, args [][]byte) interface{} {
+		h, errReply := c.d().getHash(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		if _, ok := h[string(args[1])]; ok {
+			return 1
+		}
+		return 0
+	})
+
+	register("HDEL", 2, -1, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		d := c.d()
+		h, errReply := d.getHash(c.s, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		n := 0
+		for _, field := range args[1:] {
+			if _, ok := h[string(field)]; ok {
+				delete(h, string(field))
+				n++
+			}
+		}
+		if n > 0 {
+			c.s.touch(d, key)
+			d.removeIfEmpty(c.s, key)
+		}
+		return n
+	})
+
+	register("HINCRBY", 3, 3, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		by, ok := parseInt(args[2])
+		if !ok {
+			return errNotInteger
+		}
+		h, errReply := c.d().getHash(c.s, key, true)
+		if errReply != nil {
+			return errReply
+		}
+		n := int64(0)
+		if v, exists := h[string(args[1])]; exists {
+			if n, ok = parseInt(v); !ok {
+				return errorReply("ERR hash value is not an integer")
+			}
+		}
+		n += by
+		h[string(args[1])] = []byte(strconv.FormatInt(n, 10))
+		c.s.touch(c.d(), key)
+		return n
+	})
+}
+
+func sortedFields(h hash) []string {
+	fields := make([]string, 0, len(h))
+	for field := range h {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,120 @@
+package redistest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// hyperloglogs are strings, as in Redis, holding the exact set of elements instead of registers,
+// so PFCOUNT is exact here
+
+var hllMagic = []byte("HYLL")
+
+var errNotHLL = errorReply("WRONGTYPE Key is not a valid HyperLogLog string value.")
+
+func decodeHLL(v []byte) (map[string]bool, bool) {
+	if !bytes.HasPrefix(v, hllMagic) {
+		return nil, false
+	}
+	v = v[len(hllMagic):]
+
+	elements := map[string]bool{}
+	for len(v) > 0 {
+		n, size := binary.Uvarint(v)
+		if size <= 0 || uint64(len(v)-size) < n {
+			return nil, false
+		}
+		elements[string(v[size:size+int(n)])] = true
+		v = v[size+int(n):]
+	}
+	return elements, true
+}
+
+func encodeHLL(elements map[string]bool) []byte {
+	sorted := make([]string, 0, len(elements))
+	for e := range elements {
+		sorted = append(sorted, e)
+	}
+	sort.Strings(sorted)
+
+	v := append([]byte{}, hllMagic...)
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, e := range sorted {
+		v = append(v, buf[:binary.PutUvarint(buf, uint64(len(e)))]...)
+		v = append(v, e...)
+	}
+	return v
+}
+
+// getHLL returns the elements of the hyperloglog key, nil when it doesn't exist
+func (c *client) getHLL(key string) (map[string]bool, interface{}) {
+	v, errReply := c.d().getString(c.s, key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if v == nil {
+		return nil, nil
+	}
+	elements, ok := decodeHLL(v)
+	if !ok {
+		return nil, errNotHLL
+	}
+	return elements, nil
+}
+
+func init() {
+	register("PFADD", 1, -1, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		elements, errReply := c.getHLL(key)
+		if errReply != nil {
+			return errReply
+		}
+
+		changed := elements == nil
+		if elements == nil {
+			elements = map[string]bool{}
+		}
+		for _, e := range args[1:] {
+			if !elements[string(e)] {
+				elements[string(e)] = true
+				changed = true
+			}
+		}
+
+		if !changed {
+			return 0
+		}
+		c.setKeepTTL(key, encodeHLL(elements))
+		return 1
+	})
+
+	register("PFCOUNT", 1, -1, func(c *client, args [][]byte) interface{} {
+		union := map[string]bool{}
+		for _, key := range args {
+			elements, errReply := c.getHLL(string(key))
+			if errReply != nil {
+				return errReply
+			}
+			for e := range elements {
+				union[e] = true
+			}
+		}
+		return len(union)
+	})
+
+	register("PFMERGE", 1, -1, func(c *client, args [][]byte) interface{} {
+		union := map[string]bool{}
+		for _, key := range args {
+			elements, errReply := c.getHLL(string(key))
+			if errReply != nil {
+				return errReply
+			}
+			for e := range elements {
+				union[e] = true
+			}
+		}
+		c.setKeepTTL(string(args[0]), encodeHLL(union))
+		return okReply
+	})
+}
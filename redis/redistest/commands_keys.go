@@ -0,0 +1,278 @@
+package redistest
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	register("DEL", 1, -1, func(c *client, args [][]byte) interface{} {
+		n := 0
+		for _, key := range args {
+			if c.d().del(c.s, string(key)) {
+				n++
+			}
+		}
+		return n
+	})
+	commands["UNLINK"] = commands["DEL"]
+
+	register("EXISTS", 1, -1, func(c *client, args [][]byte) interface{} {
+		n := 0
+		for _, key := range args {
+			if c.d().get(c.s, string(key)) != nil {
+				n++
+			}
+		}
+		return n
+	})
+
+	register("TYPE", 1, 1, func(c *client, args [][]byte) interface{} {
+		i := c.d().get(c.s, string(args[0]))
+		if i == nil {
+			return simpleString("none")
+		}
+		return simpleString(i.typeName())
+	})
+
+	expire := func(unit time.Duration, at bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			n, ok := parseInt(args[1])
+			if !ok {
+				return errNotInteger
+			}
+
+			d := c.d()
+			key := string(args[0])
+			i := d.get(c.s, key)
+			if i == nil {
+				return 0
+			}
+
+			expireAt := c.s.now().Add(time.Duration(n) * unit)
+			if at {
+				expireAt = time.Unix(0, n*int64(unit))
+			}
+
+			i.expireAt = expireAt
+			c.s.touch(d, key)
+			d.expire(c.s, key)
+			return 1
+		}
+	}
+	register("EXPIRE", 2, 3, expire(time.Second, false))
+	register("PEXPIRE", 2, 3, expire(time.Millisecond, false))
+	register("EXPIREAT", 2, 3, expire(time.Second, true))
+	register("PEXPIREAT", 2, 3, expire(time.Millisecond, true))
+
+	ttl := func(unit time.Duration) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			i := c.d().get(c.s, string(args[0]))
+			if i == nil {
+				return -2
+			}
+			if i.expireAt.IsZero() {
+				return -1
+			}
+			left := i.expireAt.Sub(c.s.now())
+			// round up as Redis does, so a key with a TTL never reports 0 before it is gone
+			return int64((left + unit - 1) / unit)
+		}
+	}
+	register("TTL", 1, 1, ttl(time.Second))
+	register("PTTL", 1, 1, ttl(time.Millisecond))
+
+	register("PERSIST", 1, 1, func(c *client, args [][]byte) interface{} {
+		d := c.d()
+		i := d.get(c.s, string(args[0]))
+		if i == nil || i.expireAt.IsZero() {
+			return 0
+		}
+		i.expireAt = time.Time{}
+		c.s.touch(d, string(args[0]))
+		return 1
+	})
+
+	rename := func(nx bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			d := c.d()
+			from, to := string(args[0]), string(args[1])
+			i := d.get(c.s, from)
+			if i == nil {
+				return errNoSuchKey
+			}
+			if nx && d.get(c.s, to) != nil {
+				return 0
+			}
+			delete(d.items, from)
+			c.s.touch(d, from)
+			d.items[to] = i
+			c.s.touch(d, to)
+			if nx {
+				return 1
+			}
+			return okReply
+		}
+	}
+	register("RENAME", 2, 2, rename(false))
+	register("RENAMENX", 2, 2, rename(true))
+
+	register("KEYS", 1, 1, func(c *client, args [][]byte) interface{} {
+		keys := make([]string, 0)
+		for _, key := range c.d().keys(c.s) {
+			if globMatch(string(args[0]), key) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	})
+
+	register("SCAN", 1, -1, func(c *client, args [][]byte) interface{} {
+		cursor, ok := parseInt(args[0])
+		if !ok || cursor < 0 {
+			return errorReply("ERR invalid cursor")
+		}
+
+		match, typeName, count := "*", "", 10
+		for i := 1; i < len(args); i += 2 {
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			switch strings.ToUpper(string(args[i])) {
+			case "MATCH":
+				match = string(args[i+1])
+			case "TYPE":
+				typeName = strings.ToLower(string(args[i+1]))
+			case "COUNT":
+				n, ok := parseInt(args[i+1])
+				if !ok || n < 1 {
+					return errSyntax
+				}
+				count = int(n)
+			default:
+				return errSyntax
+			}
+		}
+
+		d := c.d()
+		all := d.keys(c.s)
+
+		// the cursor is an index into the sorted keys, keys added while scanning may be missed as in Redis
+		keys := make([]string, 0)
+		next := int(cursor)
+		for ; next < len(all) && next < int(cursor)+count; next++ {
+			key := all[next]
+			if !globMatch(match, key) {
+				continue
+			}
+			if typeName != "" && d.items[key].typeName() != typeName {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if next >= len(all) {
+			next = 0
+		}
+
+		return []interface{}{strconv.Itoa(next), keys}
+	})
+
+	register("RANDOMKEY", 0, 0, func(c *client, args [][]byte) interface{} {
+		keys := c.d().keys(c.s)
+		if len(keys) == 0 {
+			return nil
+		}
+		return keys[0]
+	})
+
+	register("DBSIZE", 0, 0, func(c *client, args [][]byte) interface{} {
+		return len(c.d().keys(c.s))
+	})
+
+	register("FLUSHDB", 0, 1, func(c *client, args [][]byte) interface{} {
+		c.d().flush(c.s)
+		return okReply
+	})
+
+	register("FLUSHALL", 0, 1, func(c *client, args [][]byte) interface{} {
+		for _, d := range c.s.dbs {
+			d.flush(c.s)
+		}
+		return okReply
+	})
+}
+
+// globMatch matches str with a Redis glob pattern, supporting *, ?, [abc], [^a-z] and \ escapes
+func globMatch(pattern string, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if globMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				if str[0] != '[' {
+					return false
+				}
+				str, pattern = str[1:], pattern[1:]
+				continue
+			}
+			class := pattern[1 : 1+end]
+			not := strings.HasPrefix(class, "^")
+			if not {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if class[i] <= str[0] && str[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+					continue
+				}
+				if class[i] == str[0] {
+					matched = true
+				}
+			}
+			if matched == not {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[2+end:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || str[0] != pattern[0] {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(str) == 0
+}
@@ -0,0 +1,368 @@
+package redistest
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// normalizeRange turns Redis start/stop indexes, which may be negative, into a slice range of a sequence of n
+func normalizeRange(start int64, stop int64, n int) (int, int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+func parseBlockingTimeout(args [][]byte) (time.Duration, interface{}) {
+	f, ok := parseFloat(args[len(args)-1])
+	if !ok || f < 0 {
+		return 0, errTimeout
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+func (l *list) pop(left bool) []byte {
+	if len(l.values) == 0 {
+		return nil
+	}
+	if left {
+		v := l.values[0]
+		l.values = l.values[1:]
+		return v
+	}
+	v := l.values[len(l.values)-1]
+	l.values = l.values[:len(l.values)-1]
+	return v
+}
+
+func (l *list) push(left bool, v []byte) {
+	if left {
+		l.values = append([][]byte{v}, l.values...)
+		return
+	}
+	l.values = append(l.values, v)
+}
+
+func parseSide(b []byte) (bool, bool) {
+	switch strings.ToUpper(string(b)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+func init() {
+	push := func(left bool, onlyExisting bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			key := string(args[0])
+			d := c.d()
+			l, errReply := d.getList(c.s, key, !onlyExisting)
+			if errReply != nil {
+				return errReply
+			}
+			if l == nil {
+				return 0
+			}
+			for _, v := range args[1:] {
+				l.push(left, v)
+			}
+			c.s.touch(d, key)
+			return len(l.values)
+		}
+	}
+	register("LPUSH", 2, -1, push(true, false))
+	register("RPUSH", 2, -1, push(false, false))
+	register("LPUSHX", 2, -1, push(true, true))
+	register("RPUSHX", 2, -1, push(false, true))
+
+	pop := func(left bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			key := string(args[0])
+			d := c.d()
+			l, errReply := d.getList(c.s, key, false)
+			if errReply != nil {
+				return errReply
+			}
+
+			if len(args) == 1 {
+				if l == nil {
+					return nil
+				}
+				v := l.pop(left)
+				c.s.touch(d, key)
+				d.removeIfEmpty(c.s, key)
+				return v
+			}
+
+			count, ok := parseInt(args[1])
+			if !ok || count < 0 {
+				return errorReply("ERR value is out of range, must be positive")
+			}
+			if l == nil {
+				return nullArray{}
+			}
+			values := make([][]byte, 0)
+			for i := int64(0); i < count && len(l.values) > 0; i++ {
+				values = append(values, l.pop(left))
+			}
+			c.s.touch(d, key)
+			d.removeIfEmpty(c.s, key)
+			return values
+		}
+	}
+	register("LPOP", 1, 2, pop(true))
+	register("RPOP", 1, 2, pop(false))
+
+	register("LLEN", 1, 1, func(c *client, args [][]byte) interface{} {
+		l, errReply := c.d().getList(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			return 0
+		}
+		return len(l.values)
+	})
+
+	register("LRANGE", 3, 3, func(c *client, args [][]byte) interface{} {
+		start, ok1 := parseInt(args[1])
+		stop, ok2 := parseInt(args[2])
+		if !ok1 || !ok2 {
+			return errNotInteger
+		}
+		l, errReply := c.d().getList(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			return [][]byte{}
+		}
+		from, to := normalizeRange(start, stop, len(l.values))
+		return append([][]byte{}, l.values[from:to]...)
+	})
+
+	register("LINDEX", 2, 2, func(c *client, args [][]byte) interface{} {
+		index, ok := parseInt(args[1])
+		if !ok {
+			return errNotInteger
+		}
+		l, errReply := c.d().getList(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			return nil
+		}
+		if index < 0 {
+			index += int64(len(l.values))
+		}
+		if index < 0 || index >= int64(len(l.values)) {
+			return nil
+		}
+		return l.values[index]
+	})
+
+	register("LSET", 3, 3, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		index, ok := parseInt(args[1])
+		if !ok {
+			return errNotInteger
+		}
+		d := c.d()
+		l, errReply := d.getList(c.s, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			return errNoSuchKey
+		}
+		if index < 0 {
+			index += int64(len(l.values))
+		}
+		if index < 0 || index >= int64(len(l.values)) {
+			return errorReply("ERR index out of range")
+		}
+		l.values[index] = args[2]
+		c.s.touch(d, key)
+		return okReply
+	})
+
+	register("LREM", 3, 3, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		count, ok := parseInt(args[1])
+		if !ok {
+			return errNotInteger
+		}
+		d := c.d()
+		l, errReply := d.getList(c.s, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			return 0
+		}
+
+		removed := 0
+		limit := count
+		if limit < 0 {
+			limit = -limit
+		}
+
+		matched := func(v []byte) bool {
+			if !bytes.Equal(v, args[2]) || (limit > 0 && int64(removed) >= limit) {
+				return false
+			}
+			removed++
+			return true
+		}
+
+		kept := make([][]byte, 0, len(l.values))
+		if count >= 0 {
+			for _, v := range l.values {
+				if !matched(v) {
+					kept = append(kept, v)
+				}
+			}
+		} else {
+			for i := len(l.values) - 1; i >= 0; i-- {
+				if !matched(l.values[i]) {
+					kept = append([][]byte{l.values[i]}, kept...)
+				}
+			}
+		}
+		l.values = kept
+
+		if removed > 0 {
+			c.s.touch(d, key)
+			d.removeIfEmpty(c.s, key)
+		}
+		return removed
+	})
+
+	register("LTRIM", 3, 3, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		start, ok1 := parseInt(args[1])
+		stop, ok2 := parseInt(args[2])
+		if !ok1 || !ok2 {
+			return errNotInteger
+		}
+		d := c.d()
+		l, errReply := d.getList(c.s, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			return okReply
+		}
+		from, to := normalizeRange(start, stop, len(l.values))
+		l.values = append([][]byte{}, l.values[from:to]...)
+		c.s.touch(d, key)
+		d.removeIfEmpty(c.s, key)
+		return okReply
+	})
+
+	// move pops from src and pushes to dst, empty reports that src has nothing to pop
+	move := func(c *client, src string, dst string, fromLeft bool, toLeft bool) (v []byte, empty bool, errReply interface{}) {
+		d := c.d()
+		from, errReply := d.getList(c.s, src, false)
+		if errReply != nil {
+			return nil, false, errReply
+		}
+		if from == nil {
+			return nil, true, nil
+		}
+		to, errReply := d.getList(c.s, dst, false)
+		if errReply != nil {
+			return nil, false, errReply
+		}
+		if to == nil {
+			to = &list{}
+			d.set(c.s, dst, to)
+		}
+
+		v = from.pop(fromLeft)
+		c.s.touch(d, src)
+		to.push(toLeft, v)
+		c.s.touch(d, dst)
+		d.removeIfEmpty(c.s, src)
+		return v, false, nil
+	}
+
+	lmove := func(blocking bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			fromLeft, ok1 := parseSide(args[2])
+			toLeft, ok2 := parseSide(args[3])
+			if !ok1 || !ok2 {
+				return errSyntax
+			}
+			v, empty, errReply := move(c, string(args[0]), string(args[1]), fromLeft, toLeft)
+			if errReply != nil {
+				return errReply
+			}
+			if empty {
+				if blocking && !c.multi {
+					return nullArray{}
+				}
+				return nil
+			}
+			return v
+		}
+	}
+	register("LMOVE", 4, 4, lmove(false))
+	registerBlocking("BLMOVE", 5, 5, lmove(true), parseBlockingTimeout)
+
+	rpoplpush := func(blocking bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			v, empty, errReply := move(c, string(args[0]), string(args[1]), false, true)
+			if errReply != nil {
+				return errReply
+			}
+			if empty {
+				if blocking && !c.multi {
+					return nullArray{}
+				}
+				return nil
+			}
+			return v
+		}
+	}
+	register("RPOPLPUSH", 2, 2, rpoplpush(false))
+	registerBlocking("BRPOPLPUSH", 3, 3, rpoplpush(true), parseBlockingTimeout)
+
+	bpop := func(left bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			d := c.d()
+			for _, key := range args[:len(args)-1] {
+				l, errReply := d.getList(c.s, string(key), false)
+				if errReply != nil {
+					return errReply
+				}
+				if l == nil {
+					continue
+				}
+				v := l.pop(left)
+				c.s.touch(d, string(key))
+				d.removeIfEmpty(c.s, string(key))
+				return [][]byte{key, v}
+			}
+			return nullArray{}
+		}
+	}
+	registerBlocking("BLPOP", 2, -1, bpop(true), parseBlockingTimeout)
+	registerBlocking("BRPOP", 2, -1, bpop(false), parseBlockingTimeout)
+}
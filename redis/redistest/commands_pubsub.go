@@ -0,0 +1,77 @@
+package redistest
+
+func init() {
+	subscribe := func(pattern bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			kind := "subscribe"
+			if pattern {
+				kind = "psubscribe"
+			}
+			if c.channels == nil {
+				c.channels = map[string]bool{}
+				c.patterns = map[string]bool{}
+			}
+			replies := pushes{}
+			for _, ch := range args {
+				if pattern {
+					c.patterns[string(ch)] = true
+				} else {
+					c.channels[string(ch)] = true
+				}
+				replies = append(replies, []interface{}{kind, ch, len(c.channels) + len(c.patterns)})
+			}
+			return replies
+		}
+	}
+	register("SUBSCRIBE", 1, -1, subscribe(false))
+	register("PSUBSCRIBE", 1, -1, subscribe(true))
+
+	unsubscribe := func(pattern bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			kind, subscriptions := "unsubscribe", c.channels
+			if pattern {
+				kind, subscriptions = "punsubscribe", c.patterns
+			}
+
+			names := make([]string, 0)
+			for _, ch := range args {
+				names = append(names, string(ch))
+			}
+			if len(names) == 0 {
+				for ch := range subscriptions {
+					names = append(names, ch)
+				}
+			}
+
+			replies := pushes{}
+			for _, ch := range names {
+				delete(subscriptions, ch)
+				replies = append(replies, []interface{}{kind, ch, len(c.channels) + len(c.patterns)})
+			}
+			if len(names) == 0 {
+				replies = append(replies, []interface{}{kind, nil, len(c.channels) + len(c.patterns)})
+			}
+			return replies
+		}
+	}
+	register("UNSUBSCRIBE", 0, -1, unsubscribe(false))
+	register("PUNSUBSCRIBE", 0, -1, unsubscribe(true))
+
+	register("PUBLISH", 2, 2, func(c *client, args [][]byte) interface{} {
+		channel, message := string(args[0]), args[1]
+		n := 0
+		for other := range c.s.clients {
+			if other.channels[channel] {
+				other.write([]interface{}{"message", channel, message})
+				n++
+			}
+			for pattern := range other.patterns {
+				if globMatch(pattern, channel) {
+					other.write([]interface{}{"pmessage", pattern, channel, message})
+					n++
+				}
+			}
+		}
+		return n
+	})
+}
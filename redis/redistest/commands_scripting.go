@@ -0,0 +1,191 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ScriptFunc stands in for a Lua script, as the server can't run Lua.
+// call runs a command like redis.call does and returns its reply: nil, string for status replies,
+// error for error replies, int64, []byte or []interface{} for arrays.
+// The reply of ScriptFunc is encoded the same way, true is 1 and false is nil as for Lua.
+type ScriptFunc func(call func(name string, args ...interface{}) interface{}, keys []string, args [][]byte) interface{}
+
+var scripts = struct {
+	sync.RWMutex
+	funcs map[string]ScriptFunc
+}{
+	funcs: map[string]ScriptFunc{},
+}
+
+// RegisterScript makes EVAL of script and EVALSHA of its SHA1 run fn on every server
+func RegisterScript(script string, fn ScriptFunc) {
+	scripts.Lock()
+	defer scripts.Unlock()
+	scripts.funcs[sha1Hex(script)] = fn
+}
+
+func sha1Hex(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+func scriptFunc(sha string) ScriptFunc {
+	scripts.RLock()
+	defer scripts.RUnlock()
+	return scripts.funcs[sha]
+}
+
+var errNoScript = errorReply("NOSCRIPT No matching script. Please use EVAL.")
+
+// toArg turns the arguments of call into the bulk strings a client would send
+func toArg(v interface{}) []byte {
+	switch x := v.(type) {
+	case []byte:
+		return x
+	case string:
+		return []byte(x)
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+// fromReply turns the replies of handlers into the values passed to ScriptFunc
+func fromReply(reply interface{}) interface{} {
+	switch v := reply.(type) {
+	case simpleString:
+		return string(v)
+	case errorReply:
+		return errors.New(string(v))
+	case nullArray:
+		return nil
+	case int:
+		return int64(v)
+	case string:
+		return []byte(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = fromReply(v[i])
+		}
+		return values
+	case []string:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = []byte(v[i])
+		}
+		return values
+	case [][]byte:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = v[i]
+		}
+		return values
+	}
+	return reply
+}
+
+// toReply turns the reply of a ScriptFunc into a reply of the server
+func toReply(v interface{}) interface{} {
+	switch x := v.(type) {
+	case error:
+		return errorReply(x.Error())
+	case string:
+		return simpleString(x)
+	case []interface{}:
+		replies := make([]interface{}, len(x))
+		for i := range x {
+			replies[i] = toReply(x[i])
+		}
+		return replies
+	case bool:
+		// like Lua true and false
+		if x {
+			return 1
+		}
+		return nil
+	}
+	return v
+}
+
+func (c *client) eval(sha string, args [][]byte) interface{} {
+	fn := scriptFunc(sha)
+	if fn == nil {
+		return errorf("ERR redistest: no ScriptFunc registered for script %s", sha)
+	}
+
+	numKeys, ok := parseInt(args[0])
+	if !ok || numKeys < 0 {
+		return errNotInteger
+	}
+	if numKeys > int64(len(args)-1) {
+		return errorReply("ERR Number of keys can't be greater than number of args")
+	}
+
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[1+i])
+	}
+
+	call := func(name string, callArgs ...interface{}) interface{} {
+		name = strings.ToUpper(name)
+		cmd, ok := commands[name]
+		if !ok {
+			return errors.New("ERR Unknown Redis command called from script")
+		}
+		if !cmd.arity.valid(len(callArgs)) {
+			return errors.New(string(errWrongArgs(name)))
+		}
+		bulks := make([][]byte, len(callArgs))
+		for i := range callArgs {
+			bulks[i] = toArg(callArgs[i])
+		}
+		return fromReply(cmd.handle(c, bulks))
+	}
+
+	c.s.scripts[sha] = true
+	return toReply(fn(call, keys, args[1+numKeys:]))
+}
+
+func init() {
+	register("EVAL", 2, -1, func(c *client, args [][]byte) interface{} {
+		return c.eval(sha1Hex(string(args[0])), args[1:])
+	})
+
+	register("EVALSHA", 2, -1, func(c *client, args [][]byte) interface{} {
+		sha := strings.ToLower(string(args[0]))
+		if !c.s.scripts[sha] {
+			return errNoScript
+		}
+		return c.eval(sha, args[1:])
+	})
+
+	register("SCRIPT", 1, -1, func(c *client, args [][]byte) interface{} {
+		switch strings.ToUpper(string(args[0])) {
+		case "LOAD":
+			if len(args) != 2 {
+				return errWrongArgs("SCRIPT|LOAD")
+			}
+			sha := sha1Hex(string(args[1]))
+			c.s.scripts[sha] = true
+			return sha
+		case "EXISTS":
+			exists := make([]interface{}, 0, len(args)-1)
+			for _, sha := range args[1:] {
+				if c.s.scripts[strings.ToLower(string(sha))] {
+					exists = append(exists, 1)
+				} else {
+					exists = append(exists, 0)
+				}
+			}
+			return exists
+		case "FLUSH":
+			c.s.scripts = map[string]bool{}
+			return okReply
+		}
+		return errorf("ERR unknown subcommand '%s'", strings.ToLower(string(args[0])))
+	})
+}
@@ -0,0 +1,97 @@
+package redistest
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// sentinelMaster is a master monitored by a server acting as a sentinel
+type sentinelMaster struct {
+	addr     string
+	replicas []string
+}
+
+// SetSentinelMaster makes the server act as a sentinel which reports addr as the master name
+// and replicas as its replicas, addresses are host:port
+func (s *Server) SetSentinelMaster(name string, addr string, replicas ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sentinelMasters == nil {
+		s.sentinelMasters = map[string]sentinelMaster{}
+	}
+	s.sentinelMasters[name] = sentinelMaster{addr: addr, replicas: replicas}
+}
+
+// SetReadOnly makes the server act as a replica, which fails writes with READONLY
+func (s *Server) SetReadOnly(readOnly bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readOnly = readOnly
+}
+
+var errReadOnly = errorReply("READONLY You can't write against a read only replica.")
+
+// readOnlyCommands are the commands a replica runs
+var readOnlyCommands = map[string]bool{
+	"PING": true, "ECHO": true, "AUTH": true, "HELLO": true, "SELECT": true, "QUIT": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true, "WATCH": true, "UNWATCH": true,
+	"INFO": true, "ROLE": true, "SENTINEL": true, "TIME": true, "SCRIPT": true,
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PSUBSCRIBE": true, "PUNSUBSCRIBE": true, "PUBLISH": true,
+	"GET": true, "MGET": true, "STRLEN": true, "GETBIT": true, "BITCOUNT": true,
+	"EXISTS": true, "TYPE": true, "TTL": true, "PTTL": true, "KEYS": true, "SCAN": true, "RANDOMKEY": true, "DBSIZE": true,
+	"HGET": true, "HMGET": true, "HGETALL": true, "HKEYS": true, "HVALS": true, "HLEN": true, "HEXISTS": true,
+	"LLEN": true, "LRANGE": true, "LINDEX": true,
+	"ZSCORE": true, "ZCARD": true, "ZCOUNT": true, "ZRANK": true, "ZREVRANK": true,
+	"ZRANGE": true, "ZREVRANGE": true, "ZRANGEBYSCORE": true, "ZREVRANGEBYSCORE": true,
+	"XLEN": true, "XRANGE": true, "XREVRANGE": true, "PFCOUNT": true,
+}
+
+func splitAddr(addr string) (string, string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, ""
+	}
+	return host, port
+}
+
+func init() {
+	register("ROLE", 0, 0, func(c *client, args [][]byte) interface{} {
+		if c.s.readOnly {
+			host, port := c.s.Host(), strconv.Itoa(c.s.Port())
+			return []interface{}{"slave", host, port, "connected", 0}
+		}
+		return []interface{}{"master", 0, []interface{}{}}
+	})
+
+	// SENTINEL get-master-addr-by-name name | replicas name | slaves name
+	register("SENTINEL", 2, 2, func(c *client, args [][]byte) interface{} {
+		m, ok := c.s.sentinelMasters[string(args[1])]
+
+		switch strings.ToLower(string(args[0])) {
+		case "get-master-addr-by-name":
+			if !ok {
+				return nullArray{}
+			}
+			host, port := splitAddr(m.addr)
+			return []string{host, port}
+		case "replicas", "slaves":
+			if !ok {
+				return errorReply("ERR No such master with that name")
+			}
+			replicas := make([]interface{}, 0, len(m.replicas))
+			for _, addr := range m.replicas {
+				host, port := splitAddr(addr)
+				replicas = append(replicas, []string{
+					"name", addr,
+					"ip", host,
+					"port", port,
+					"flags", "slave",
+					"master-link-status", "ok",
+				})
+			}
+			return replicas
+		}
+		return errorf("ERR Unknown sentinel subcommand '%s'", string(args[0]))
+	})
+}
@@ -0,0 +1,831 @@
+package redistest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) less(other streamID) bool {
+	if id.ms == other.ms {
+		return id.seq < other.seq
+	}
+	return id.ms < other.ms
+}
+
+func (id streamID) next() streamID {
+	if id.seq == math.MaxUint64 {
+		return streamID{ms: id.ms + 1}
+	}
+	return streamID{ms: id.ms, seq: id.seq + 1}
+}
+
+// parseStreamID parses ms-seq, a missing seq is filled with defaultSeq
+func parseStreamID(b []byte, defaultSeq uint64) (streamID, bool) {
+	parts := strings.SplitN(string(b), "-", 2)
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if len(parts) == 1 {
+		return streamID{ms: ms, seq: defaultSeq}, true
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	return streamID{ms: ms, seq: seq}, true
+}
+
+var errInvalidStreamID = errorReply("ERR Invalid stream ID specified as stream command argument")
+
+type streamEntry struct {
+	id     streamID
+	fields [][]byte
+}
+
+type pendingEntry struct {
+	consumer    string
+	deliveredAt time.Time
+	count       int
+}
+
+type streamGroup struct {
+	lastDelivered streamID
+	pending       map[streamID]*pendingEntry
+	consumers     map[string]bool
+}
+
+// pendingIDs returns the ids of the pending entries in order
+func (g *streamGroup) pendingIDs() []streamID {
+	ids := make([]streamID, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].less(ids[j])
+	})
+	return ids
+}
+
+func newStream() *stream {
+	return &stream{groups: map[string]*streamGroup{}}
+}
+
+type stream struct {
+	entries []streamEntry
+	lastID  streamID
+	groups  map[string]*streamGroup
+}
+
+// search returns the index of the first entry with an id >= id
+func (st *stream) search(id streamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].id.less(id)
+	})
+}
+
+func (st *stream) entry(id streamID) (streamEntry, bool) {
+	i := st.search(id)
+	if i < len(st.entries) && st.entries[i].id == id {
+		return st.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+func (st *stream) trim(maxLen int64, minID *streamID) int {
+	from := 0
+	if maxLen >= 0 && int64(len(st.entries)) > maxLen {
+		from = len(st.entries) - int(maxLen)
+	}
+	if minID != nil {
+		if i := st.search(*minID); i > from {
+			from = i
+		}
+	}
+	st.entries = append([]streamEntry{}, st.entries[from:]...)
+	return from
+}
+
+func (e streamEntry) reply() []interface{} {
+	fields := make([][]byte, len(e.fields))
+	copy(fields, e.fields)
+	return []interface{}{e.id.String(), fields}
+}
+
+// parseTrim parses MAXLEN|MINID [=|~] threshold [LIMIT count] at args[i], and returns the index after it
+func parseTrim(args [][]byte, i int) (maxLen int64, minID *streamID, next int, errReply interface{}) {
+	maxLen = -1
+	strategy := strings.ToUpper(string(args[i]))
+	i++
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		i++
+	}
+	if i >= len(args) {
+		return 0, nil, 0, errSyntax
+	}
+
+	switch strategy {
+	case "MAXLEN":
+		n, ok := parseInt(args[i])
+		if !ok || n < 0 {
+			return 0, nil, 0, errorReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		maxLen = n
+	case "MINID":
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			return 0, nil, 0, errInvalidStreamID
+		}
+		minID = &id
+	}
+	i++
+
+	// trimming is always exact here, so LIMIT has nothing to bound
+	if i+1 < len(args) && strings.ToUpper(string(args[i])) == "LIMIT" {
+		i += 2
+	}
+	return maxLen, minID, i, nil
+}
+
+func noGroup(key string, group string, command string) errorReply {
+	return errorf("NOGROUP No such key '%s' or consumer group '%s' in %s", key, group, command)
+}
+
+func (c *client) getGroup(key string, group string, command string) (*stream, *streamGroup, interface{}) {
+	st, errReply := c.d().getStream(c.s, key, false)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if st == nil || st.groups[group] == nil {
+		return nil, nil, noGroup(key, group, command)
+	}
+	return st, st.groups[group], nil
+}
+
+func init() {
+	register("XADD", 4, -1, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+
+		noMkStream := false
+		maxLen, minID := int64(-1), (*streamID)(nil)
+		i := 1
+	options:
+		for i < len(args) {
+			switch strings.ToUpper(string(args[i])) {
+			case "NOMKSTREAM":
+				noMkStream = true
+				i++
+			case "MAXLEN", "MINID":
+				var errReply interface{}
+				maxLen, minID, i, errReply = parseTrim(args, i)
+				if errReply != nil {
+					return errReply
+				}
+			default:
+				break options
+			}
+		}
+
+		if i >= len(args) {
+			return errSyntax
+		}
+		rawID, pairs := args[i], args[i+1:]
+		if len(pairs) == 0 || len(pairs)%2 != 0 {
+			return errWrongArgs("XADD")
+		}
+
+		d := c.d()
+		st, errReply := d.getStream(c.s, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		if st == nil {
+			if noMkStream {
+				return nil
+			}
+			st = newStream()
+		}
+
+		var id streamID
+		if string(rawID) == "*" {
+			ms := uint64(c.s.now().UnixNano() / int64(time.Millisecond))
+			id = streamID{ms: ms}
+			if !st.lastID.less(id) {
+				id = st.lastID.next()
+			}
+		} else {
+			var ok bool
+			if id, ok = parseStreamID(rawID, 0); !ok {
+				return errInvalidStreamID
+			}
+			if id == (streamID{}) {
+				return errorReply("ERR The ID specified in XADD must be greater than 0-0")
+			}
+			if !st.lastID.less(id) {
+				return errorReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+			}
+		}
+
+		if d.get(c.s, key) == nil {
+			d.set(c.s, key, st)
+		}
+
+		fields := make([][]byte, len(pairs))
+		copy(fields, pairs)
+		st.entries = append(st.entries, streamEntry{id: id, fields: fields})
+		st.lastID = id
+		st.trim(maxLen, minID)
+		c.s.touch(d, key)
+
+		return id.String()
+	})
+
+	register("XLEN", 1, 1, func(c *client, args [][]byte) interface{} {
+		st, errReply := c.d().getStream(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		if st == nil {
+			return 0
+		}
+		return len(st.entries)
+	})
+
+	xrange := func(rev bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			start, end := args[1], args[2]
+			if rev {
+				start, end = end, start
+			}
+
+			parseBound := func(b []byte, low bool) (streamID, bool) {
+				switch string(b) {
+				case "-":
+					return streamID{}, true
+				case "+":
+					return streamID{ms: math.MaxUint64, seq: math.MaxUint64}, true
+				}
+				exclusive := strings.HasPrefix(string(b), "(")
+				if exclusive {
+					b = b[1:]
+				}
+				defaultSeq := uint64(0)
+				if !low {
+					defaultSeq = math.MaxUint64
+				}
+				id, ok := parseStreamID(b, defaultSeq)
+				if !ok {
+					return id, false
+				}
+				if exclusive && low {
+					id = id.next()
+				}
+				if exclusive && !low {
+					if id == (streamID{}) {
+						return id, false
+					}
+					if id.seq == 0 {
+						id = streamID{ms: id.ms - 1, seq: math.MaxUint64}
+					} else {
+						id.seq--
+					}
+				}
+				return id, true
+			}
+
+			from, ok1 := parseBound(start, true)
+			to, ok2 := parseBound(end, false)
+			if !ok1 || !ok2 {
+				return errInvalidStreamID
+			}
+
+			count := int64(-1)
+			if len(args) > 3 {
+				if len(args) != 5 || strings.ToUpper(string(args[3])) != "COUNT" {
+					return errSyntax
+				}
+				var ok bool
+				if count, ok = parseInt(args[4]); !ok {
+					return errNotInteger
+				}
+			}
+
+			st, errReply := c.d().getStream(c.s, string(args[0]), false)
+			if errReply != nil {
+				return errReply
+			}
+
+			replies := make([]interface{}, 0)
+			if st == nil {
+				return replies
+			}
+
+			matched := make([]streamEntry, 0)
+			for _, e := range st.entries[st.search(from):] {
+				if to.less(e.id) {
+					break
+				}
+				matched = append(matched, e)
+			}
+			if rev {
+				for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+					matched[i], matched[j] = matched[j], matched[i]
+				}
+			}
+			for _, e := range matched {
+				if count >= 0 && int64(len(replies)) >= count {
+					break
+				}
+				replies = append(replies, e.reply())
+			}
+			return replies
+		}
+	}
+	register("XRANGE", 3, 5, xrange(false))
+	register("XREVRANGE", 3, 5, xrange(true))
+
+	register("XDEL", 2, -1, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		d := c.d()
+		st, errReply := d.getStream(c.s, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		ids := make([]streamID, 0, len(args)-1)
+		for _, b := range args[1:] {
+			id, ok := parseStreamID(b, 0)
+			if !ok {
+				return errInvalidStreamID
+			}
+			ids = append(ids, id)
+		}
+		if st == nil {
+			return 0
+		}
+		n := 0
+		for _, id := range ids {
+			if i := st.search(id); i < len(st.entries) && st.entries[i].id == id {
+				st.entries = append(st.entries[:i], st.entries[i+1:]...)
+				n++
+			}
+		}
+		if n > 0 {
+			c.s.touch(d, key)
+		}
+		return n
+	})
+
+	register("XTRIM", 3, -1, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		switch strings.ToUpper(string(args[1])) {
+		case "MAXLEN", "MINID":
+		default:
+			return errSyntax
+		}
+		maxLen, minID, next, errReply := parseTrim(args, 1)
+		if errReply != nil {
+			return errReply
+		}
+		if next != len(args) {
+			return errSyntax
+		}
+		d := c.d()
+		st, errReply := d.getStream(c.s, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		if st == nil {
+			return 0
+		}
+		n := st.trim(maxLen, minID)
+		if n > 0 {
+			c.s.touch(d, key)
+		}
+		return n
+	})
+
+	register("XGROUP", 1, -1, func(c *client, args [][]byte) interface{} {
+		sub := strings.ToUpper(string(args[0]))
+		d := c.d()
+
+		switch sub {
+		case "CREATE":
+			if len(args) < 4 {
+				return errWrongArgs("XGROUP|CREATE")
+			}
+			key, group := string(args[1]), string(args[2])
+			mkStream := false
+			for _, option := range args[4:] {
+				switch strings.ToUpper(string(option)) {
+				case "MKSTREAM":
+					mkStream = true
+				case "ENTRIESREAD":
+				default:
+					return errSyntax
+				}
+			}
+
+			st, errReply := d.getStream(c.s, key, mkStream)
+			if errReply != nil {
+				return errReply
+			}
+			if st == nil {
+				return errorReply("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+			}
+			if st.groups[group] != nil {
+				return errorReply("BUSYGROUP Consumer Group name already exists")
+			}
+
+			id := st.lastID
+			if string(args[3]) != "$" {
+				var ok bool
+				if id, ok = parseStreamID(args[3], 0); !ok {
+					return errInvalidStreamID
+				}
+			}
+			st.groups[group] = &streamGroup{
+				lastDelivered: id,
+				pending:       map[streamID]*pendingEntry{},
+				consumers:     map[string]bool{},
+			}
+			c.s.touch(d, key)
+			return okReply
+
+		case "DESTROY":
+			if len(args) != 3 {
+				return errWrongArgs("XGROUP|DESTROY")
+			}
+			key, group := string(args[1]), string(args[2])
+			st, errReply := d.getStream(c.s, key, false)
+			if errReply != nil {
+				return errReply
+			}
+			if st == nil || st.groups[group] == nil {
+				return 0
+			}
+			delete(st.groups, group)
+			c.s.touch(d, key)
+			return 1
+
+		case "CREATECONSUMER", "DELCONSUMER":
+			if len(args) != 4 {
+				return errWrongArgs("XGROUP|" + sub)
+			}
+			key, group, consumer := string(args[1]), string(args[2]), string(args[3])
+			_, g, errReply := c.getGroup(key, group, "XGROUP")
+			if errReply != nil {
+				return errReply
+			}
+			if sub == "CREATECONSUMER" {
+				if g.consumers[consumer] {
+					return 0
+				}
+				g.consumers[consumer] = true
+				return 1
+			}
+			n := 0
+			for id, p := range g.pending {
+				if p.consumer == consumer {
+					delete(g.pending, id)
+					n++
+				}
+			}
+			delete(g.consumers, consumer)
+			return n
+
+		case "SETID":
+			if len(args) < 4 {
+				return errWrongArgs("XGROUP|SETID")
+			}
+			st, g, errReply := c.getGroup(string(args[1]), string(args[2]), "XGROUP")
+			if errReply != nil {
+				return errReply
+			}
+			id := st.lastID
+			if string(args[3]) != "$" {
+				var ok bool
+				if id, ok = parseStreamID(args[3], 0); !ok {
+					return errInvalidStreamID
+				}
+			}
+			g.lastDelivered = id
+			return okReply
+		}
+
+		return errorf("ERR unknown subcommand '%s'", strings.ToLower(sub))
+	})
+
+	registerBlocking("XREADGROUP", 6, -1, func(c *client, args [][]byte) interface{} {
+		group, consumer := string(args[1]), string(args[2])
+
+		count, noAck := int64(-1), false
+		i := 3
+	options:
+		for ; i < len(args); i++ {
+			switch strings.ToUpper(string(args[i])) {
+			case "COUNT":
+				if i+1 >= len(args) {
+					return errSyntax
+				}
+				var ok bool
+				if count, ok = parseInt(args[i+1]); !ok {
+					return errNotInteger
+				}
+				i++
+			case "BLOCK":
+				i++
+			case "NOACK":
+				noAck = true
+			case "STREAMS":
+				i++
+				break options
+			default:
+				return errSyntax
+			}
+		}
+
+		streams := args[i:]
+		if len(streams) == 0 || len(streams)%2 != 0 {
+			return errorReply("ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.")
+		}
+		keys, ids := streams[:len(streams)/2], streams[len(streams)/2:]
+
+		now := c.s.now()
+		replies := make([]interface{}, 0)
+		waiting := true
+
+		for j := range keys {
+			key := string(keys[j])
+			st, g, errReply := c.getGroup(key, group, "XREADGROUP with GROUP option")
+			if errReply != nil {
+				return errReply
+			}
+			g.consumers[consumer] = true
+
+			entries := make([]interface{}, 0)
+
+			if string(ids[j]) == ">" {
+				for _, e := range st.entries[st.search(g.lastDelivered.next()):] {
+					if count > 0 && int64(len(entries)) >= count {
+						break
+					}
+					g.lastDelivered = e.id
+					if !noAck {
+						g.pending[e.id] = &pendingEntry{consumer: consumer, deliveredAt: now, count: 1}
+					}
+					entries = append(entries, e.reply())
+				}
+				if len(entries) == 0 {
+					continue
+				}
+				c.s.touch(c.d(), key)
+			} else {
+				// the history of the pending entries of the consumer never blocks
+				waiting = false
+				from, ok := parseStreamID(ids[j], 0)
+				if !ok {
+					return errInvalidStreamID
+				}
+				for _, id := range g.pendingIDs() {
+					if count > 0 && int64(len(entries)) >= count {
+						break
+					}
+					p := g.pending[id]
+					if p.consumer != consumer || id.less(from) || id == from {
+						continue
+					}
+					if e, ok := st.entry(id); ok {
+						entries = append(entries, e.reply())
+					} else {
+						entries = append(entries, []interface{}{id.String(), nil})
+					}
+				}
+			}
+
+			waiting = false
+			replies = append(replies, []interface{}{key, entries})
+		}
+
+		// waits when BLOCK is given, see the timeout below
+		if waiting {
+			return nullArray{}
+		}
+		return replies
+	}, func(args [][]byte) (time.Duration, interface{}) {
+		for i := 3; i < len(args)-1; i++ {
+			switch strings.ToUpper(string(args[i])) {
+			case "STREAMS":
+				return -1, nil
+			case "BLOCK":
+				ms, ok := parseInt(args[i+1])
+				if !ok || ms < 0 {
+					return 0, errTimeout
+				}
+				return time.Duration(ms) * time.Millisecond, nil
+			}
+		}
+		return -1, nil
+	})
+
+	register("XACK", 3, -1, func(c *client, args [][]byte) interface{} {
+		st, errReply := c.d().getStream(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		ids := make([]streamID, 0, len(args)-2)
+		for _, b := range args[2:] {
+			id, ok := parseStreamID(b, 0)
+			if !ok {
+				return errInvalidStreamID
+			}
+			ids = append(ids, id)
+		}
+		if st == nil || st.groups[string(args[1])] == nil {
+			return 0
+		}
+		g := st.groups[string(args[1])]
+		n := 0
+		for _, id := range ids {
+			if _, ok := g.pending[id]; ok {
+				delete(g.pending, id)
+				n++
+			}
+		}
+		return n
+	})
+
+	register("XPENDING", 2, -1, func(c *client, args [][]byte) interface{} {
+		key, group := string(args[0]), string(args[1])
+		_, g, errReply := c.getGroup(key, group, "XPENDING")
+		if errReply != nil {
+			return errReply
+		}
+		now := c.s.now()
+		ids := g.pendingIDs()
+
+		if len(args) == 2 {
+			if len(ids) == 0 {
+				return []interface{}{0, nil, nil, nullArray{}}
+			}
+			counts := map[string]int{}
+			for _, id := range ids {
+				counts[g.pending[id].consumer]++
+			}
+			consumers := make([]string, 0, len(counts))
+			for consumer := range counts {
+				consumers = append(consumers, consumer)
+			}
+			sort.Strings(consumers)
+			perConsumer := make([]interface{}, 0, len(consumers))
+			for _, consumer := range consumers {
+				perConsumer = append(perConsumer, []interface{}{consumer, strconv.Itoa(counts[consumer])})
+			}
+			return []interface{}{len(ids), ids[0].String(), ids[len(ids)-1].String(), perConsumer}
+		}
+
+		rest := args[2:]
+		minIdle := time.Duration(0)
+		if strings.ToUpper(string(rest[0])) == "IDLE" {
+			if len(rest) < 2 {
+				return errSyntax
+			}
+			ms, ok := parseInt(rest[1])
+			if !ok {
+				return errNotInteger
+			}
+			minIdle = time.Duration(ms) * time.Millisecond
+			rest = rest[2:]
+		}
+		if len(rest) != 3 && len(rest) != 4 {
+			return errSyntax
+		}
+
+		from, to := streamID{}, streamID{ms: math.MaxUint64, seq: math.MaxUint64}
+		if string(rest[0]) != "-" {
+			var ok bool
+			if from, ok = parseStreamID(rest[0], 0); !ok {
+				return errInvalidStreamID
+			}
+		}
+		if string(rest[1]) != "+" {
+			var ok bool
+			if to, ok = parseStreamID(rest[1], math.MaxUint64); !ok {
+				return errInvalidStreamID
+			}
+		}
+		count, ok := parseInt(rest[2])
+		if !ok {
+			return errNotInteger
+		}
+		consumer := ""
+		if len(rest) == 4 {
+			consumer = string(rest[3])
+		}
+
+		replies := make([]interface{}, 0)
+		for _, id := range ids {
+			if int64(len(replies)) >= count {
+				break
+			}
+			p := g.pending[id]
+			idle := now.Sub(p.deliveredAt)
+			if id.less(from) || to.less(id) || idle < minIdle || (consumer != "" && p.consumer != consumer) {
+				continue
+			}
+			replies = append(replies, []interface{}{id.String(), p.consumer, int64(idle / time.Millisecond), p.count})
+		}
+		return replies
+	})
+
+	register("XAUTOCLAIM", 5, 8, func(c *client, args [][]byte) interface{} {
+		key, group, consumer := string(args[0]), string(args[1]), string(args[2])
+
+		ms, ok := parseInt(args[3])
+		if !ok || ms < 0 {
+			return errorReply("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+		}
+		minIdle := time.Duration(ms) * time.Millisecond
+
+		start, ok := parseStreamID(args[4], 0)
+		if !ok {
+			return errInvalidStreamID
+		}
+
+		count, justID := int64(100), false
+		for i := 5; i < len(args); i++ {
+			switch strings.ToUpper(string(args[i])) {
+			case "COUNT":
+				if i+1 >= len(args) {
+					return errSyntax
+				}
+				if count, ok = parseInt(args[i+1]); !ok || count < 1 {
+					return errorReply("ERR COUNT must be > 0")
+				}
+				i++
+			case "JUSTID":
+				justID = true
+			default:
+				return errSyntax
+			}
+		}
+
+		st, g, errReply := c.getGroup(key, group, "XAUTOCLAIM")
+		if errReply != nil {
+			return errReply
+		}
+		g.consumers[consumer] = true
+
+		now := c.s.now()
+		claimed := make([]interface{}, 0)
+		deleted := make([]interface{}, 0)
+		next := streamID{}
+
+		scanned := int64(0)
+		for _, id := range g.pendingIDs() {
+			if id.less(start) {
+				continue
+			}
+			if scanned >= count {
+				next = id
+				break
+			}
+			scanned++
+
+			p := g.pending[id]
+			if now.Sub(p.deliveredAt) < minIdle {
+				continue
+			}
+
+			e, exists := st.entry(id)
+			if !exists {
+				delete(g.pending, id)
+				deleted = append(deleted, id.String())
+				continue
+			}
+
+			p.consumer = consumer
+			p.deliveredAt = now
+			if justID {
+				claimed = append(claimed, id.String())
+				continue
+			}
+			p.count++
+			claimed = append(claimed, e.reply())
+		}
+
+		return []interface{}{next.String(), claimed, deleted}
+	})
+}
@@ -0,0 +1,287 @@
+package redistest
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (c *client) d() *db {
+	return c.s.db(c.db)
+}
+
+func parseInt(b []byte) (int64, bool) {
+	i, err := strconv.ParseInt(string(b), 10, 64)
+	return i, err == nil
+}
+
+func parseFloat(b []byte) (float64, bool) {
+	switch strings.ToLower(string(b)) {
+	case "+inf", "inf":
+		return math.Inf(1), true
+	case "-inf":
+		return math.Inf(-1), true
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.Abs(f) < 1e17:
+		// like %.17g of Redis, which prints large integers such as unix milliseconds without an exponent
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func init() {
+	register("GET", 1, 1, func(c *client, args [][]byte) interface{} {
+		v, errReply := c.d().getString(c.s, string(args[0]))
+		if errReply != nil {
+			return errReply
+		}
+		return v
+	})
+
+	register("SET", 2, -1, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		d := c.d()
+
+		nx, xx, get, keepTTL := false, false, false, false
+		expireAt := time.Time{}
+
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(string(args[i])) {
+			case "NX":
+				nx = true
+			case "XX":
+				xx = true
+			case "GET":
+				get = true
+			case "KEEPTTL":
+				keepTTL = true
+			case "EX", "PX", "EXAT", "PXAT":
+				if i+1 >= len(args) {
+					return errSyntax
+				}
+				n, ok := parseInt(args[i+1])
+				if !ok {
+					return errNotInteger
+				}
+				if n <= 0 {
+					return errorReply("ERR invalid expire time in 'set' command")
+				}
+				switch strings.ToUpper(string(args[i])) {
+				case "EX":
+					expireAt = c.s.now().Add(time.Duration(n) * time.Second)
+				case "PX":
+					expireAt = c.s.now().Add(time.Duration(n) * time.Millisecond)
+				case "EXAT":
+					expireAt = time.Unix(n, 0)
+				case "PXAT":
+					expireAt = time.Unix(0, n*int64(time.Millisecond))
+				}
+				i++
+			default:
+				return errSyntax
+			}
+		}
+
+		if nx && xx {
+			return errSyntax
+		}
+
+		current := d.get(c.s, key)
+
+		var old interface{}
+		if get && current != nil {
+			v, ok := current.value.([]byte)
+			if !ok {
+				return errWrongType
+			}
+			old = v
+		}
+
+		if (nx && current != nil) || (xx && current == nil) {
+			if get {
+				return old
+			}
+			return nil
+		}
+
+		i := d.set(c.s, key, args[1])
+		if keepTTL && current != nil {
+			i.expireAt = current.expireAt
+		} else {
+			i.expireAt = expireAt
+		}
+
+		if get {
+			return old
+		}
+		return okReply
+	})
+
+	register("SETNX", 2, 2, func(c *client, args [][]byte) interface{} {
+		d := c.d()
+		if d.get(c.s, string(args[0])) != nil {
+			return 0
+		}
+		d.set(c.s, string(args[0]), args[1])
+		return 1
+	})
+
+	setWithTTL := func(unit time.Duration) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			n, ok := parseInt(args[1])
+			if !ok {
+				return errNotInteger
+			}
+			if n <= 0 {
+				return errInvalidTTL
+			}
+			c.d().set(c.s, string(args[0]), args[2]).expireAt = c.s.now().Add(time.Duration(n) * unit)
+			return okReply
+		}
+	}
+	register("SETEX", 3, 3, setWithTTL(time.Second))
+	register("PSETEX", 3, 3, setWithTTL(time.Millisecond))
+
+	register("GETSET", 2, 2, func(c *client, args [][]byte) interface{} {
+		d := c.d()
+		old, errReply := d.getString(c.s, string(args[0]))
+		if errReply != nil {
+			return errReply
+		}
+		d.set(c.s, string(args[0]), args[1])
+		return old
+	})
+
+	register("GETDEL", 1, 1, func(c *client, args [][]byte) interface{} {
+		d := c.d()
+		old, errReply := d.getString(c.s, string(args[0]))
+		if errReply != nil {
+			return errReply
+		}
+		d.del(c.s, string(args[0]))
+		return old
+	})
+
+	register("MGET", 1, -1, func(c *client, args [][]byte) interface{} {
+		d := c.d()
+		values := make([]interface{}, len(args))
+		for i, key := range args {
+			if v, errReply := d.getString(c.s, string(key)); errReply == nil {
+				values[i] = v
+			}
+		}
+		return values
+	})
+
+	register("MSET", 2, -1, func(c *client, args [][]byte) interface{} {
+		if len(args)%2 != 0 {
+			return errWrongArgs("MSET")
+		}
+		d := c.d()
+		for i := 0; i < len(args); i += 2 {
+			d.set(c.s, string(args[i]), args[i+1])
+		}
+		return okReply
+	})
+
+	register("APPEND", 2, 2, func(c *client, args [][]byte) interface{} {
+		d := c.d()
+		key := string(args[0])
+		old, errReply := d.getString(c.s, key)
+		if errReply != nil {
+			return errReply
+		}
+		v := append(append([]byte{}, old...), args[1]...)
+		c.setKeepTTL(key, v)
+		return len(v)
+	})
+
+	register("STRLEN", 1, 1, func(c *client, args [][]byte) interface{} {
+		v, errReply := c.d().getString(c.s, string(args[0]))
+		if errReply != nil {
+			return errReply
+		}
+		return len(v)
+	})
+
+	incrBy := func(c *client, key string, by int64) interface{} {
+		v, errReply := c.d().getString(c.s, key)
+		if errReply != nil {
+			return errReply
+		}
+		n := int64(0)
+		if v != nil {
+			var ok bool
+			if n, ok = parseInt(v); !ok {
+				return errNotInteger
+			}
+		}
+		n += by
+		c.setKeepTTL(key, []byte(strconv.FormatInt(n, 10)))
+		return n
+	}
+
+	register("INCR", 1, 1, func(c *client, args [][]byte) interface{} {
+		return incrBy(c, string(args[0]), 1)
+	})
+	register("DECR", 1, 1, func(c *client, args [][]byte) interface{} {
+		return incrBy(c, string(args[0]), -1)
+	})
+	register("INCRBY", 2, 2, func(c *client, args [][]byte) interface{} {
+		by, ok := parseInt(args[1])
+		if !ok {
+			return errNotInteger
+		}
+		return incrBy(c, string(args[0]), by)
+	})
+	register("DECRBY", 2, 2, func(c *client, args [][]byte) interface{} {
+		by, ok := parseInt(args[1])
+		if !ok {
+			return errNotInteger
+		}
+		return incrBy(c, string(args[0]), -by)
+	})
+
+	register("INCRBYFLOAT", 2, 2, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		by, ok := parseFloat(args[1])
+		if !ok {
+			return errNotFloat
+		}
+		v, errReply := c.d().getString(c.s, key)
+		if errReply != nil {
+			return errReply
+		}
+		f := float64(0)
+		if v != nil {
+			if f, ok = parseFloat(v); !ok {
+				return errNotFloat
+			}
+		}
+		result := []byte(strconv.FormatFloat(f+by, 'f', -1, 64))
+		c.setKeepTTL(key, result)
+		return result
+	})
+}
+
+// setKeepTTL replaces the value of key keeping its TTL, as commands modifying strings in place do
+func (c *client) setKeepTTL(key string, value interface{}) {
+	d := c.d()
+	if i := d.get(c.s, key); i != nil {
+		i.value = value
+		c.s.touch(d, key)
+		return
+	}
+	d.set(c.s, key, value)
+}
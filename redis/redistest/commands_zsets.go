@@ -0,0 +1,446 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+func newZSet() *zset {
+	return &zset{scores: map[string]float64{}}
+}
+
+type zset struct {
+	scores map[string]float64
+}
+
+type zmember struct {
+	member string
+	score  float64
+}
+
+// sorted returns the members ordered by score, then by member
+func (z *zset) sorted() []zmember {
+	members := make([]zmember, 0, len(z.scores))
+	for m, score := range z.scores {
+		members = append(members, zmember{member: m, score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].score == members[j].score {
+			return members[i].member < members[j].member
+		}
+		return members[i].score < members[j].score
+	})
+	return members
+}
+
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(b []byte) (scoreBound, bool) {
+	s := string(b)
+	bound := scoreBound{}
+	if strings.HasPrefix(s, "(") {
+		bound.exclusive = true
+		s = s[1:]
+	}
+	f, ok := parseFloat([]byte(s))
+	bound.value = f
+	return bound, ok
+}
+
+func (b scoreBound) lessOrEqual(score float64) bool {
+	if b.exclusive {
+		return b.value < score
+	}
+	return b.value <= score
+}
+
+func (b scoreBound) greaterOrEqual(score float64) bool {
+	if b.exclusive {
+		return b.value > score
+	}
+	return b.value >= score
+}
+
+func withScores(members []zmember, scores bool) [][]byte {
+	values := make([][]byte, 0, len(members)*2)
+	for _, m := range members {
+		values = append(values, []byte(m.member))
+		if scores {
+			values = append(values, []byte(formatFloat(m.score)))
+		}
+	}
+	return values
+}
+
+func reverse(members []zmember) []zmember {
+	reversed := make([]zmember, len(members))
+	for i := range members {
+		reversed[len(members)-1-i] = members[i]
+	}
+	return reversed
+}
+
+// zrange implements ZRANGE key start stop [BYSCORE] [REV] [LIMIT offset count] [WITHSCORES]
+func zrange(c *client, key string, start []byte, stop []byte, byScore bool, rev bool, offset int64, count int64, scores bool) interface{} {
+	z, errReply := c.d().getZSet(c.s, key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return [][]byte{}
+	}
+
+	members := z.sorted()
+	if rev {
+		members = reverse(members)
+	}
+
+	if !byScore {
+		from, ok1 := parseInt(start)
+		to, ok2 := parseInt(stop)
+		if !ok1 || !ok2 {
+			return errNotInteger
+		}
+		i, j := normalizeRange(from, to, len(members))
+		return withScores(members[i:j], scores)
+	}
+
+	min, ok1 := parseScoreBound(start)
+	max, ok2 := parseScoreBound(stop)
+	if !ok1 || !ok2 {
+		return errorReply("ERR min or max is not a float")
+	}
+	if rev {
+		min, max = max, min
+	}
+
+	matched := make([]zmember, 0)
+	for _, m := range members {
+		if min.lessOrEqual(m.score) && max.greaterOrEqual(m.score) {
+			matched = append(matched, m)
+		}
+	}
+
+	if offset > 0 {
+		if offset >= int64(len(matched)) {
+			matched = nil
+		} else {
+			matched = matched[offset:]
+		}
+	}
+	if count >= 0 && count < int64(len(matched)) {
+		matched = matched[:count]
+	}
+	return withScores(matched, scores)
+}
+
+func parseRangeOptions(args [][]byte, allowed ...string) (options map[string]bool, offset int64, count int64, errReply interface{}) {
+	options = map[string]bool{}
+	count = -1
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "LIMIT" {
+			if i+2 >= len(args) {
+				return nil, 0, 0, errSyntax
+			}
+			var ok1, ok2 bool
+			offset, ok1 = parseInt(args[i+1])
+			count, ok2 = parseInt(args[i+2])
+			if !ok1 || !ok2 {
+				return nil, 0, 0, errNotInteger
+			}
+			options[option] = true
+			i += 2
+			continue
+		}
+
+		valid := false
+		for _, a := range allowed {
+			if a == option {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, 0, 0, errSyntax
+		}
+		options[option] = true
+	}
+	return
+}
+
+func init() {
+	register("ZADD", 3, -1, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+
+		nx, xx, gt, lt, ch, incr := false, false, false, false, false, false
+		i := 1
+	options:
+		for ; i < len(args); i++ {
+			switch strings.ToUpper(string(args[i])) {
+			case "NX":
+				nx = true
+			case "XX":
+				xx = true
+			case "GT":
+				gt = true
+			case "LT":
+				lt = true
+			case "CH":
+				ch = true
+			case "INCR":
+				incr = true
+			default:
+				break options
+			}
+		}
+
+		pairs := args[i:]
+		if len(pairs) == 0 || len(pairs)%2 != 0 || (nx && xx) || (incr && len(pairs) != 2) {
+			return errSyntax
+		}
+
+		scores := make([]float64, 0, len(pairs)/2)
+		for j := 0; j < len(pairs); j += 2 {
+			f, ok := parseFloat(pairs[j])
+			if !ok {
+				return errNotFloat
+			}
+			scores = append(scores, f)
+		}
+
+		d := c.d()
+		z, errReply := d.getZSet(c.s, key, true)
+		if errReply != nil {
+			return errReply
+		}
+
+		added, changed := 0, 0
+		var result interface{}
+
+		for j := 0; j < len(pairs); j += 2 {
+			member := string(pairs[j+1])
+			score := scores[j/2]
+			current, exists := z.scores[member]
+
+			if (nx && exists) || (xx && !exists) {
+				continue
+			}
+			if incr && exists {
+				score += current
+			}
+			if exists && ((gt && score <= current) || (lt && score >= current)) {
+				continue
+			}
+
+			z.scores[member] = score
+			result = []byte(formatFloat(score))
+			if !exists {
+				added++
+			} else if current != score {
+				changed++
+			}
+		}
+
+		c.s.touch(d, key)
+		d.removeIfEmpty(c.s, key)
+
+		if incr {
+			return result
+		}
+		if ch {
+			return added + changed
+		}
+		return added
+	})
+
+	register("ZINCRBY", 3, 3, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		by, ok := parseFloat(args[1])
+		if !ok {
+			return errNotFloat
+		}
+		d := c.d()
+		z, errReply := d.getZSet(c.s, key, true)
+		if errReply != nil {
+			return errReply
+		}
+		z.scores[string(args[2])] += by
+		c.s.touch(d, key)
+		return []byte(formatFloat(z.scores[string(args[2])]))
+	})
+
+	register("ZREM", 2, -1, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		d := c.d()
+		z, errReply := d.getZSet(c.s, key, false)
+		if errReply != nil {
+			return errReply
+		}
+		if z == nil {
+			return 0
+		}
+		n := 0
+		for _, m := range args[1:] {
+			if _, ok := z.scores[string(m)]; ok {
+				delete(z.scores, string(m))
+				n++
+			}
+		}
+		if n > 0 {
+			c.s.touch(d, key)
+			d.removeIfEmpty(c.s, key)
+		}
+		return n
+	})
+
+	register("ZSCORE", 2, 2, func(c *client, args [][]byte) interface{} {
+		z, errReply := c.d().getZSet(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		if z == nil {
+			return nil
+		}
+		if score, ok := z.scores[string(args[1])]; ok {
+			return []byte(formatFloat(score))
+		}
+		return nil
+	})
+
+	register("ZCARD", 1, 1, func(c *client, args [][]byte) interface{} {
+		z, errReply := c.d().getZSet(c.s, string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		if z == nil {
+			return 0
+		}
+		return len(z.scores)
+	})
+
+	register("ZCOUNT", 3, 3, func(c *client, args [][]byte) interface{} {
+		reply := zrange(c, string(args[0]), args[1], args[2], true, false, 0, -1, false)
+		if values, ok := reply.([][]byte); ok {
+			return len(values)
+		}
+		return reply
+	})
+
+	rank := func(rev bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			z, errReply := c.d().getZSet(c.s, string(args[0]), false)
+			if errReply != nil {
+				return errReply
+			}
+			if z == nil {
+				return nil
+			}
+			members := z.sorted()
+			if rev {
+				members = reverse(members)
+			}
+			for i, m := range members {
+				if m.member == string(args[1]) {
+					return i
+				}
+			}
+			return nil
+		}
+	}
+	register("ZRANK", 2, 2, rank(false))
+	register("ZREVRANK", 2, 2, rank(true))
+
+	register("ZRANGE", 3, -1, func(c *client, args [][]byte) interface{} {
+		options, offset, count, errReply := parseRangeOptions(args[3:], "BYSCORE", "REV", "WITHSCORES")
+		if errReply != nil {
+			return errReply
+		}
+		if options["LIMIT"] && !options["BYSCORE"] {
+			return errorReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+		}
+		return zrange(c, string(args[0]), args[1], args[2], options["BYSCORE"], options["REV"], offset, count, options["WITHSCORES"])
+	})
+
+	register("ZREVRANGE", 3, 4, func(c *client, args [][]byte) interface{} {
+		options, _, _, errReply := parseRangeOptions(args[3:], "WITHSCORES")
+		if errReply != nil {
+			return errReply
+		}
+		return zrange(c, string(args[0]), args[1], args[2], false, true, 0, -1, options["WITHSCORES"])
+	})
+
+	register("ZRANGEBYSCORE", 3, -1, func(c *client, args [][]byte) interface{} {
+		options, offset, count, errReply := parseRangeOptions(args[3:], "WITHSCORES")
+		if errReply != nil {
+			return errReply
+		}
+		return zrange(c, string(args[0]), args[1], args[2], true, false, offset, count, options["WITHSCORES"])
+	})
+
+	register("ZREVRANGEBYSCORE", 3, -1, func(c *client, args [][]byte) interface{} {
+		options, offset, count, errReply := parseRangeOptions(args[3:], "WITHSCORES")
+		if errReply != nil {
+			return errReply
+		}
+		return zrange(c, string(args[0]), args[1], args[2], true, true, offset, count, options["WITHSCORES"])
+	})
+
+	register("ZREMRANGEBYSCORE", 3, 3, func(c *client, args [][]byte) interface{} {
+		key := string(args[0])
+		reply := zrange(c, key, args[1], args[2], true, false, 0, -1, false)
+		members, ok := reply.([][]byte)
+		if !ok {
+			return reply
+		}
+		d := c.d()
+		if z, _ := d.getZSet(c.s, key, false); z != nil {
+			for _, m := range members {
+				delete(z.scores, string(m))
+			}
+			if len(members) > 0 {
+				c.s.touch(d, key)
+				d.removeIfEmpty(c.s, key)
+			}
+		}
+		return len(members)
+	})
+
+	pop := func(max bool) func(c *client, args [][]byte) interface{} {
+		return func(c *client, args [][]byte) interface{} {
+			key := string(args[0])
+			count := int64(1)
+			if len(args) == 2 {
+				var ok bool
+				if count, ok = parseInt(args[1]); !ok || count < 0 {
+					return errNotInteger
+				}
+			}
+			d := c.d()
+			z, errReply := d.getZSet(c.s, key, false)
+			if errReply != nil {
+				return errReply
+			}
+			if z == nil {
+				return [][]byte{}
+			}
+			members := z.sorted()
+			if max {
+				members = reverse(members)
+			}
+			count = int64(math.Min(float64(count), float64(len(members))))
+			for _, m := range members[:count] {
+				delete(z.scores, m.member)
+			}
+			c.s.touch(d, key)
+			d.removeIfEmpty(c.s, key)
+			return withScores(members[:count], true)
+		}
+	}
+	register("ZPOPMIN", 1, 2, pop(false))
+	register("ZPOPMAX", 1, 2, pop(true))
+}
@@ -0,0 +1,201 @@
+package redistest
+
+import (
+	"sort"
+	"time"
+)
+
+func newDB() *db {
+	return &db{
+		items:    map[string]*item{},
+		versions: map[string]uint64{},
+	}
+}
+
+type db struct {
+	items map[string]*item
+	// versions of keys, compared by EXEC against the versions seen by WATCH
+	versions map[string]uint64
+}
+
+// item holds one of []byte, hash, *list, *zset or *stream
+type item struct {
+	value    interface{}
+	expireAt time.Time
+}
+
+type hash map[string][]byte
+
+type list struct {
+	values [][]byte
+}
+
+func (i *item) expired(now time.Time) bool {
+	return !i.expireAt.IsZero() && !now.Before(i.expireAt)
+}
+
+func (i *item) typeName() string {
+	switch i.value.(type) {
+	case []byte:
+		return "string"
+	case hash:
+		return "hash"
+	case *list:
+		return "list"
+	case *zset:
+		return "zset"
+	case *stream:
+		return "stream"
+	}
+	return "none"
+}
+
+// expire removes key when it is expired
+func (d *db) expire(s *Server, key string) {
+	if i, ok := d.items[key]; ok && i.expired(s.now()) {
+		delete(d.items, key)
+		s.touch(d, key)
+	}
+}
+
+func (d *db) get(s *Server, key string) *item {
+	d.expire(s, key)
+	return d.items[key]
+}
+
+func (d *db) set(s *Server, key string, value interface{}) *item {
+	i := &item{value: value}
+	d.items[key] = i
+	s.touch(d, key)
+	return i
+}
+
+func (d *db) del(s *Server, key string) bool {
+	if d.get(s, key) == nil {
+		return false
+	}
+	delete(d.items, key)
+	s.touch(d, key)
+	return true
+}
+
+func (d *db) flush(s *Server) {
+	for key := range d.items {
+		delete(d.items, key)
+		s.touch(d, key)
+	}
+}
+
+// keys returns the sorted alive keys
+func (d *db) keys(s *Server) []string {
+	keys := make([]string, 0, len(d.items))
+	for key := range d.items {
+		d.expire(s, key)
+		if _, ok := d.items[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *db) getString(s *Server, key string) ([]byte, interface{}) {
+	i := d.get(s, key)
+	if i == nil {
+		return nil, nil
+	}
+	v, ok := i.value.([]byte)
+	if !ok {
+		return nil, errWrongType
+	}
+	return v, nil
+}
+
+func (d *db) getHash(s *Server, key string, create bool) (hash, interface{}) {
+	i := d.get(s, key)
+	if i == nil {
+		if !create {
+			return nil, nil
+		}
+		h := hash{}
+		d.set(s, key, h)
+		return h, nil
+	}
+	h, ok := i.value.(hash)
+	if !ok {
+		return nil, errWrongType
+	}
+	return h, nil
+}
+
+func (d *db) getList(s *Server, key string, create bool) (*list, interface{}) {
+	i := d.get(s, key)
+	if i == nil {
+		if !create {
+			return nil, nil
+		}
+		l := &list{}
+		d.set(s, key, l)
+		return l, nil
+	}
+	l, ok := i.value.(*list)
+	if !ok {
+		return nil, errWrongType
+	}
+	return l, nil
+}
+
+func (d *db) getZSet(s *Server, key string, create bool) (*zset, interface{}) {
+	i := d.get(s, key)
+	if i == nil {
+		if !create {
+			return nil, nil
+		}
+		z := newZSet()
+		d.set(s, key, z)
+		return z, nil
+	}
+	z, ok := i.value.(*zset)
+	if !ok {
+		return nil, errWrongType
+	}
+	return z, nil
+}
+
+func (d *db) getStream(s *Server, key string, create bool) (*stream, interface{}) {
+	i := d.get(s, key)
+	if i == nil {
+		if !create {
+			return nil, nil
+		}
+		st := newStream()
+		d.set(s, key, st)
+		return st, nil
+	}
+	st, ok := i.value.(*stream)
+	if !ok {
+		return nil, errWrongType
+	}
+	return st, nil
+}
+
+// removeIfEmpty deletes containers left without elements, as Redis does, empty streams are kept
+func (d *db) removeIfEmpty(s *Server, key string) {
+	i, ok := d.items[key]
+	if !ok {
+		return
+	}
+	empty := false
+	switch v := i.value.(type) {
+	case hash:
+		empty = len(v) == 0
+	case *list:
+		empty = len(v.values) == 0
+	case *zset:
+		empty = len(v.scores) == 0
+	}
+	if empty {
+		delete(d.items, key)
+		s.touch(d, key)
+	}
+}
@@ -0,0 +1,150 @@
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// replies of handlers, encoded by writeReply
+type (
+	simpleString string
+	errorReply   string
+	// nullArray is the reply of an aborted EXEC or a timed out blocking pop
+	nullArray struct{}
+	// pushes are written as separate replies, as subscribe replies are
+	pushes []interface{}
+)
+
+var okReply = simpleString("OK")
+
+func errorf(format string, args ...interface{}) errorReply {
+	return errorReply(fmt.Sprintf(format, args...))
+}
+
+var (
+	errSyntax       = errorReply("ERR syntax error")
+	errWrongType    = errorReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger   = errorReply("ERR value is not an integer or out of range")
+	errNotFloat     = errorReply("ERR value is not a valid float")
+	errNoSuchKey    = errorReply("ERR no such key")
+	errInvalidTTL   = errorReply("ERR invalid expire time")
+	errTimeout      = errorReply("ERR timeout is not a float or out of range")
+	errNotAuthed    = errorReply("NOAUTH Authentication required.")
+	errInvalidAuth  = errorReply("WRONGPASS invalid username-password pair or user is disabled.")
+	errInvalidIndex = errorReply("ERR DB index is out of range")
+)
+
+func errWrongArgs(name string) errorReply {
+	return errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 {
+		return readCommand(r)
+	}
+
+	// inline command, as typed in telnet
+	if line[0] != '*' {
+		fields := strings.Fields(line)
+		args := make([][]byte, len(fields))
+		for i := range fields {
+			args[i] = []byte(fields[i])
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, errors.New("invalid multibulk length")
+	}
+
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected '$', got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errors.New("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case nullArray:
+		w.WriteString("*-1\r\n")
+	case simpleString:
+		w.WriteString("+" + string(v) + "\r\n")
+	case errorReply:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		writeBulk(w, []byte(v))
+	case []byte:
+		writeBulk(w, v)
+	case []string:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for i := range v {
+			writeBulk(w, []byte(v[i]))
+		}
+	case [][]byte:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for i := range v {
+			writeReply(w, v[i])
+		}
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for i := range v {
+			writeReply(w, v[i])
+		}
+	case pushes:
+		for i := range v {
+			writeReply(w, v[i])
+		}
+	default:
+		panic(fmt.Sprintf("redistest: unsupported reply %T", reply))
+	}
+}
+
+func writeBulk(w *bufio.Writer, b []byte) {
+	if b == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,642 @@
+package redistest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewServer starts a Redis protocol server on a random local port, it panics when it cannot listen.
+// The server keeps everything in memory and supports the commands used by the helper packages:
+// strings, keys with TTL, MULTI/EXEC/WATCH, hashes, lists, sorted sets, streams, bitmaps,
+// hyperloglogs, which count exactly, pub/sub, SELECT and AUTH.
+// Lua scripts run as the Go functions registered for them by RegisterScript.
+// It can also act as a replica with SetReadOnly, as a sentinel with SetSentinelMaster and as a cluster node with SetClusterSlots.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("redistest: failed to listen: " + err.Error())
+	}
+	return newServer(l)
+}
+
+// NewTLSServer starts a server as NewServer does, whose connections are secured by config
+func NewTLSServer(config *tls.Config) *Server {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		panic("redistest: failed to listen: " + err.Error())
+	}
+	return newServer(l)
+}
+
+// NewUnixServer starts a server as NewServer does, listening on the unix socket at path, whose Addr is path
+func NewUnixServer(path string) *Server {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		panic("redistest: failed to listen: " + err.Error())
+	}
+	return newServer(l)
+}
+
+func newServer(l net.Listener) *Server {
+	s := &Server{
+		l:       l,
+		dbs:     map[int]*db{},
+		clients: map[*client]bool{},
+		scripts: map[string]bool{},
+		notify:  make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+type Server struct {
+	l        net.Listener
+	mu       sync.Mutex
+	dbs      map[int]*db
+	version  uint64
+	password string
+	offset   time.Duration
+	clients  map[*client]bool
+	// users are the ACL users added by AddUser, by name
+	users map[string]string
+	// scripts holds the SHA1 of the scripts loaded by SCRIPT LOAD or EVAL
+	scripts map[string]bool
+	// readOnly makes the server act as a replica
+	readOnly bool
+	// sentinelMasters are the masters reported when the server acts as a sentinel
+	sentinelMasters map[string]sentinelMaster
+	// clusterSlots makes the server a cluster node, it serves the keys of the slots assigned to its address
+	clusterSlots []SlotRange
+	// migrating are the slots moving to other nodes, by slot
+	migrating map[int]string
+	// injected are the error replies of the next commands, by command name
+	injected map[string]*injectedError
+	// notify is closed and replaced on every write, to wake up blocking commands
+	notify chan struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Addr returns host:port of the server
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *Server) Host() string {
+	return s.l.Addr().(*net.TCPAddr).IP.String()
+}
+
+func (s *Server) Port() int {
+	return s.l.Addr().(*net.TCPAddr).Port
+}
+
+// RequirePassword makes new connections authenticate with AUTH password before other commands
+func (s *Server) RequirePassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// AddUser adds an ACL user, which authenticates with AUTH username password.
+// Connections must authenticate once a user or a password is set.
+func (s *Server) AddUser(username string, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		s.users = map[string]string{}
+	}
+	s.users[username] = password
+}
+
+// InjectError makes the next times commands named name, as INCR or EXEC, fail with the error reply err without running,
+// to test how clients handle transient errors as LOADING or TRYAGAIN
+func (s *Server) InjectError(name string, err string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.injected == nil {
+		s.injected = map[string]*injectedError{}
+	}
+	s.injected[strings.ToUpper(name)] = &injectedError{reply: errorReply(err), times: times}
+}
+
+type injectedError struct {
+	reply errorReply
+	times int
+}
+
+// injectedError returns the error injected for the command, s.mu must be held
+func (s *Server) injectedError(name string) interface{} {
+	e, ok := s.injected[name]
+	if !ok || e.times <= 0 {
+		return nil
+	}
+	e.times--
+	return e.reply
+}
+
+// FastForward moves the clock of the server forward, so TTLs can be tested without sleeping
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+	s.touchAll()
+}
+
+// FlushAll removes all keys of all databases
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.dbs {
+		d.flush(s)
+	}
+}
+
+// DropConnections closes all connections while the server keeps listening, to test reconnecting clients
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		_ = c.conn.Close()
+	}
+}
+
+// Close stops listening and closes all connections
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	_ = s.l.Close()
+	for c := range s.clients {
+		_ = c.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (s *Server) db(i int) *db {
+	d, ok := s.dbs[i]
+	if !ok {
+		d = newDB()
+		s.dbs[i] = d
+	}
+	return d
+}
+
+// touch marks key as modified for WATCH and wakes up blocking commands
+func (s *Server) touch(d *db, key string) {
+	s.version++
+	d.versions[key] = s.version
+	s.wakeUp()
+}
+
+func (s *Server) touchAll() {
+	for _, d := range s.dbs {
+		for key := range d.items {
+			s.version++
+			d.versions[key] = s.version
+		}
+	}
+	s.wakeUp()
+}
+
+func (s *Server) wakeUp() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &client{
+			s:    s,
+			conn: conn,
+			r:    bufio.NewReader(conn),
+			w:    bufio.NewWriter(conn),
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		c.authed = s.password == "" && len(s.users) == 0
+		s.clients[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+
+			s.mu.Lock()
+			delete(s.clients, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+type watchKey struct {
+	db  int
+	key string
+}
+
+type client struct {
+	s      *Server
+	conn   net.Conn
+	r      *bufio.Reader
+	wmu    sync.Mutex
+	w      *bufio.Writer
+	db     int
+	authed bool
+	// asking is set by ASKING, for the next command or transaction
+	asking bool
+
+	multi    bool
+	queued   [][][]byte
+	multiErr bool
+	watched  map[watchKey]uint64
+
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+func (c *client) serve() {
+	defer c.conn.Close()
+
+	for {
+		args, err := readCommand(c.r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToUpper(string(args[0]))
+		if name == "QUIT" {
+			c.write(okReply)
+			return
+		}
+
+		c.write(c.execute(name, args[1:]))
+	}
+}
+
+func (c *client) write(reply interface{}) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	writeReply(c.w, reply)
+	_ = c.w.Flush()
+}
+
+func (c *client) subscribed() bool {
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+func (c *client) execute(name string, args [][]byte) interface{} {
+	cmd, exists := commands[name]
+
+	asking := c.asking
+	if name != "ASKING" && name != "MULTI" && !c.multi {
+		c.asking = false
+	}
+
+	c.s.mu.Lock()
+	authed, readOnly := c.authed, c.s.readOnly
+	redirect := c.redirect(name, args, asking)
+	injected := c.s.injectedError(name)
+	c.s.mu.Unlock()
+
+	if injected != nil {
+		if name == "EXEC" {
+			c.resetMulti()
+		} else if c.multi {
+			c.multiErr = true
+		}
+		return injected
+	}
+
+	if c.multi {
+		switch name {
+		case "EXEC", "DISCARD", "MULTI", "WATCH":
+		default:
+			if !exists {
+				c.multiErr = true
+				return errorf("ERR unknown command '%s'", strings.ToLower(name))
+			}
+			if !cmd.arity.valid(len(args)) {
+				c.multiErr = true
+				return errWrongArgs(name)
+			}
+			if readOnly && !readOnlyCommands[name] {
+				c.multiErr = true
+				return errReadOnly
+			}
+			if redirect != nil {
+				c.multiErr = true
+				return redirect
+			}
+			c.queued = append(c.queued, append([][]byte{[]byte(name)}, args...))
+			return simpleString("QUEUED")
+		}
+	}
+
+	if !exists {
+		return errorf("ERR unknown command '%s'", strings.ToLower(name))
+	}
+	if !cmd.arity.valid(len(args)) {
+		return errWrongArgs(name)
+	}
+
+	if !authed && name != "AUTH" && name != "HELLO" {
+		return errNotAuthed
+	}
+
+	if readOnly && !readOnlyCommands[name] {
+		return errReadOnly
+	}
+
+	if redirect != nil {
+		return redirect
+	}
+
+	if c.subscribed() {
+		switch name {
+		case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "PING", "RESET":
+		default:
+			return errorf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(name))
+		}
+	}
+
+	switch name {
+	case "MULTI":
+		if c.multi {
+			return errorReply("ERR MULTI calls can not be nested")
+		}
+		c.multi = true
+		c.queued = nil
+		c.multiErr = false
+		return okReply
+	case "DISCARD":
+		if !c.multi {
+			return errorReply("ERR DISCARD without MULTI")
+		}
+		c.resetMulti()
+		return okReply
+	case "EXEC":
+		return c.exec()
+	case "WATCH":
+		if c.multi {
+			return errorReply("ERR WATCH inside MULTI is not allowed")
+		}
+	}
+
+	if cmd.blocking != nil {
+		return c.block(cmd, args)
+	}
+
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	return cmd.handle(c, args)
+}
+
+func (c *client) resetMulti() {
+	c.asking = false
+	c.multi = false
+	c.queued = nil
+	c.multiErr = false
+	c.watched = nil
+}
+
+func (c *client) exec() interface{} {
+	if !c.multi {
+		return errorReply("ERR EXEC without MULTI")
+	}
+
+	queued, multiErr, watched := c.queued, c.multiErr, c.watched
+	c.resetMulti()
+
+	if multiErr {
+		return errorReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	for k, version := range watched {
+		d := c.s.db(k.db)
+		d.expire(c.s, k.key)
+		if d.versions[k.key] != version {
+			return nullArray{}
+		}
+	}
+
+	replies := make([]interface{}, 0, len(queued))
+	for _, args := range queued {
+		name := string(args[0])
+		cmd := commands[name]
+		replies = append(replies, cmd.handle(c, args[1:]))
+	}
+	return replies
+}
+
+// block runs a blocking command until it gets a reply or its timeout is reached, a negative timeout runs it once
+func (c *client) block(cmd command, args [][]byte) interface{} {
+	timeout, errReply := cmd.blocking(args)
+	if errReply != nil {
+		return errReply
+	}
+
+	if timeout < 0 {
+		c.s.mu.Lock()
+		defer c.s.mu.Unlock()
+		return cmd.handle(c, args)
+	}
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+
+	for {
+		c.s.mu.Lock()
+		reply := cmd.handle(c, args)
+		notify, closed := c.s.notify, c.s.closed
+		c.s.mu.Unlock()
+
+		if _, wait := reply.(nullArray); !wait || closed {
+			return reply
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return nullArray{}
+		}
+	}
+}
+
+type arity struct {
+	min int
+	// max < 0 means no limit
+	max int
+}
+
+func (a arity) valid(n int) bool {
+	return n >= a.min && (a.max < 0 || n <= a.max)
+}
+
+type command struct {
+	arity  arity
+	handle func(c *client, args [][]byte) interface{}
+	// blocking parses the timeout of blocking commands, whose handlers return nullArray while they should wait
+	blocking func(args [][]byte) (time.Duration, interface{})
+}
+
+var commands = map[string]command{}
+
+func register(name string, min int, max int, handle func(c *client, args [][]byte) interface{}) {
+	commands[name] = command{arity: arity{min: min, max: max}, handle: handle}
+}
+
+func registerBlocking(name string, min int, max int, handle func(c *client, args [][]byte) interface{}, timeout func(args [][]byte) (time.Duration, interface{})) {
+	commands[name] = command{arity: arity{min: min, max: max}, handle: handle, blocking: timeout}
+}
+
+func init() {
+	register("PING", 0, 1, func(c *client, args [][]byte) interface{} {
+		if c.subscribed() {
+			msg := []byte("")
+			if len(args) == 1 {
+				msg = args[0]
+			}
+			return []interface{}{"pong", msg}
+		}
+		if len(args) == 1 {
+			return args[0]
+		}
+		return simpleString("PONG")
+	})
+
+	register("ECHO", 1, 1, func(c *client, args [][]byte) interface{} {
+		return args[0]
+	})
+
+	// AUTH [username] password
+	register("AUTH", 1, 2, func(c *client, args [][]byte) interface{} {
+		password := string(args[len(args)-1])
+		if len(args) == 2 && string(args[0]) != "default" {
+			if p, ok := c.s.users[string(args[0])]; !ok || p != password {
+				return errInvalidAuth
+			}
+			c.authed = true
+			return okReply
+		}
+		if c.s.password == "" {
+			return errorReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+		if password != c.s.password {
+			return errInvalidAuth
+		}
+		c.authed = true
+		return okReply
+	})
+
+	register("SELECT", 1, 1, func(c *client, args [][]byte) interface{} {
+		i, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return errNotInteger
+		}
+		if i < 0 || i >= 16 {
+			return errInvalidIndex
+		}
+		c.db = i
+		return okReply
+	})
+
+	register("WATCH", 1, -1, func(c *client, args [][]byte) interface{} {
+		if c.watched == nil {
+			c.watched = map[watchKey]uint64{}
+		}
+		d := c.s.db(c.db)
+		for _, key := range args {
+			d.expire(c.s, string(key))
+			c.watched[watchKey{db: c.db, key: string(key)}] = d.versions[string(key)]
+		}
+		return okReply
+	})
+
+	register("UNWATCH", 0, 0, func(c *client, args [][]byte) interface{} {
+		c.watched = nil
+		return okReply
+	})
+
+	register("MULTI", 0, 0, nil)
+	register("EXEC", 0, 0, nil)
+	register("DISCARD", 0, 0, nil)
+
+	register("TIME", 0, 0, func(c *client, args [][]byte) interface{} {
+		now := c.s.now()
+		return []string{strconv.FormatInt(now.Unix(), 10), strconv.Itoa(now.Nanosecond() / 1000)}
+	})
+
+	register("INFO", 0, -1, func(c *client, args [][]byte) interface{} {
+		keys := 0
+		for _, d := range c.s.dbs {
+			keys += len(d.items)
+		}
+		mode, role := "standalone", "master"
+		if c.s.clusterSlots != nil {
+			mode = "cluster"
+		}
+		if c.s.readOnly {
+			role = "slave"
+		}
+		return strings.Join([]string{
+			"# Server",
+			"redis_version:7.0.0",
+			"redis_mode:" + mode,
+			"",
+			"# Clients",
+			"connected_clients:" + strconv.Itoa(len(c.s.clients)),
+			"",
+			"# Memory",
+			"used_memory:0",
+			"used_memory_human:0B",
+			"maxmemory:0",
+			"",
+			"# Replication",
+			"role:" + role,
+			"connected_slaves:0",
+			"",
+			"# Keyspace",
+			"keys:" + strconv.Itoa(keys),
+			"",
+		}, "\r\n")
+	})
+}
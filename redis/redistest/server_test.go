@@ -0,0 +1,267 @@
+package redistest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+)
+
+func dial(t *testing.T, s *Server) redis.Conn {
+	c, err := redis.Dial("tcp", s.Addr())
+	NewWithT(t).Expect(err).To(BeNil())
+	return c
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	c := dial(t, s)
+	defer c.Close()
+
+	t.Run("strings with ttl", func(t *testing.T) {
+		NewWithT(t).Expect(redis.String(c.Do("SET", "key", "1", "EX", 10))).To(Equal("OK"))
+		NewWithT(t).Expect(redis.String(c.Do("SET", "key", "2", "NX"))).Error().To(Equal(redis.ErrNil))
+		NewWithT(t).Expect(redis.Int(c.Do("INCR", "key"))).To(Equal(2))
+		NewWithT(t).Expect(redis.Int(c.Do("TTL", "key"))).To(Equal(10))
+
+		s.FastForward(11 * time.Second)
+		NewWithT(t).Expect(redis.Bool(c.Do("EXISTS", "key"))).To(BeFalse())
+	})
+
+	t.Run("select", func(t *testing.T) {
+		NewWithT(t).Expect(c.Do("SET", "db", "0")).To(Equal("OK"))
+		NewWithT(t).Expect(c.Do("SELECT", 1)).To(Equal("OK"))
+		NewWithT(t).Expect(c.Do("GET", "db")).To(BeNil())
+		NewWithT(t).Expect(c.Do("SELECT", 0)).To(Equal("OK"))
+		NewWithT(t).Expect(redis.String(c.Do("GET", "db"))).To(Equal("0"))
+	})
+
+	t.Run("multi", func(t *testing.T) {
+		NewWithT(t).Expect(c.Send("MULTI")).To(BeNil())
+		NewWithT(t).Expect(c.Send("SET", "m", "1")).To(BeNil())
+		NewWithT(t).Expect(c.Send("HSET", "m", "f", "1")).To(BeNil())
+		NewWithT(t).Expect(c.Send("GET", "m")).To(BeNil())
+		values, err := redis.Values(c.Do("EXEC"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(values[0]).To(Equal("OK"))
+		NewWithT(t).Expect(values[1]).To(BeAssignableToTypeOf(redis.Error("")))
+		NewWithT(t).Expect(values[2]).To(Equal([]byte("1")))
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		other := dial(t, s)
+		defer other.Close()
+
+		NewWithT(t).Expect(c.Do("WATCH", "w")).To(Equal("OK"))
+		NewWithT(t).Expect(other.Do("SET", "w", "1")).To(Equal("OK"))
+		NewWithT(t).Expect(c.Send("MULTI")).To(BeNil())
+		NewWithT(t).Expect(c.Send("SET", "w", "2")).To(BeNil())
+		NewWithT(t).Expect(c.Do("EXEC")).To(BeNil())
+	})
+
+	t.Run("hashes", func(t *testing.T) {
+		NewWithT(t).Expect(redis.Int(c.Do("HSET", "h", "a", "1", "b", "2"))).To(Equal(2))
+		NewWithT(t).Expect(redis.StringMap(c.Do("HGETALL", "h"))).To(Equal(map[string]string{"a": "1", "b": "2"}))
+		NewWithT(t).Expect(redis.Int(c.Do("HDEL", "h", "a", "b"))).To(Equal(2))
+		NewWithT(t).Expect(redis.Bool(c.Do("EXISTS", "h"))).To(BeFalse())
+	})
+
+	t.Run("lists", func(t *testing.T) {
+		NewWithT(t).Expect(redis.Int(c.Do("RPUSH", "l", "a", "b", "c"))).To(Equal(3))
+		NewWithT(t).Expect(redis.String(c.Do("LMOVE", "l", "p", "LEFT", "RIGHT"))).To(Equal("a"))
+		NewWithT(t).Expect(redis.Strings(c.Do("LRANGE", "l", 0, -1))).To(Equal([]string{"b", "c"}))
+
+		other := dial(t, s)
+		defer other.Close()
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			_, _ = other.Do("RPUSH", "blocking", "x")
+		}()
+		NewWithT(t).Expect(redis.String(c.Do("BLMOVE", "blocking", "p", "LEFT", "RIGHT", 1))).To(Equal("x"))
+		NewWithT(t).Expect(c.Do("BLMOVE", "blocking", "p", "LEFT", "RIGHT", 0.01)).To(BeNil())
+	})
+
+	t.Run("sorted sets", func(t *testing.T) {
+		NewWithT(t).Expect(redis.Int(c.Do("ZADD", "z", 3, "c", 1, "a", 2, "b"))).To(Equal(3))
+		NewWithT(t).Expect(redis.Strings(c.Do("ZRANGE", "z", 0, -1))).To(Equal([]string{"a", "b", "c"}))
+		NewWithT(t).Expect(redis.Strings(c.Do("ZRANGEBYSCORE", "z", "(1", "+inf", "WITHSCORES", "LIMIT", 0, 1))).To(Equal([]string{"b", "2"}))
+		NewWithT(t).Expect(redis.Int(c.Do("ZREMRANGEBYSCORE", "z", "-inf", 2))).To(Equal(2))
+		NewWithT(t).Expect(redis.Int(c.Do("ZCARD", "z"))).To(Equal(1))
+	})
+
+	t.Run("streams", func(t *testing.T) {
+		NewWithT(t).Expect(c.Do("XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")).To(Equal("OK"))
+		for i := 1; i <= 3; i++ {
+			NewWithT(t).Expect(redis.String(c.Do("XADD", "s", "MAXLEN", "~", 2, fmt.Sprintf("%d-0", i), "n", i))).To(Equal(fmt.Sprintf("%d-0", i)))
+		}
+		NewWithT(t).Expect(redis.Int(c.Do("XLEN", "s"))).To(Equal(2))
+
+		values, err := redis.Values(c.Do("XREADGROUP", "GROUP", "g", "a", "COUNT", 1, "STREAMS", "s", ">"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(fmt.Sprintf("%s", values)).To(Equal("[[s [[2-0 [n 2]]]]]"))
+
+		NewWithT(t).Expect(c.Do("XREADGROUP", "GROUP", "g", "a", "STREAMS", "s", ">")).NotTo(BeNil())
+		NewWithT(t).Expect(c.Do("XREADGROUP", "GROUP", "g", "a", "BLOCK", 10, "STREAMS", "s", ">")).To(BeNil())
+
+		s.FastForward(time.Second)
+		values, err = redis.Values(c.Do("XAUTOCLAIM", "s", "g", "b", 500, "0-0", "JUSTID"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(fmt.Sprintf("%s", values)).To(Equal("[0-0 [2-0 3-0] []]"))
+
+		NewWithT(t).Expect(redis.Int(c.Do("XACK", "s", "g", "2-0", "3-0"))).To(Equal(2))
+		NewWithT(t).Expect(redis.Values(c.Do("XPENDING", "s", "g", "-", "+", 10))).To(BeEmpty())
+	})
+
+	t.Run("bitmaps and hyperloglogs", func(t *testing.T) {
+		NewWithT(t).Expect(redis.Int(c.Do("SETBIT", "bits", 9, 1))).To(Equal(0))
+		NewWithT(t).Expect(redis.Int(c.Do("SETBIT", "bits", 9, 1))).To(Equal(1))
+		NewWithT(t).Expect(redis.Int(c.Do("GETBIT", "bits", 9))).To(Equal(1))
+		NewWithT(t).Expect(redis.Int(c.Do("GETBIT", "bits", 100))).To(Equal(0))
+		NewWithT(t).Expect(redis.Bytes(c.Do("GET", "bits"))).To(Equal([]byte{0, 0x40}))
+		NewWithT(t).Expect(redis.Int(c.Do("BITCOUNT", "bits"))).To(Equal(1))
+
+		NewWithT(t).Expect(redis.Int(c.Do("PFADD", "hll1", "a", "b"))).To(Equal(1))
+		NewWithT(t).Expect(redis.Int(c.Do("PFADD", "hll1", "a"))).To(Equal(0))
+		NewWithT(t).Expect(redis.Int(c.Do("PFADD", "hll2", "b", "c"))).To(Equal(1))
+		NewWithT(t).Expect(redis.Int(c.Do("PFCOUNT", "hll1", "hll2"))).To(Equal(3))
+		NewWithT(t).Expect(c.Do("PFMERGE", "hll3", "hll1", "hll2")).To(Equal("OK"))
+		NewWithT(t).Expect(redis.Int(c.Do("PFCOUNT", "hll3"))).To(Equal(3))
+		NewWithT(t).Expect(redis.String(c.Do("TYPE", "hll3"))).To(Equal("string"))
+
+		_, err := c.Do("PFADD", "bits", "a")
+		NewWithT(t).Expect(err).To(MatchError(ContainSubstring("WRONGTYPE")))
+	})
+
+	t.Run("scripting", func(t *testing.T) {
+		script := redis.NewScript(1, "return redis.call('INCRBY', KEYS[1], ARGV[1])")
+		RegisterScript("return redis.call('INCRBY', KEYS[1], ARGV[1])", func(call func(name string, args ...interface{}) interface{}, keys []string, args [][]byte) interface{} {
+			return call("INCRBY", keys[0], args[0])
+		})
+
+		NewWithT(t).Expect(redis.Ints(c.Do("SCRIPT", "EXISTS", script.Hash()))).To(Equal([]int{0}))
+		_, err := c.Do("EVALSHA", script.Hash(), 1, "counter", 2)
+		NewWithT(t).Expect(err).To(MatchError(ContainSubstring("NOSCRIPT")))
+
+		// EVALSHA then EVAL, then EVALSHA once loaded
+		NewWithT(t).Expect(redis.Int(script.Do(c, "counter", 2))).To(Equal(2))
+		NewWithT(t).Expect(redis.Int(c.Do("EVALSHA", script.Hash(), 1, "counter", 3))).To(Equal(5))
+
+		NewWithT(t).Expect(c.Do("SCRIPT", "FLUSH")).To(Equal("OK"))
+		NewWithT(t).Expect(redis.Ints(c.Do("SCRIPT", "EXISTS", script.Hash()))).To(Equal([]int{0}))
+
+		_, err = c.Do("EVAL", "return 1", 0)
+		NewWithT(t).Expect(err).To(MatchError(ContainSubstring("no ScriptFunc registered")))
+	})
+
+	t.Run("scan", func(t *testing.T) {
+		NewWithT(t).Expect(c.Do("FLUSHDB")).To(Equal("OK"))
+		for _, key := range []string{"a:1", "a:2", "b:1"} {
+			NewWithT(t).Expect(c.Do("SET", key, "1")).To(Equal("OK"))
+		}
+
+		keys := make([]string, 0)
+		cursor := 0
+		for {
+			values, err := redis.Values(c.Do("SCAN", cursor, "MATCH", "a:*", "COUNT", 1))
+			NewWithT(t).Expect(err).To(BeNil())
+			cursor, _ = redis.Int(values[0], nil)
+			found, _ := redis.Strings(values[1], nil)
+			keys = append(keys, found...)
+			if cursor == 0 {
+				break
+			}
+		}
+		NewWithT(t).Expect(keys).To(Equal([]string{"a:1", "a:2"}))
+	})
+
+	t.Run("pubsub", func(t *testing.T) {
+		psc := redis.PubSubConn{Conn: dial(t, s)}
+		defer psc.Close()
+
+		NewWithT(t).Expect(psc.Subscribe("news")).To(BeNil())
+		NewWithT(t).Expect(psc.PSubscribe("user.*")).To(BeNil())
+		NewWithT(t).Expect(psc.Receive()).To(BeAssignableToTypeOf(redis.Subscription{}))
+		NewWithT(t).Expect(psc.Receive()).To(BeAssignableToTypeOf(redis.Subscription{}))
+
+		NewWithT(t).Expect(redis.Int(c.Do("PUBLISH", "user.1", "hi"))).To(Equal(1))
+
+		msg, ok := psc.Receive().(redis.Message)
+		NewWithT(t).Expect(ok).To(BeTrue())
+		NewWithT(t).Expect(msg.Pattern).To(Equal("user.*"))
+		NewWithT(t).Expect(string(msg.Data)).To(Equal("hi"))
+	})
+
+	t.Run("auth", func(t *testing.T) {
+		s.RequirePassword("secret")
+		defer s.RequirePassword("")
+
+		_, err := redis.Dial("tcp", s.Addr())
+		NewWithT(t).Expect(err).To(BeNil())
+
+		_, err = redis.Dial("tcp", s.Addr(), redis.DialPassword("wrong"))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		authed, err := redis.Dial("tcp", s.Addr(), redis.DialPassword("secret"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(authed.Do("PING")).To(Equal("PONG"))
+		authed.Close()
+
+		s.AddUser("app", "app-secret")
+		_, err = redis.Dial("tcp", s.Addr(), redis.DialUsername("app"), redis.DialPassword("secret"))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		user, err := redis.Dial("tcp", s.Addr(), redis.DialUsername("app"), redis.DialPassword("app-secret"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(user.Do("PING")).To(Equal("PONG"))
+		user.Close()
+	})
+
+	t.Run("replica and sentinel", func(t *testing.T) {
+		role, err := redis.Values(c.Do("ROLE"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(role[0]).To(Equal([]byte("master")))
+
+		s.SetReadOnly(true)
+		_, err = c.Do("SET", "replica", "1")
+		NewWithT(t).Expect(err).To(MatchError(HavePrefix("READONLY")))
+		NewWithT(t).Expect(c.Do("GET", "replica")).To(BeNil())
+		s.SetReadOnly(false)
+
+		s.SetSentinelMaster("mymaster", "127.0.0.1:6379", "127.0.0.1:6380")
+		NewWithT(t).Expect(redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", "mymaster"))).To(Equal([]string{"127.0.0.1", "6379"}))
+		NewWithT(t).Expect(c.Do("SENTINEL", "get-master-addr-by-name", "other")).To(BeNil())
+
+		replicas, err := redis.Values(c.Do("SENTINEL", "replicas", "mymaster"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(replicas).To(HaveLen(1))
+		fields, err := redis.StringMap(replicas[0], nil)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(fields["port"]).To(Equal("6380"))
+	})
+
+	t.Run("cluster", func(t *testing.T) {
+		s.SetClusterSlots(SlotRange{Start: 0, End: 12181, Addr: s.Addr()}, SlotRange{Start: 12182, End: 16383, Addr: "127.0.0.1:7000"})
+		defer s.SetClusterSlots()
+
+		NewWithT(t).Expect(redis.Int(c.Do("CLUSTER", "KEYSLOT", "{foo}.bar"))).To(Equal(12182))
+
+		_, err := c.Do("SET", "foo", "1")
+		NewWithT(t).Expect(err).To(MatchError("MOVED 12182 127.0.0.1:7000"))
+		NewWithT(t).Expect(c.Do("ASKING")).To(Equal("OK"))
+		NewWithT(t).Expect(c.Do("SET", "foo", "1")).To(Equal("OK"))
+
+		s.SetMigrating(0, "127.0.0.1:7001")
+		defer s.SetMigrating(0, "")
+		_, err = c.Do("GET", "3560")
+		NewWithT(t).Expect(err).To(MatchError("ASK 0 127.0.0.1:7001"))
+
+		slots, err := redis.Values(c.Do("CLUSTER", "SLOTS"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(slots).To(HaveLen(2))
+	})
+}
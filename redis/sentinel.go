@@ -0,0 +1,276 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrNoMaster is returned when none of the sentinels knows the master
+var ErrNoMaster = errors.New("redis: no sentinel knows the master")
+
+// ErrNoReplica is returned when the sentinels know no healthy replica of the master
+var ErrNoReplica = errors.New("redis: no replica available")
+
+// errStaleMaster drops the pooled connections to a master the sentinels no longer report
+var errStaleMaster = errors.New("redis: connection to a former master")
+
+// sentinel discovers the master named masterName and its replicas through the sentinels at addrs.
+// The sentinels are asked without holding mu, so the connections to the known master don't wait for them.
+type sentinel struct {
+	masterName string
+	// dial connects to a sentinel, with the TLS settings and the sentinel password of the endpoint
+	dial func(addr string) (redis.Conn, error)
+
+	mu sync.Mutex
+	// addrs are the sentinels, the last one which answered comes first
+	addrs []string
+	// master is the address of the current master, empty until discovered
+	master string
+}
+
+// newSentinel creates a sentinel asking the sentinel at host then the ones of the comma separated others, connected by dial
+func newSentinel(host string, others string, masterName string, dial func(addr string) (redis.Conn, error)) *sentinel {
+	addrs := []string{host}
+	for _, addr := range strings.Split(others, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" && addr != host {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return &sentinel{
+		masterName: masterName,
+		dial:       dial,
+		addrs:      addrs,
+	}
+}
+
+// masterAddr returns the address of the master, asking the sentinels when it isn't known
+func (s *sentinel) masterAddr() (string, error) {
+	if master := s.current(); master != "" {
+		return master, nil
+	}
+
+	var lastErr error = ErrNoMaster
+	for _, addr := range s.sentinels() {
+		reply, err := s.do(addr, "SENTINEL", "get-master-addr-by-name", s.masterName)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		hostPort, err := redis.Strings(reply, nil)
+		if err != nil || len(hostPort) != 2 {
+			continue
+		}
+
+		master := net.JoinHostPort(hostPort[0], hostPort[1])
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.promote(addr)
+		s.master = master
+		return master, nil
+	}
+	return "", fmt.Errorf("redis: discover master %s: %w", s.masterName, lastErr)
+}
+
+// sentinels returns a copy of the addresses of the sentinels, to ask them without holding mu
+func (s *sentinel) sentinels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.addrs...)
+}
+
+// current returns the address of the master without asking the sentinels, empty when it must be discovered again
+func (s *sentinel) current() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.master
+}
+
+// invalidate forgets the master when it is addr, so the next connection asks the sentinels again
+func (s *sentinel) invalidate(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.master == addr {
+		s.master = ""
+	}
+}
+
+// replicaAddrs returns the addresses of the healthy replicas of the master
+func (s *sentinel) replicaAddrs() ([]string, error) {
+	var lastErr error = ErrNoReplica
+	for _, addr := range s.sentinels() {
+		reply, err := s.do(addr, "SENTINEL", "replicas", s.masterName)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		s.mu.Lock()
+		s.promote(addr)
+		s.mu.Unlock()
+
+		addrs := make([]string, 0, len(values))
+		for _, v := range values {
+			fields, err := redis.StringMap(v, nil)
+			if err != nil {
+				continue
+			}
+			if flags := fields["flags"]; strings.Contains(flags, "s_down") || strings.Contains(flags, "o_down") || strings.Contains(flags, "disconnected") {
+				continue
+			}
+			if fields["master-link-status"] == "err" {
+				continue
+			}
+			addrs = append(addrs, net.JoinHostPort(fields["ip"], fields["port"]))
+		}
+		if len(addrs) == 0 {
+			return nil, ErrNoReplica
+		}
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("redis: discover replicas of %s: %w", s.masterName, lastErr)
+}
+
+// promote moves the sentinel at addr first, to be asked first next time, mu must be held
+func (s *sentinel) promote(addr string) {
+	for i := range s.addrs {
+		if s.addrs[i] == addr {
+			s.addrs[0], s.addrs[i] = s.addrs[i], s.addrs[0]
+			return
+		}
+	}
+}
+
+func (s *sentinel) do(addr string, cmd string, args ...interface{}) (interface{}, error) {
+	c, err := s.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.Do(cmd, args...)
+}
+
+// dialMaster connects to the master, asking the sentinels again once when the address is unreachable or no longer a master
+func (s *sentinel) dialMaster(dial func(addr string) (redis.Conn, error)) (redis.Conn, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var addr string
+		addr, err = s.masterAddr()
+		if err != nil {
+			return nil, err
+		}
+
+		var c redis.Conn
+		c, err = dial(addr)
+		if err == nil {
+			err = checkRole(c, "master")
+			if err == nil {
+				return &sentinelConn{Conn: c, addr: addr}, nil
+			}
+			_ = c.Close()
+		}
+		s.invalidate(addr)
+	}
+	return nil, err
+}
+
+// dialReplica connects to a random healthy replica
+func (s *sentinel) dialReplica(dial func(addr string) (redis.Conn, error)) (redis.Conn, error) {
+	addrs, err := s.replicaAddrs()
+	if err != nil {
+		return nil, err
+	}
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	for _, addr := range addrs {
+		var c redis.Conn
+		c, err = dial(addr)
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// testOnBorrow drops the pooled connections to a former master
+func (s *sentinel) testOnBorrow(c redis.Conn, _ time.Time) error {
+	if sc, ok := c.(*sentinelConn); ok && sc.addr != s.current() {
+		return errStaleMaster
+	}
+	return nil
+}
+
+func checkRole(c redis.Conn, want string) error {
+	values, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("redis: empty ROLE reply")
+	}
+	role, err := redis.String(values[0], nil)
+	if err != nil {
+		return err
+	}
+	if role != want {
+		return fmt.Errorf("redis: role is %s instead of %s", role, want)
+	}
+	return nil
+}
+
+// isFailover reports whether err tells the master moved: the connection failed or the server is a replica now
+func isFailover(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(redis.Error); ok {
+		return isReadOnly(e)
+	}
+	return isConnError(err)
+}
+
+func isReadOnly(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(e), "READONLY")
+}
+
+// sentinelConn is a connection to the master at addr
+type sentinelConn struct {
+	redis.Conn
+	addr string
+}
+
+var (
+	_ redis.ConnWithContext = (*sentinelConn)(nil)
+	_ redis.ConnWithTimeout = (*sentinelConn)(nil)
+)
+
+func (c *sentinelConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	return redis.DoContext(c.Conn, ctx, cmd, args...)
+}
+
+func (c *sentinelConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return redis.ReceiveContext(c.Conn, ctx)
+}
+
+func (c *sentinelConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+}
+
+func (c *sentinelConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-courier/envconf"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+func TestRedisEndpointSentinel(t *testing.T) {
+	sentinel := redistest.NewServer()
+	defer sentinel.Close()
+	master := redistest.NewServer()
+	defer master.Close()
+	replica := redistest.NewServer()
+	defer replica.Close()
+	replica.SetReadOnly(true)
+
+	// the first sentinel is down, the next one answers
+	down := redistest.NewServer()
+	down.Close()
+
+	sentinel.SetSentinelMaster("mymaster", master.Addr(), replica.Addr())
+
+	endpoint, err := envconf.ParseEndpoint(fmt.Sprintf("redis://%s?masterName=mymaster&sentinels=%s&replicaReads=true", down.Addr(), sentinel.Addr()))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	r := &RedisEndpoint{Endpoint: *endpoint}
+	r.Init()
+
+	t.Run("writes go to the master and reads to the replicas", func(t *testing.T) {
+		_, err := r.Exec(Command("SET", r.Prefix("sentinel"), "master"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		// the replica isn't synced by the test servers
+		replica.SetReadOnly(false)
+		writeTo(t, replica, r.Prefix("sentinel"), "replica")
+		replica.SetReadOnly(true)
+
+		v, err := redis.String(r.Exec(Command("GET", r.Prefix("sentinel"))))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal("replica"))
+
+		values, err := redis.Values(r.Exec(Command("SET", r.Prefix("sentinel"), "multi"), Command("GET", r.Prefix("sentinel"))))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(values[1]).To(Equal([]byte("multi")))
+	})
+
+	t.Run("re-resolves the master on READONLY", func(t *testing.T) {
+		master.SetReadOnly(true)
+		replica.SetReadOnly(false)
+		sentinel.SetSentinelMaster("mymaster", replica.Addr(), master.Addr())
+		defer func() {
+			master.SetReadOnly(false)
+			replica.SetReadOnly(true)
+			sentinel.SetSentinelMaster("mymaster", master.Addr(), replica.Addr())
+		}()
+
+		values, err := redis.Values(r.Exec(Command("INCR", r.Prefix("failover")), Command("INCR", r.Prefix("failover"))))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(values).To(Equal([]interface{}{int64(1), int64(2)}))
+
+		_, err = r.Exec(Command("INCR", r.Prefix("failover")))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		NewWithT(t).Expect(readFrom(t, replica, r.Prefix("failover"))).To(Equal("3"))
+	})
+
+	t.Run("re-resolves the master when its connections fail", func(t *testing.T) {
+		former := redistest.NewServer()
+		sentinel.SetSentinelMaster("mymaster", former.Addr())
+		r.sentinel.invalidate(r.sentinel.current())
+
+		_, err := r.Exec(Command("SET", r.Prefix("moved"), "1"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		former.Close()
+		sentinel.SetSentinelMaster("mymaster", master.Addr(), replica.Addr())
+
+		// INCR may have run before the connection failed, so it isn't retried on the new master
+		_, err = r.Exec(Command("INCR", r.Prefix("moved")))
+		NewWithT(t).Expect(err).NotTo(BeNil())
+
+		_, err = r.Exec(Command("SET", r.Prefix("moved"), "3"))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(readFrom(t, master, r.Prefix("moved"))).To(Equal("3"))
+	})
+
+	t.Run("reads fall back to the master without replicas", func(t *testing.T) {
+		replica.Close()
+
+		_, err := r.Exec(Command("SET", r.Prefix("fallback"), "master"))
+		NewWithT(t).Expect(err).To(BeNil())
+
+		v, err := redis.String(r.Exec(Command("GET", r.Prefix("fallback"))))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal("master"))
+	})
+}
+
+func TestSentinelLock(t *testing.T) {
+	dialing, release := make(chan struct{}), make(chan struct{})
+	s := newSentinel("127.0.0.1:26379", "", "mymaster", func(addr string) (redis.Conn, error) {
+		close(dialing)
+		<-release
+		return nil, errors.New("unreachable")
+	})
+
+	done := make(chan error)
+	go func() {
+		_, err := s.masterAddr()
+		done <- err
+	}()
+	<-dialing
+
+	// the sentinels are asked without holding the lock
+	NewWithT(t).Expect(s.current()).To(Equal(""))
+	s.invalidate("127.0.0.1:6379")
+
+	close(release)
+	NewWithT(t).Expect(<-done).To(MatchError(ContainSubstring("unreachable")))
+}
+
+func writeTo(t *testing.T, s *redistest.Server, key string, value string) {
+	c, err := redis.Dial("tcp", s.Addr(), redis.DialDatabase(10))
+	NewWithT(t).Expect(err).To(BeNil())
+	defer c.Close()
+	_, err = c.Do("SET", key, value)
+	NewWithT(t).Expect(err).To(BeNil())
+}
+
+func readFrom(t *testing.T, s *redistest.Server, key string) string {
+	c, err := redis.Dial("tcp", s.Addr(), redis.DialDatabase(10))
+	NewWithT(t).Expect(err).To(BeNil())
+	defer c.Close()
+	v, err := redis.String(c.Do("GET", key))
+	NewWithT(t).Expect(err).To(BeNil())
+	return v
+}
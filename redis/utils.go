@@ -2,10 +2,14 @@ package redis
 
 import (
 	"context"
+	"strconv"
+	"strings"
+
 	"github.com/gomodule/redigo/redis"
 )
 
 type Conn = redis.Conn
+type Error = redis.Error
 
 func Command(name string, args ...interface{}) *CMD {
 	return &CMD{
@@ -19,6 +23,14 @@ type CMD struct {
 	args []interface{}
 }
 
+func (c *CMD) Name() string {
+	return c.name
+}
+
+func (c *CMD) Args() []interface{} {
+	return c.args
+}
+
 type RedisOperator interface {
 	Prefix(key string) string
 	Get() Conn
@@ -26,3 +38,302 @@ type RedisOperator interface {
 	Exec(cmd *CMD, others ...*CMD) (interface{}, error)
 	ExecContext(ctx context.Context, cmd *CMD, others ...*CMD) (interface{}, error)
 }
+
+var keylessCommands = map[string]bool{
+	"AUTH":      true,
+	"HELLO":     true,
+	"PING":      true,
+	"ECHO":      true,
+	"INFO":      true,
+	"TIME":      true,
+	"DBSIZE":    true,
+	"FLUSHDB":   true,
+	"FLUSHALL":  true,
+	"SELECT":    true,
+	"SCRIPT":    true,
+	"PUBLISH":   true,
+	"SCAN":      true,
+	"RANDOMKEY": true,
+	"MULTI":     true,
+	"EXEC":      true,
+	"DISCARD":   true,
+	"UNWATCH":   true,
+}
+
+// keySpec gives the positions of the keys in the args of a command: from first to last, every step args,
+// last < 0 counts from the end of the args, as -1 for the last arg
+type keySpec struct {
+	first int
+	last  int
+	step  int
+}
+
+// keySpecs are the commands with other keys than their first arg
+var keySpecs = map[string]keySpec{
+	"DEL":         {0, -1, 1},
+	"UNLINK":      {0, -1, 1},
+	"EXISTS":      {0, -1, 1},
+	"TOUCH":       {0, -1, 1},
+	"WATCH":       {0, -1, 1},
+	"MGET":        {0, -1, 1},
+	"MSET":        {0, -1, 2},
+	"MSETNX":      {0, -1, 2},
+	"RENAME":      {0, 1, 1},
+	"RENAMENX":    {0, 1, 1},
+	"COPY":        {0, 1, 1},
+	"RPOPLPUSH":   {0, 1, 1},
+	"BRPOPLPUSH":  {0, 1, 1},
+	"LMOVE":       {0, 1, 1},
+	"BLMOVE":      {0, 1, 1},
+	"SMOVE":       {0, 1, 1},
+	"BLPOP":       {0, -2, 1},
+	"BRPOP":       {0, -2, 1},
+	"BZPOPMIN":    {0, -2, 1},
+	"BZPOPMAX":    {0, -2, 1},
+	"SDIFF":       {0, -1, 1},
+	"SDIFFSTORE":  {0, -1, 1},
+	"SINTER":      {0, -1, 1},
+	"SINTERSTORE": {0, -1, 1},
+	"SUNION":      {0, -1, 1},
+	"SUNIONSTORE": {0, -1, 1},
+	"PFCOUNT":     {0, -1, 1},
+	"PFMERGE":     {0, -1, 1},
+	"BITOP":       {1, -1, 1},
+	"XGROUP":      {1, 1, 1},
+	"XINFO":       {1, 1, 1},
+}
+
+// numKeysSpec gives the index of the number of keys followed by the keys, and whether a destination key comes first
+type numKeysSpec struct {
+	index int
+	dest  bool
+}
+
+// numKeysCommands are the commands whose keys follow their number
+var numKeysCommands = map[string]numKeysSpec{
+	"EVAL":        {1, false},
+	"EVALSHA":     {1, false},
+	"EVAL_RO":     {1, false},
+	"EVALSHA_RO":  {1, false},
+	"FCALL":       {1, false},
+	"FCALL_RO":    {1, false},
+	"ZUNIONSTORE": {1, true},
+	"ZINTERSTORE": {1, true},
+	"ZDIFFSTORE":  {1, true},
+	"ZUNION":      {0, false},
+	"ZINTER":      {0, false},
+	"ZDIFF":       {0, false},
+}
+
+// key returns the first key the command operates on
+func (c *CMD) key() (string, bool) {
+	keys := c.keys()
+	if len(keys) == 0 {
+		return "", false
+	}
+	return keys[0], true
+}
+
+// keys returns all the keys the command operates on, to route it by every one of them
+func (c *CMD) keys() []string {
+	indexes := c.keyIndexes()
+	if len(indexes) == 0 {
+		return nil
+	}
+	keys := make([]string, len(indexes))
+	for i, index := range indexes {
+		keys[i] = toKey(c.args[index])
+	}
+	return keys
+}
+
+// keyIndexes returns the positions of the keys in the args of the command
+func (c *CMD) keyIndexes() []int {
+	name := strings.ToUpper(c.name)
+	if keylessCommands[name] {
+		return nil
+	}
+
+	spec := keySpec{0, 0, 1}
+
+	if nk, ok := numKeysCommands[name]; ok {
+		// EVAL script numkeys key [key ...]
+		if len(c.args) <= nk.index {
+			return nil
+		}
+		n, err := strconv.Atoi(toKey(c.args[nk.index]))
+		if err != nil || n <= 0 {
+			return nil
+		}
+		indexes := c.argIndexes(keySpec{nk.index + 1, nk.index + n, 1})
+		if nk.dest {
+			indexes = append([]int{0}, indexes...)
+		}
+		return indexes
+	}
+
+	if name == "XREAD" || name == "XREADGROUP" {
+		// XREAD [COUNT count] [BLOCK ms] STREAMS key [key ...] id [id ...]
+		for i, arg := range c.args {
+			if strings.ToUpper(toKey(arg)) == "STREAMS" {
+				n := (len(c.args) - i - 1) / 2
+				return c.argIndexes(keySpec{i + 1, i + n, 1})
+			}
+		}
+		return nil
+	}
+
+	if s, ok := keySpecs[name]; ok {
+		spec = s
+	}
+
+	return c.argIndexes(spec)
+}
+
+func (c *CMD) argIndexes(spec keySpec) []int {
+	last := spec.last
+	if last < 0 {
+		last = len(c.args) + last
+	}
+	if last >= len(c.args) {
+		last = len(c.args) - 1
+	}
+
+	var indexes []int
+	for i := spec.first; i <= last; i += spec.step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// NewErrorConn returns a Conn whose commands fail with err, for the Get of operators which failed to get a connection
+func NewErrorConn(err error) Conn {
+	return errorConn{err: err}
+}
+
+// errorConn is returned instead of a nil Conn, so callers of Get get the error from the first command instead of a panic
+type errorConn struct {
+	err error
+}
+
+func (c errorConn) Close() error                                   { return nil }
+func (c errorConn) Err() error                                     { return c.err }
+func (c errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, c.err }
+func (c errorConn) Send(string, ...interface{}) error              { return c.err }
+func (c errorConn) Flush() error                                   { return c.err }
+func (c errorConn) Receive() (interface{}, error)                  { return nil, c.err }
+
+// readOnlyCommands are the commands which don't write, so they may run on replicas
+var readOnlyCommands = map[string]bool{
+	"PING":             true,
+	"ECHO":             true,
+	"TIME":             true,
+	"DBSIZE":           true,
+	"SCAN":             true,
+	"RANDOMKEY":        true,
+	"EXISTS":           true,
+	"TYPE":             true,
+	"TTL":              true,
+	"PTTL":             true,
+	"GET":              true,
+	"MGET":             true,
+	"STRLEN":           true,
+	"GETRANGE":         true,
+	"GETBIT":           true,
+	"BITCOUNT":         true,
+	"HGET":             true,
+	"HMGET":            true,
+	"HGETALL":          true,
+	"HKEYS":            true,
+	"HVALS":            true,
+	"HLEN":             true,
+	"HEXISTS":          true,
+	"HSCAN":            true,
+	"LLEN":             true,
+	"LRANGE":           true,
+	"LINDEX":           true,
+	"SCARD":            true,
+	"SISMEMBER":        true,
+	"SMEMBERS":         true,
+	"SSCAN":            true,
+	"ZCARD":            true,
+	"ZCOUNT":           true,
+	"ZSCORE":           true,
+	"ZRANK":            true,
+	"ZREVRANK":         true,
+	"ZRANGE":           true,
+	"ZREVRANGE":        true,
+	"ZRANGEBYSCORE":    true,
+	"ZREVRANGEBYSCORE": true,
+	"ZSCAN":            true,
+	"XLEN":             true,
+	"XRANGE":           true,
+	"XREVRANGE":        true,
+	"PFCOUNT":          true,
+}
+
+// readOnly reports whether the command doesn't write
+func (c *CMD) readOnly() bool {
+	return readOnlyCommands[strings.ToUpper(c.name)]
+}
+
+// idempotentCommands are the commands which leave the same data when they are applied twice, besides the read-only ones
+var idempotentCommands = map[string]bool{
+	"SET":              true,
+	"SETEX":            true,
+	"PSETEX":           true,
+	"MSET":             true,
+	"SETBIT":           true,
+	"SETRANGE":         true,
+	"DEL":              true,
+	"UNLINK":           true,
+	"EXPIRE":           true,
+	"PEXPIRE":          true,
+	"EXPIREAT":         true,
+	"PEXPIREAT":        true,
+	"PERSIST":          true,
+	"HSET":             true,
+	"HMSET":            true,
+	"HDEL":             true,
+	"SADD":             true,
+	"SREM":             true,
+	"ZADD":             true,
+	"ZREM":             true,
+	"ZREMRANGEBYSCORE": true,
+	"LSET":             true,
+	"PFADD":            true,
+	"PFMERGE":          true,
+	"XACK":             true,
+	"XDEL":             true,
+}
+
+// idempotent reports whether applying the command twice leaves the same data as applying it once,
+// SET with NX or GET and ZADD with INCR aren't, as their replies depend on the data they change
+func (c *CMD) idempotent() bool {
+	name := strings.ToUpper(c.name)
+	if readOnlyCommands[name] {
+		return true
+	}
+	if !idempotentCommands[name] {
+		return false
+	}
+
+	// the options follow the key, and the value of SET
+	skip := 1
+	if name == "SET" {
+		skip = 2
+	} else if name != "ZADD" {
+		return true
+	}
+	var options []interface{}
+	if len(c.args) > skip {
+		options = c.args[skip:]
+	}
+	for _, arg := range options {
+		switch strings.ToUpper(toKey(arg)) {
+		case "NX", "GET", "INCR":
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCronSchedule(t *testing.T) {
+	from := time.Date(2024, 2, 28, 10, 17, 30, 0, time.UTC)
+
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, 2, 28, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 2, 28, 10, 30, 0, 0, time.UTC)},
+		{"5,50 9-11 * * *", time.Date(2024, 2, 28, 10, 50, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"30 8 * * MON-FRI", time.Date(2024, 2, 29, 8, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+		// either day field matches when both are restricted
+		{"0 12 1 * SAT", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 2, 28, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.spec, func(t *testing.T) {
+			s, err := ParseCron(c.spec)
+			NewWithT(t).Expect(err).To(BeNil())
+			NewWithT(t).Expect(s.Next(from)).To(Equal(c.next))
+		})
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * FOO *"} {
+		_, err := ParseCron(spec)
+		NewWithT(t).Expect(err).NotTo(BeNil(), spec)
+	}
+}
@@ -0,0 +1,434 @@
+// Package scheduler runs delayed and cron jobs on Redis, claiming due jobs with Lua scripts.
+//
+// The test server of redistest can't run Lua, so by default the tests run Go stand-ins of claimScript and doneScript
+// and the Lua itself isn't covered. Set REDIS_ADDR to the host:port of a Redis server, where the tests flush nothing
+// but the keys they write, for TestSchedulerScripts to run the scripts there and check the stand-ins agree with them.
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+// NewScheduler creates a scheduler named name, all its keys share the hash tag {name} so they live on the same shard.
+func NewScheduler(op redis1.RedisOperator, name string) *Scheduler {
+	s := &Scheduler{
+		op:   op,
+		Name: name,
+	}
+	s.SetDefaults()
+	return s
+}
+
+// Scheduler runs jobs at a given time and cron jobs on every replica running Run for the same Name,
+// each job and each cron occurrence runs on one replica only.
+// Due jobs are claimed by a Lua script, which hides them for ClaimTimeout, and are removed once handled,
+// so a job whose handler fails or whose replica dies runs again after ClaimTimeout.
+type Scheduler struct {
+	op   redis1.RedisOperator
+	Name string
+	// PollInterval is how often due jobs and cron jobs are checked, and so how late they may run
+	PollInterval time.Duration
+	// BatchSize is the number of due jobs claimed at once
+	BatchSize int
+	// ClaimTimeout is how long a claimed job may run before it is claimed again
+	ClaimTimeout time.Duration
+	// Location is the time zone of cron expressions, time.Local by default
+	Location *time.Location
+	// OnError is called with the errors of Redis and handlers, by default they are logged
+	OnError func(id string, err error)
+
+	mu    sync.Mutex
+	crons []*cronJob
+}
+
+func (s *Scheduler) SetDefaults() {
+	if s.PollInterval == 0 {
+		s.PollInterval = time.Second
+	}
+	if s.BatchSize <= 0 {
+		s.BatchSize = 100
+	}
+	if s.ClaimTimeout == 0 {
+		s.ClaimTimeout = time.Minute
+	}
+	if s.Location == nil {
+		s.Location = time.Local
+	}
+	if s.OnError == nil {
+		s.OnError = func(id string, err error) {
+			if id != "" {
+				logrus.Errorf("scheduler %s: job %s failed: %s", s.Name, id, err)
+				return
+			}
+			logrus.Errorf("scheduler %s: %s", s.Name, err)
+		}
+	}
+}
+
+// path returns the key name before the prefix, which scripts apply
+func (s *Scheduler) path(name string) string {
+	return fmt.Sprintf("scheduler:{%s}:%s", s.Name, name)
+}
+
+func (s *Scheduler) key(name string) string {
+	return s.op.Prefix(s.path(name))
+}
+
+// dueKey is a sorted set of the job ids scored by the unix milliseconds they are due,
+// or the claim of a running job expires
+func (s *Scheduler) dueKey() string {
+	return s.key("due")
+}
+
+// jobsKey is a hash of the payloads of the jobs by id
+func (s *Scheduler) jobsKey() string {
+	return s.key("jobs")
+}
+
+func (s *Scheduler) occurrenceKey(cron string, at time.Time) string {
+	return s.key(fmt.Sprintf("cron:%s:%d", cron, toMillis(at)))
+}
+
+type Job struct {
+	ID      string
+	Payload json.RawMessage
+	// DueAt is when the job was due, or when its previous claim expired for a job running again
+	DueAt time.Time
+
+	// claim is the score of the job while it is claimed
+	claim int64
+}
+
+// Decode unmarshals the payload of the job into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Schedule stores a job due at at and returns its id, a random one when id is empty.
+// Scheduling an existing id replaces its payload and due time.
+func (s *Scheduler) Schedule(ctx context.Context, id string, at time.Time, payload interface{}) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		id = newJobID()
+	}
+
+	_, err = s.op.ExecContext(ctx,
+		redis1.Command("HSET", s.jobsKey(), id, data),
+		redis1.Command("ZADD", s.dueKey(), toMillis(at), id),
+	)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ScheduleAfter stores a job due after d, see Schedule
+func (s *Scheduler) ScheduleAfter(ctx context.Context, id string, d time.Duration, payload interface{}) (string, error) {
+	return s.Schedule(ctx, id, time.Now().Add(d), payload)
+}
+
+// Cancel removes the job and reports whether it existed, a running job isn't interrupted
+func (s *Scheduler) Cancel(ctx context.Context, id string) (bool, error) {
+	values, err := redis.Values(s.op.ExecContext(ctx,
+		redis1.Command("ZREM", s.dueKey(), id),
+		redis1.Command("HDEL", s.jobsKey(), id),
+	))
+	if err != nil {
+		return false, err
+	}
+	removed, err := redis.Int(values[1], nil)
+	return removed > 0, err
+}
+
+// Reschedule changes when the job is due and reports whether it exists.
+// A running job is kept once handled and runs again at at.
+func (s *Scheduler) Reschedule(ctx context.Context, id string, at time.Time) (bool, error) {
+	values, err := redis.Values(s.op.ExecContext(ctx,
+		redis1.Command("ZSCORE", s.dueKey(), id),
+		redis1.Command("ZADD", s.dueKey(), "XX", toMillis(at), id),
+	))
+	if err != nil {
+		return false, err
+	}
+	return values[0] != nil, nil
+}
+
+// DueAt returns when the job is due, the zero time when it doesn't exist
+func (s *Scheduler) DueAt(ctx context.Context, id string) (time.Time, error) {
+	ms, err := redis.Int64(s.op.ExecContext(ctx, redis1.Command("ZSCORE", s.dueKey(), id)))
+	if err != nil {
+		if err == redis.ErrNil {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return fromMillis(ms), nil
+}
+
+// claimScript hides up to ARGV[2] jobs due at ARGV[1] until ARGV[3] and returns them as id, due, payload, ...
+// ids without payload are left by a Cancel racing with Schedule and are removed.
+const claimScript = `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'WITHSCORES', 'LIMIT', 0, ARGV[2])
+local claimed = {}
+for i = 1, #due, 2 do
+  local payload = redis.call('HGET', KEYS[2], due[i])
+  if payload then
+    redis.call('ZADD', KEYS[1], ARGV[3], due[i])
+    table.insert(claimed, due[i])
+    table.insert(claimed, due[i + 1])
+    table.insert(claimed, payload)
+  else
+    redis.call('ZREM', KEYS[1], due[i])
+  end
+end
+return claimed
+`
+
+// doneScript removes the job ARGV[1] unless it was rescheduled or claimed again since it was claimed until ARGV[2]
+const doneScript = `
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) == tonumber(ARGV[2]) then
+  redis.call('ZREM', KEYS[1], ARGV[1])
+  redis.call('HDEL', KEYS[2], ARGV[1])
+  return 1
+end
+return 0
+`
+
+var (
+	claimLua = redis1.NewScript(2, claimScript)
+	doneLua  = redis1.NewScript(2, doneScript)
+)
+
+func (s *Scheduler) eval(ctx context.Context, script *redis1.Script, args ...interface{}) (interface{}, error) {
+	return script.Do(ctx, s.op, append([]interface{}{s.path("due"), s.path("jobs")}, args...)...)
+}
+
+// Claim returns up to n jobs due at now and hides them for ClaimTimeout, the jobs must be passed to Done once handled
+func (s *Scheduler) Claim(ctx context.Context, now time.Time, n int) ([]*Job, error) {
+	claim := toMillis(now.Add(s.ClaimTimeout))
+
+	values, err := redis.Values(s.eval(ctx, claimLua, toMillis(now), n, claim))
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%3 != 0 {
+		return nil, fmt.Errorf("scheduler: unexpected claim reply %v", values)
+	}
+
+	jobs := make([]*Job, 0, len(values)/3)
+	for i := 0; i < len(values); i += 3 {
+		id, err := redis.String(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		due, err := redis.Int64(values[i+1], nil)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := redis.Bytes(values[i+2], nil)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &Job{ID: id, Payload: payload, DueAt: fromMillis(due), claim: claim})
+	}
+	return jobs, nil
+}
+
+// Done removes a claimed job and reports whether it was removed,
+// which it isn't when it was rescheduled meanwhile or its claim expired and it was claimed again
+func (s *Scheduler) Done(ctx context.Context, j *Job) (bool, error) {
+	removed, err := redis.Int(s.eval(ctx, doneLua, j.ID, j.claim))
+	return removed == 1, err
+}
+
+type Handler func(ctx context.Context, j *Job) error
+
+// CronHandler handles the occurrence of a cron job due at at
+type CronHandler func(ctx context.Context, at time.Time) error
+
+type cronJob struct {
+	name     string
+	schedule *CronSchedule
+	handler  CronHandler
+	next     time.Time
+}
+
+// Cron adds a cron job to the jobs started by Run, name identifies it among the replicas.
+// Every occurrence runs on the replica which claims it first, occurrences passed while no replica runs are skipped.
+func (s *Scheduler) Cron(name string, spec string, handler CronHandler) error {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.crons {
+		if c.name == name {
+			return fmt.Errorf("scheduler: cron job %s already exists", name)
+		}
+	}
+	s.crons = append(s.crons, &cronJob{
+		name:     name,
+		schedule: schedule,
+		handler:  handler,
+		next:     schedule.Next(time.Now().In(s.Location)),
+	})
+	return nil
+}
+
+// Run handles due jobs with handler and runs the cron jobs until ctx is done, then waits for the running handlers.
+// handler may be nil when only cron jobs are used.
+func (s *Scheduler) Run(ctx context.Context, handler Handler) error {
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.runCrons(ctx, &wg)
+
+		if handler != nil {
+			s.runDue(ctx, handler)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue handles the due jobs concurrently, batch after batch until none is due
+func (s *Scheduler) runDue(ctx context.Context, handler Handler) {
+	for ctx.Err() == nil {
+		jobs, err := s.Claim(ctx, time.Now(), s.BatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.OnError("", err)
+			}
+			return
+		}
+
+		wg := sync.WaitGroup{}
+		for _, j := range jobs {
+			wg.Add(1)
+			go func(j *Job) {
+				defer wg.Done()
+
+				if err := call(func() error { return handler(ctx, j) }); err != nil {
+					// claimed again once the claim expires
+					s.OnError(j.ID, err)
+					return
+				}
+				// removed even when ctx is done, as the job was handled
+				if _, err := s.Done(context.Background(), j); err != nil {
+					s.OnError(j.ID, err)
+				}
+			}(j)
+		}
+		wg.Wait()
+
+		if len(jobs) < s.BatchSize {
+			return
+		}
+	}
+}
+
+// runCrons starts the cron occurrences which are due and claimed by this replica
+func (s *Scheduler) runCrons(ctx context.Context, wg *sync.WaitGroup) {
+	now := time.Now().In(s.Location)
+
+	s.mu.Lock()
+	due := make(map[*cronJob]time.Time)
+	for _, c := range s.crons {
+		if c.next.IsZero() || c.next.After(now) {
+			continue
+		}
+		due[c] = c.next
+		// missed occurrences are skipped
+		c.next = c.schedule.Next(now)
+	}
+	s.mu.Unlock()
+
+	for c, at := range due {
+		claimed, err := s.claimOccurrence(ctx, c, at)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.OnError(c.name, err)
+			}
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		wg.Add(1)
+		go func(c *cronJob, at time.Time) {
+			defer wg.Done()
+			if err := call(func() error { return c.handler(ctx, at) }); err != nil {
+				s.OnError(c.name, err)
+			}
+		}(c, at)
+	}
+}
+
+// claimOccurrence reports whether this replica is the first one to claim the occurrence at of the cron job.
+// The claim outlives the interval to the next occurrence, so replicas whose clocks lag behind don't run it again.
+func (s *Scheduler) claimOccurrence(ctx context.Context, c *cronJob, at time.Time) (bool, error) {
+	ttl := time.Minute
+	if next := c.schedule.Next(at); !next.IsZero() && 2*next.Sub(at) > ttl {
+		ttl = 2 * next.Sub(at)
+	}
+
+	_, err := redis.String(s.op.ExecContext(ctx, redis1.Command(
+		"SET", s.occurrenceKey(c.name, at), 1, "NX", "PX", int64(ttl/time.Millisecond),
+	)))
+	if err != nil {
+		if err == redis.ErrNil {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func call(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,344 @@
+package scheduler
+
+import (
+	"context"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+var server = redistest.NewServer()
+
+var r = &redis.Redis{
+	Host: server.Host(),
+	Port: server.Port(),
+}
+
+func init() {
+	r.SetDefaults()
+	r.Init()
+
+	// the test server can't run Lua, these do what the scripts do, TestSchedulerScripts checks they agree on a Redis server
+	redistest.RegisterScript(claimScript, func(call func(name string, args ...interface{}) interface{}, keys []string, args [][]byte) interface{} {
+		due := call("ZRANGEBYSCORE", keys[0], "-inf", args[0], "WITHSCORES", "LIMIT", 0, args[1]).([]interface{})
+		claimed := make([]interface{}, 0)
+		for i := 0; i < len(due); i += 2 {
+			payload := call("HGET", keys[1], due[i])
+			if payload != nil {
+				call("ZADD", keys[0], args[2], due[i])
+				claimed = append(claimed, due[i], due[i+1], payload)
+			} else {
+				call("ZREM", keys[0], due[i])
+			}
+		}
+		return claimed
+	})
+
+	redistest.RegisterScript(doneScript, func(call func(name string, args ...interface{}) interface{}, keys []string, args [][]byte) interface{} {
+		score, ok := call("ZSCORE", keys[0], args[0]).([]byte)
+		if !ok {
+			return 0
+		}
+		s, _ := strconv.ParseFloat(string(score), 64)
+		claim, _ := strconv.ParseFloat(string(args[1]), 64)
+		if s != claim {
+			return 0
+		}
+		call("ZREM", keys[0], args[0])
+		call("HDEL", keys[1], args[0])
+		return 1
+	})
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+
+	s := NewScheduler(r, "test")
+
+	t.Run("claim and done", func(t *testing.T) {
+		now := time.Now()
+
+		id, err := s.Schedule(ctx, "", now.Add(-time.Second), "past")
+		NewWithT(t).Expect(err).To(BeNil())
+		_, err = s.Schedule(ctx, "future", now.Add(time.Hour), "future")
+		NewWithT(t).Expect(err).To(BeNil())
+
+		jobs, err := s.Claim(ctx, now, 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(jobs).To(HaveLen(1))
+		NewWithT(t).Expect(jobs[0].ID).To(Equal(id))
+		NewWithT(t).Expect(jobs[0].DueAt.Unix()).To(Equal(now.Add(-time.Second).Unix()))
+
+		v := ""
+		NewWithT(t).Expect(jobs[0].Decode(&v)).To(BeNil())
+		NewWithT(t).Expect(v).To(Equal("past"))
+
+		// hidden while claimed
+		again, err := s.Claim(ctx, now, 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(again).To(BeEmpty())
+
+		done, err := s.Done(ctx, jobs[0])
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(done).To(BeTrue())
+
+		dueAt, err := s.DueAt(ctx, id)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(dueAt.IsZero()).To(BeTrue())
+
+		dueAt, err = s.DueAt(ctx, "future")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(dueAt.Unix()).To(Equal(now.Add(time.Hour).Unix()))
+
+		ok, err := s.Cancel(ctx, "future")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(ok).To(BeTrue())
+	})
+
+	t.Run("claimed again after claim timeout", func(t *testing.T) {
+		now := time.Now()
+
+		_, err := s.Schedule(ctx, "retry", now, "retry")
+		NewWithT(t).Expect(err).To(BeNil())
+
+		first, err := s.Claim(ctx, now, 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(first).To(HaveLen(1))
+
+		second, err := s.Claim(ctx, now.Add(s.ClaimTimeout), 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(second).To(HaveLen(1))
+
+		// the first claim expired, so the job belongs to the second one
+		done, err := s.Done(ctx, first[0])
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(done).To(BeFalse())
+
+		done, err = s.Done(ctx, second[0])
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(done).To(BeTrue())
+	})
+
+	t.Run("cancel and reschedule", func(t *testing.T) {
+		now := time.Now()
+
+		_, err := s.Schedule(ctx, "job", now.Add(time.Hour), "job")
+		NewWithT(t).Expect(err).To(BeNil())
+
+		ok, err := s.Reschedule(ctx, "job", now.Add(-time.Second))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(ok).To(BeTrue())
+
+		ok, err = s.Reschedule(ctx, "missing", now)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(ok).To(BeFalse())
+
+		jobs, err := s.Claim(ctx, now, 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(jobs).To(HaveLen(1))
+
+		// rescheduled while running, so kept for the next run
+		ok, err = s.Reschedule(ctx, "job", now.Add(time.Hour))
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(ok).To(BeTrue())
+
+		done, err := s.Done(ctx, jobs[0])
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(done).To(BeFalse())
+
+		ok, err = s.Cancel(ctx, "job")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(ok).To(BeTrue())
+
+		ok, err = s.Cancel(ctx, "job")
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(ok).To(BeFalse())
+
+		jobs, err = s.Claim(ctx, now.Add(2*time.Hour), 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(jobs).To(BeEmpty())
+	})
+}
+
+func TestSchedulerReplicas(t *testing.T) {
+	server.FlushAll()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mu := sync.Mutex{}
+	runs := map[string]int{}
+	handler := func(ctx context.Context, j *Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs[j.ID]++
+		return nil
+	}
+
+	replicas := make([]*Scheduler, 3)
+	for i := range replicas {
+		replicas[i] = NewScheduler(r, "replicas")
+		replicas[i].PollInterval = 10 * time.Millisecond
+		replicas[i].BatchSize = 5
+	}
+
+	for i := 0; i < 50; i++ {
+		_, err := replicas[0].ScheduleAfter(ctx, "", time.Duration(i)*time.Millisecond, i)
+		NewWithT(t).Expect(err).To(BeNil())
+	}
+
+	wg := sync.WaitGroup{}
+	for _, s := range replicas {
+		wg.Add(1)
+		go func(s *Scheduler) {
+			defer wg.Done()
+			_ = s.Run(ctx, handler)
+		}(s)
+	}
+
+	NewWithT(t).Eventually(func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(runs)
+	}, time.Second, 10*time.Millisecond).Should(Equal(50))
+
+	cancel()
+	wg.Wait()
+
+	for id, n := range runs {
+		NewWithT(t).Expect(n).To(Equal(1), id)
+	}
+}
+
+func TestSchedulerCron(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+
+	mu := sync.Mutex{}
+	runs := map[time.Time]int{}
+
+	replicas := make([]*Scheduler, 3)
+	for i := range replicas {
+		replicas[i] = NewScheduler(r, "cron")
+		err := replicas[i].Cron("minutely", "* * * * *", func(ctx context.Context, at time.Time) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[at]++
+			return nil
+		})
+		NewWithT(t).Expect(err).To(BeNil())
+
+		NewWithT(t).Expect(replicas[i].Cron("minutely", "@hourly", nil)).NotTo(BeNil())
+	}
+
+	at := time.Now().Truncate(time.Minute)
+	for _, s := range replicas {
+		s.crons[0].next = at
+	}
+
+	wg := sync.WaitGroup{}
+	for _, s := range replicas {
+		s.runCrons(ctx, &wg)
+	}
+	wg.Wait()
+
+	NewWithT(t).Expect(runs).To(HaveLen(1))
+	NewWithT(t).Expect(runs[at]).To(Equal(1))
+
+	for _, s := range replicas {
+		NewWithT(t).Expect(s.crons[0].next).To(BeTemporally("==", at.Add(time.Minute)))
+	}
+}
+
+// TestSchedulerScripts runs the Lua scripts on the Redis at REDIS_ADDR and the stand-ins of init on the test server,
+// which can't run Lua, and checks that both return the same replies and leave the same jobs
+func TestSchedulerScripts(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR isn't set, the Lua scripts need a Redis server, only their stand-ins run, see the package documentation")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	NewWithT(t).Expect(err).To(BeNil())
+	p, err := strconv.Atoi(port)
+	NewWithT(t).Expect(err).To(BeNil())
+
+	lua := &redis.Redis{Host: host, Port: p}
+	lua.SetDefaults()
+	lua.Init()
+	if _, err := lua.Exec(redis.Command("PING")); err != nil {
+		t.Skipf("no Redis at %s: %s", addr, err)
+	}
+
+	server.FlushAll()
+
+	traces := map[string][]interface{}{}
+	for name, op := range map[string]redis.RedisOperator{"lua": lua, "stand-in": r} {
+		traces[name] = scriptsTrace(t, NewScheduler(op, "scripts"))
+	}
+	NewWithT(t).Expect(traces["lua"]).To(Equal(traces["stand-in"]))
+}
+
+// scriptsTrace claims and completes jobs with the scripts and returns what they replied and left
+func scriptsTrace(t *testing.T, s *Scheduler) []interface{} {
+	ctx := context.Background()
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := s.op.Exec(redis.Command("DEL", s.dueKey(), s.jobsKey()))
+	NewWithT(t).Expect(err).To(BeNil())
+	defer s.op.Exec(redis.Command("DEL", s.dueKey(), s.jobsKey()))
+
+	for id, at := range map[string]time.Time{
+		"a": now.Add(-2 * time.Second),
+		"b": now.Add(-time.Second),
+		"c": now.Add(time.Hour),
+		"d": now.Add(-3 * time.Second),
+	} {
+		_, err := s.Schedule(ctx, id, at, id)
+		NewWithT(t).Expect(err).To(BeNil())
+	}
+	// an id without payload, as a Cancel racing with Schedule leaves
+	_, err = s.op.Exec(redis.Command("HDEL", s.jobsKey(), "d"))
+	NewWithT(t).Expect(err).To(BeNil())
+
+	trace := []interface{}{}
+	claim := func(at time.Time, n int) []*Job {
+		jobs, err := s.Claim(ctx, at, n)
+		NewWithT(t).Expect(err).To(BeNil())
+		for _, j := range jobs {
+			trace = append(trace, j.ID, toMillis(j.DueAt), string(j.Payload), j.claim)
+		}
+		trace = append(trace, len(jobs))
+		return jobs
+	}
+	done := func(j *Job) {
+		removed, err := s.Done(ctx, j)
+		NewWithT(t).Expect(err).To(BeNil())
+		trace = append(trace, removed)
+	}
+
+	jobs := claim(now, 10)
+	NewWithT(t).Expect(jobs).To(HaveLen(2))
+	claim(now, 10)
+
+	done(jobs[0])
+	_, err = s.Reschedule(ctx, jobs[1].ID, now)
+	NewWithT(t).Expect(err).To(BeNil())
+	done(jobs[1])
+
+	claim(now.Add(s.ClaimTimeout), 1)
+
+	due, ids := []string{}, []string{}
+	NewWithT(t).Expect(redis.ExecResult(ctx, s.op, redis.Command("ZRANGE", s.dueKey(), 0, -1, "WITHSCORES"), redis.Command("HKEYS", s.jobsKey())).Scan(&due, &ids)).To(BeNil())
+	sort.Strings(ids)
+
+	return append(trace, due, ids)
+}
@@ -0,0 +1,180 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Handler func(ctx context.Context, e *Entry) error
+
+func NewConsumer(s *Stream, group string, name string, handler Handler) *Consumer {
+	c := &Consumer{
+		Stream:  s,
+		Group:   group,
+		Name:    name,
+		Handler: handler,
+	}
+	c.SetDefaults()
+	return c
+}
+
+// Consumer reads the entries of Stream as the consumer Name of Group and runs Handler for each of them.
+// An entry is acked when Handler returns nil, otherwise it stays pending and is retried once it was idle for ClaimMinIdle.
+// Entries left pending by dead consumers of the group are claimed the same way.
+type Consumer struct {
+	Stream  *Stream
+	Group   string
+	Name    string
+	Handler Handler
+	// Start is where a new group starts reading, $ for new entries only, 0 for all entries
+	Start string
+	// Count is the number of entries read at once
+	Count int
+	// Block bounds how long a read waits for new entries, and so how long Run takes to return once its context is done.
+	// It must be less than the read timeout of the connections.
+	Block time.Duration
+	// ClaimMinIdle is how long an entry stays pending before it is claimed, ClaimMinIdle < 0 disables claiming
+	ClaimMinIdle time.Duration
+	// ClaimInterval is how often pending entries are claimed, half of ClaimMinIdle when 0
+	ClaimInterval time.Duration
+	// OnError is called with the errors of the stream and of Handler, by default they are logged
+	OnError func(e *Entry, err error)
+}
+
+func (c *Consumer) SetDefaults() {
+	if c.Start == "" {
+		c.Start = "$"
+	}
+	if c.Count <= 0 {
+		c.Count = 10
+	}
+	if c.Block == 0 {
+		c.Block = time.Second
+	}
+	if c.ClaimMinIdle == 0 {
+		c.ClaimMinIdle = time.Minute
+	}
+	if c.OnError == nil {
+		c.OnError = func(e *Entry, err error) {
+			if e != nil {
+				logrus.Errorf("stream %s: group %s: entry %s failed: %s", c.Stream.Name, c.Group, e.ID, err)
+				return
+			}
+			logrus.Errorf("stream %s: group %s: %s", c.Stream.Name, c.Group, err)
+		}
+	}
+}
+
+// Run creates the group when missing and handles entries until ctx is done.
+// The entries left pending for Name by a previous run are handled first.
+// Entries are handled one by one, run consumers with different names for concurrency.
+func (c *Consumer) Run(ctx context.Context) error {
+	if err := c.Stream.CreateGroup(ctx, c.Group, c.Start); err != nil {
+		return err
+	}
+
+	if err := c.recover(ctx); err != nil {
+		return err
+	}
+
+	claimInterval := c.ClaimInterval
+	if claimInterval == 0 {
+		claimInterval = c.ClaimMinIdle / 2
+	}
+	lastClaim := time.Time{}
+	cursor := "0-0"
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		if c.ClaimMinIdle >= 0 && time.Since(lastClaim) >= claimInterval {
+			next, entries, err := c.Stream.Claim(ctx, c.Group, c.Name, c.ClaimMinIdle, cursor, c.Count)
+			if err != nil {
+				c.fail(ctx, err)
+				continue
+			}
+			c.handle(ctx, entries)
+			cursor = next
+			// the whole pending list was scanned
+			if cursor == "0-0" {
+				lastClaim = time.Now()
+			}
+			continue
+		}
+
+		entries, err := c.Stream.ReadGroup(ctx, c.Group, c.Name, c.Count, c.Block)
+		if err != nil {
+			c.fail(ctx, err)
+			continue
+		}
+		c.handle(ctx, entries)
+	}
+}
+
+// recover handles the entries still pending for the consumer, for example after a crash
+func (c *Consumer) recover(ctx context.Context) error {
+	after := "0"
+	for {
+		entries, err := c.Stream.Pending(ctx, c.Group, c.Name, after, c.Count)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		c.handle(ctx, entries)
+		after = entries[len(entries)-1].ID
+	}
+}
+
+func (c *Consumer) fail(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	c.OnError(nil, err)
+	// avoid spinning while Redis is down
+	select {
+	case <-ctx.Done():
+	case <-time.After(c.Block):
+	}
+}
+
+func (c *Consumer) handle(ctx context.Context, entries []Entry) {
+	for i := range entries {
+		// the rest stays pending for the next run
+		if ctx.Err() != nil {
+			return
+		}
+
+		e := &entries[i]
+
+		// entries deleted from the stream while pending can only be acked
+		if !e.Deleted {
+			if err := c.call(ctx, e); err != nil {
+				c.OnError(e, err)
+				continue
+			}
+		}
+
+		// acked even when ctx is done, as the entry was handled
+		if _, err := c.Stream.Ack(context.Background(), c.Group, e.ID); err != nil {
+			c.OnError(e, err)
+		}
+	}
+}
+
+func (c *Consumer) call(ctx context.Context, e *Entry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.Handler(ctx, e)
+}
@@ -0,0 +1,138 @@
+package streams
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-courier/reflectx"
+	"github.com/zj-open-source/helper/internal/fields"
+)
+
+// marshalFields flattens v into the field value pairs of an entry.
+// v is a map with string keys or a struct, whose fields are named by the tag `redis:"name,omitempty"`
+// and by the field name without it. Scalars and text marshalers are stored as text, other values as JSON.
+func marshalFields(v interface{}) ([]interface{}, error) {
+	rv := reflectx.Indirect(reflect.ValueOf(v))
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("streams: map key of %T must be string", v)
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+
+		args := make([]interface{}, 0, len(keys)*2)
+		for _, k := range keys {
+			b, err := marshalValue(rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())))
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, k, b)
+		}
+		return args, nil
+
+	case reflect.Struct:
+		args := make([]interface{}, 0, rv.NumField()*2)
+		err := rangeFields(rv, func(name string, omitempty bool, fv reflect.Value) error {
+			if omitempty && reflectx.IsEmptyValue(fv) {
+				return nil
+			}
+			b, err := marshalValue(fv)
+			if err != nil {
+				return fmt.Errorf("streams: field %s: %w", name, err)
+			}
+			args = append(args, name, b)
+			return nil
+		})
+		return args, err
+	}
+
+	return nil, fmt.Errorf("streams: unsupported type %T, want struct or map", v)
+}
+
+// unmarshalFields sets the fields of the struct or map v points to, fields of the entry missing in v are skipped
+func unmarshalFields(entry map[string]string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("streams: decode into non-pointer %T", v)
+	}
+	rv = reflectx.Indirect(rv)
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("streams: map key of %T must be string", v)
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		for k, s := range entry {
+			item := reflect.New(rv.Type().Elem())
+			if err := unmarshalValue(item.Elem(), s); err != nil {
+				return fmt.Errorf("streams: field %s: %w", k, err)
+			}
+			rv.SetMapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()), item.Elem())
+		}
+		return nil
+
+	case reflect.Struct:
+		return rangeFields(rv, func(name string, omitempty bool, fv reflect.Value) error {
+			s, ok := entry[name]
+			if !ok {
+				return nil
+			}
+			if err := unmarshalValue(fv, s); err != nil {
+				return fmt.Errorf("streams: field %s: %w", name, err)
+			}
+			return nil
+		})
+	}
+
+	return fmt.Errorf("streams: unsupported type %T, want pointer to struct or map", v)
+}
+
+func rangeFields(rv reflect.Value, fn func(name string, omitempty bool, fv reflect.Value) error) error {
+	for _, f := range fields.Of(rv.Type()) {
+		if err := fn(f.Name, f.OmitEmpty, rv.Field(f.Index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalValue(rv reflect.Value) ([]byte, error) {
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return []byte{}, nil
+	}
+	if fields.IsText(rv.Type()) {
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return []byte{}, nil
+		}
+		return reflectx.MarshalText(rv)
+	}
+	return json.Marshal(rv.Interface())
+}
+
+func unmarshalValue(rv reflect.Value, s string) error {
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		rv.Set(reflect.ValueOf(s))
+		return nil
+	}
+	if fields.IsText(rv.Type()) {
+		if rv.Kind() == reflect.Slice {
+			rv.SetBytes([]byte(s))
+			return nil
+		}
+		return reflectx.UnmarshalText(rv, []byte(s))
+	}
+	return json.Unmarshal([]byte(s), rv.Addr().Interface())
+}
@@ -0,0 +1,218 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	redis1 "github.com/zj-open-source/helper/redis"
+)
+
+func NewStream(op redis1.RedisOperator, name string) *Stream {
+	return &Stream{
+		op:   op,
+		Name: name,
+	}
+}
+
+// Stream publishes to and reads from the Redis stream Name, the key is prefixed by the operator
+type Stream struct {
+	op   redis1.RedisOperator
+	Name string
+	// MaxLen trims the stream to about MaxLen entries on every Add, MaxLen <= 0 keeps all entries
+	MaxLen int64
+	// ExactMaxLen trims to exactly MaxLen entries, which is slower than the default approximate trimming
+	ExactMaxLen bool
+}
+
+func (s *Stream) key() string {
+	return s.op.Prefix(s.Name)
+}
+
+type Entry struct {
+	ID     string
+	Fields map[string]string
+	// Deleted reports a pending entry which was deleted from the stream, it has no fields
+	Deleted bool
+}
+
+// Decode sets the struct or map v points to from the fields of the entry, see Add for the field names
+func (e *Entry) Decode(v interface{}) error {
+	return unmarshalFields(e.Fields, v)
+}
+
+// Add appends v to the stream and returns the id of the entry.
+// v is a map with string keys or a struct, whose fields are named by the tag `redis:"name,omitempty"`.
+func (s *Stream) Add(ctx context.Context, v interface{}) (string, error) {
+	fields, err := marshalFields(v)
+	if err != nil {
+		return "", err
+	}
+	if len(fields) == 0 {
+		return "", fmt.Errorf("streams: %T has no fields to add", v)
+	}
+
+	args := []interface{}{s.key()}
+	if s.MaxLen > 0 {
+		if s.ExactMaxLen {
+			args = append(args, "MAXLEN", s.MaxLen)
+		} else {
+			args = append(args, "MAXLEN", "~", s.MaxLen)
+		}
+	}
+	args = append(args, "*")
+	args = append(args, fields...)
+
+	return redis.String(s.op.ExecContext(ctx, redis1.Command("XADD", args...)))
+}
+
+func (s *Stream) Len(ctx context.Context) (int, error) {
+	return redis.Int(s.op.ExecContext(ctx, redis1.Command("XLEN", s.key())))
+}
+
+// Range returns up to count entries with ids from start to end, which may be - and + for the first and last entry
+func (s *Stream) Range(ctx context.Context, start string, end string, count int) ([]Entry, error) {
+	return parseEntries(s.op.ExecContext(ctx, redis1.Command("XRANGE", s.key(), start, end, "COUNT", count)))
+}
+
+// CreateGroup creates the consumer group, which reads the entries after start, $ for new entries only, 0 for all entries.
+// The stream is created when missing and an existing group is left as it is.
+func (s *Stream) CreateGroup(ctx context.Context, group string, start string) error {
+	_, err := s.op.ExecContext(ctx, redis1.Command("XGROUP", "CREATE", s.key(), group, start, "MKSTREAM"))
+	if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// ReadGroup reads up to count entries never delivered to the group and makes them pending for consumer.
+// It waits up to block for new entries, block <= 0 doesn't wait. Without entries it returns nil.
+func (s *Stream) ReadGroup(ctx context.Context, group string, consumer string, count int, block time.Duration) ([]Entry, error) {
+	args := []interface{}{"GROUP", group, consumer, "COUNT", count}
+	if block > 0 {
+		args = append(args, "BLOCK", int64((block+time.Millisecond-1)/time.Millisecond))
+	}
+	args = append(args, "STREAMS", s.key(), ">")
+	return parseReadReply(s.op.ExecContext(ctx, redis1.Command("XREADGROUP", args...)))
+}
+
+// Pending returns up to count entries pending for consumer after the id after, which is 0 to start from the first one
+func (s *Stream) Pending(ctx context.Context, group string, consumer string, after string, count int) ([]Entry, error) {
+	return parseReadReply(s.op.ExecContext(ctx, redis1.Command("XREADGROUP", "GROUP", group, consumer, "COUNT", count, "STREAMS", s.key(), after)))
+}
+
+// Ack removes the entries from the pending entries of the group and returns how many were pending
+func (s *Stream) Ack(ctx context.Context, group string, ids ...string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := []interface{}{s.key(), group}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return redis.Int(s.op.ExecContext(ctx, redis1.Command("XACK", args...)))
+}
+
+// Claim transfers to consumer up to count entries of the group pending for at least minIdle, from the id start.
+// It returns the id to continue from, 0-0 once all the pending entries were scanned,
+// entries deleted from the stream are returned with Deleted set and are no longer pending.
+func (s *Stream) Claim(ctx context.Context, group string, consumer string, minIdle time.Duration, start string, count int) (string, []Entry, error) {
+	values, err := redis.Values(s.op.ExecContext(ctx, redis1.Command(
+		"XAUTOCLAIM", s.key(), group, consumer, int64(minIdle/time.Millisecond), start, "COUNT", count,
+	)))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(values) < 2 {
+		return "", nil, fmt.Errorf("streams: unexpected XAUTOCLAIM reply %v", values)
+	}
+
+	next, err := redis.String(values[0], nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	entries, err := parseEntries(values[1], nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// since Redis 7 the ids deleted from the stream are returned too
+	if len(values) > 2 {
+		deleted, err := redis.Strings(values[2], nil)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, id := range deleted {
+			entries = append(entries, Entry{ID: id, Deleted: true})
+		}
+	}
+
+	return next, entries, nil
+}
+
+// parseReadReply parses the reply of XREADGROUP for a single stream
+func parseReadReply(reply interface{}, err error) ([]Entry, error) {
+	streams, err := redis.Values(reply, err)
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entries := make([]Entry, 0)
+	for _, st := range streams {
+		values, err := redis.Values(st, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 2 {
+			return nil, fmt.Errorf("streams: unexpected XREADGROUP reply %v", values)
+		}
+		found, err := parseEntries(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	return entries, nil
+}
+
+// parseEntries parses a list of [id, [field, value, ...]], entries with nil fields were deleted
+func parseEntries(reply interface{}, err error) ([]Entry, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(values))
+	for _, v := range values {
+		pair, err := redis.Values(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("streams: unexpected entry %v", pair)
+		}
+
+		id, err := redis.String(pair[0], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if pair[1] == nil {
+			entries = append(entries, Entry{ID: id, Deleted: true})
+			continue
+		}
+
+		fields, err := redis.StringMap(pair[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{ID: id, Fields: fields})
+	}
+	return entries, nil
+}
@@ -0,0 +1,205 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/zj-open-source/helper/redis"
+	"github.com/zj-open-source/helper/redis/redistest"
+)
+
+var server = redistest.NewServer()
+
+var r = &redis.Redis{
+	Host: server.Host(),
+	Port: server.Port(),
+}
+
+func init() {
+	r.SetDefaults()
+	r.Init()
+}
+
+type Event struct {
+	Name     string            `redis:"name"`
+	Count    int               `redis:"count"`
+	At       time.Time         `redis:"at"`
+	Labels   map[string]string `redis:"labels,omitempty"`
+	Internal string            `redis:"-"`
+}
+
+func TestStream(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+	s := NewStream(r, "events")
+	s.MaxLen = 3
+
+	at := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("add and range", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			_, err := s.Add(ctx, Event{Name: "created", Count: i, At: at, Labels: map[string]string{"k": "v"}, Internal: "x"})
+			NewWithT(t).Expect(err).To(BeNil())
+		}
+		NewWithT(t).Expect(s.Len(ctx)).To(Equal(3))
+
+		entries, err := s.Range(ctx, "-", "+", 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(entries).To(HaveLen(3))
+		NewWithT(t).Expect(entries[0].Fields).To(Equal(map[string]string{
+			"name":   "created",
+			"count":  "2",
+			"at":     "2022-01-01T00:00:00Z",
+			"labels": `{"k":"v"}`,
+		}))
+
+		e := Event{}
+		NewWithT(t).Expect(entries[2].Decode(&e)).To(BeNil())
+		NewWithT(t).Expect(e).To(Equal(Event{Name: "created", Count: 4, At: at, Labels: map[string]string{"k": "v"}}))
+
+		m := map[string]string{}
+		NewWithT(t).Expect(entries[2].Decode(&m)).To(BeNil())
+		NewWithT(t).Expect(m["count"]).To(Equal("4"))
+	})
+
+	t.Run("group", func(t *testing.T) {
+		NewWithT(t).Expect(s.CreateGroup(ctx, "g", "0")).To(BeNil())
+		NewWithT(t).Expect(s.CreateGroup(ctx, "g", "0")).To(BeNil())
+
+		entries, err := s.ReadGroup(ctx, "g", "a", 2, 0)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(entries).To(HaveLen(2))
+
+		pending, err := s.Pending(ctx, "g", "a", "0", 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(pending).To(Equal(entries))
+
+		NewWithT(t).Expect(s.Ack(ctx, "g", entries[0].ID)).To(Equal(1))
+
+		server.FastForward(time.Minute)
+		next, claimed, err := s.Claim(ctx, "g", "b", time.Second, "0-0", 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(next).To(Equal("0-0"))
+		NewWithT(t).Expect(claimed).To(Equal(entries[1:]))
+
+		entries, err = s.ReadGroup(ctx, "g", "a", 10, 10*time.Millisecond)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(entries).To(HaveLen(1))
+
+		entries, err = s.ReadGroup(ctx, "g", "a", 10, 10*time.Millisecond)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(entries).To(BeNil())
+	})
+}
+
+func TestConsumer(t *testing.T) {
+	ctx := context.Background()
+	server.FlushAll()
+	s := NewStream(r, "consumer")
+
+	mu := sync.Mutex{}
+	handled := map[int]int{}
+	done := make(chan struct{})
+
+	handler := func(name string) Handler {
+		return func(ctx context.Context, e *Entry) error {
+			ev := Event{}
+			if err := e.Decode(&ev); err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			handled[ev.Count]++
+			// fails once, and is claimed later by one of the consumers
+			if ev.Count == 0 && handled[ev.Count] == 1 {
+				return errors.New("retry")
+			}
+			if len(handled) == 10 && handled[0] == 2 {
+				close(done)
+			}
+			return nil
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	wg := sync.WaitGroup{}
+
+	for _, name := range []string{"a", "b"} {
+		c := NewConsumer(s, "workers", name, handler(name))
+		c.Start = "0"
+		c.Block = 10 * time.Millisecond
+		c.ClaimMinIdle = 20 * time.Millisecond
+		c.OnError = func(e *Entry, err error) {}
+
+		NewWithT(t).Expect(s.CreateGroup(ctx, c.Group, c.Start)).To(BeNil())
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewWithT(t).Expect(c.Run(runCtx)).To(BeNil())
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		_, err := s.Add(ctx, Event{Name: "job", Count: i})
+		NewWithT(t).Expect(err).To(BeNil())
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("entries not handled")
+	}
+
+	cancel()
+	wg.Wait()
+
+	for i := 1; i < 10; i++ {
+		NewWithT(t).Expect(handled[i]).To(Equal(1))
+	}
+}
+
+func TestStreamSharded(t *testing.T) {
+	ctx := context.Background()
+
+	operators := map[string]redis.RedisOperator{}
+	for _, name := range []string{"a", "b"} {
+		s := redistest.NewServer()
+		defer s.Close()
+
+		op := &redis.Redis{Host: s.Host(), Port: s.Port()}
+		op.SetDefaults()
+		op.Init()
+		operators[name] = op
+	}
+	sharded := redis.NewShardedRedis(operators, 0)
+
+	// the groups of the streams are on the shards of their streams
+	shards := map[redis.RedisOperator]bool{}
+	for i := 0; i < 20; i++ {
+		s := NewStream(sharded, fmt.Sprint("stream", i))
+		shards[sharded.Shard(s.key())] = true
+		NewWithT(t).Expect(s.CreateGroup(ctx, "g", "0")).To(BeNil())
+
+		id, err := s.Add(ctx, Event{Name: s.Name})
+		NewWithT(t).Expect(err).To(BeNil())
+
+		entries, err := s.ReadGroup(ctx, "g", "a", 10, 0)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(entries).To(HaveLen(1))
+		NewWithT(t).Expect(entries[0].ID).To(Equal(id))
+
+		pending, err := s.Pending(ctx, "g", "a", "0", 10)
+		NewWithT(t).Expect(err).To(BeNil())
+		NewWithT(t).Expect(pending).To(Equal(entries))
+		NewWithT(t).Expect(s.Ack(ctx, "g", id)).To(Equal(1))
+	}
+	NewWithT(t).Expect(shards).To(HaveLen(2))
+}